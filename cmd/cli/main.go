@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"log"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/jessevdk/go-flags"
+	"github.com/xmtp/xmtpd/pkg/config"
+	"github.com/xmtp/xmtpd/pkg/registry"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+var options config.AdminOptions
+
+func main() {
+	parser := flags.NewParser(&options, flags.Default)
+	if _, err := parser.Parse(); err != nil {
+		if err, ok := err.(*flags.Error); !ok || err.Type != flags.ErrHelp {
+			fatal("Could not parse options: %s", err)
+		}
+		return
+	}
+
+	logger, err := buildLogger(options)
+	if err != nil {
+		fatal("Could not build logger: %s", err)
+	}
+
+	ctx := context.Background()
+	client, err := ethclient.DialContext(ctx, options.RpcUrl)
+	if err != nil {
+		logger.Fatal("connecting to blockchain", zap.Error(err))
+	}
+	admin, err := registry.NewNodeAdmin(
+		ctx,
+		client,
+		logger,
+		options.NodesContractAddress,
+		options.PrivateKey,
+		options.DryRun,
+	)
+	if err != nil {
+		logger.Fatal("initializing node admin", zap.Error(err))
+	}
+
+	switch parser.Active.Name {
+	case "register-node":
+		if !common.IsHexAddress(options.RegisterNode.OwnerAddress) {
+			logger.Fatal("invalid owner address")
+		}
+		signingKeyPub, err := hex.DecodeString(
+			strings.TrimPrefix(options.RegisterNode.SigningKeyPub, "0x"),
+		)
+		if err != nil {
+			logger.Fatal("decoding signing key", zap.Error(err))
+		}
+		err = admin.AddNode(
+			common.HexToAddress(options.RegisterNode.OwnerAddress),
+			signingKeyPub,
+			options.RegisterNode.HttpAddress,
+		)
+	case "update-http-address":
+		err = admin.UpdateHttpAddress(
+			options.UpdateHttpAddress.NodeID,
+			options.UpdateHttpAddress.HttpAddress,
+		)
+	case "set-healthy":
+		err = admin.UpdateHealth(options.SetHealthy.NodeID, !options.SetHealthy.Unhealthy)
+	}
+	if err != nil {
+		logger.Fatal(parser.Active.Name, zap.Error(err))
+	}
+}
+
+func fatal(msg string, args ...any) {
+	log.Fatalf(msg, args...)
+}
+
+func buildLogger(options config.AdminOptions) (*zap.Logger, error) {
+	level := zapcore.InfoLevel
+	if err := level.Set(options.LogLevel); err != nil {
+		return nil, err
+	}
+
+	cfg := zap.NewDevelopmentConfig()
+	cfg.Level = zap.NewAtomicLevelAt(level)
+	cfg.DisableStacktrace = true
+	log, err := cfg.Build()
+	if err != nil {
+		return nil, err
+	}
+
+	return log.Named("cli"), nil
+}
@@ -7,6 +7,7 @@ import (
 	"os/signal"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/jessevdk/go-flags"
 	"github.com/xmtp/xmtpd/pkg/config"
@@ -23,15 +24,15 @@ var Commit string
 var options config.ServerOptions
 
 func main() {
-	if _, err := flags.Parse(&options); err != nil {
+	var err error
+	if options, err = loadOptions(); err != nil {
 		if err, ok := err.(*flags.Error); !ok || err.Type != flags.ErrHelp {
 			fatal("Could not parse options: %s", err)
 		}
 		return
 	}
-	addEnvVars()
 
-	log, _, err := buildLogger(options)
+	log, logConfig, err := buildLogger(options)
 	if err != nil {
 		fatal("Could not build logger: %s", err)
 	}
@@ -51,23 +52,48 @@ func main() {
 			log.Fatal("initializing database", zap.Error(err))
 		}
 
+		var nodeRegistry registry.NodeRegistry = registry.NewFixedNodeRegistry([]registry.Node{})
+		if len(options.Contracts.RpcUrls) > 0 {
+			contractRegistry, err := registry.DialSmartContractRegistry(
+				ctx,
+				log,
+				options.Contracts,
+			)
+			if err != nil {
+				log.Fatal("initializing registry", zap.Error(err))
+			}
+			if options.Contracts.SnapshotPath != "" {
+				err = contractRegistry.EnableSnapshot(
+					options.Contracts.SnapshotPath,
+					options.PrivateKeyString,
+				)
+				if err != nil {
+					log.Fatal("enabling registry snapshot", zap.Error(err))
+				}
+			}
+			if err = contractRegistry.Start(ctx); err != nil {
+				log.Fatal("starting registry", zap.Error(err))
+			}
+			nodeRegistry = contractRegistry
+		}
+
 		s, err := server.NewReplicationServer(
 			ctx,
 			log,
 			options,
-			registry.NewFixedNodeRegistry([]registry.Node{}),
+			nodeRegistry,
 			db,
 		)
 		if err != nil {
 			log.Fatal("initializing server", zap.Error(err))
 		}
+		s.EnableReload(logConfig.Level, loadOptions)
 		s.WaitForShutdown()
 		doneC <- true
 	})
 
 	sigC := make(chan os.Signal, 1)
 	signal.Notify(sigC,
-		syscall.SIGHUP,
 		syscall.SIGINT,
 		syscall.SIGTERM,
 		syscall.SIGQUIT,
@@ -75,24 +101,22 @@ func main() {
 	select {
 	case sig := <-sigC:
 		log.Info("ending on signal", zap.String("signal", sig.String()))
+		// The server drains on SIGINT and SIGTERM. Give it the chance to before cancelling
+		if sig == syscall.SIGINT || sig == syscall.SIGTERM {
+			select {
+			case <-doneC:
+			case <-time.After(options.API.DrainTimeout + time.Second):
+			}
+		}
 	case <-doneC:
 	}
 	cancel()
 	wg.Wait()
 }
 
-func addEnvVars() {
-	if connStr, hasConnstr := os.LookupEnv("WRITER_DB_CONNECTION_STRING"); hasConnstr {
-		options.DB.WriterConnectionString = connStr
-	}
-
-	if connStr, hasConnstr := os.LookupEnv("READER_DB_CONNECTION_STRING"); hasConnstr {
-		options.DB.ReaderConnectionString = connStr
-	}
-
-	if privKey, hasPrivKey := os.LookupEnv("PRIVATE_KEY"); hasPrivKey {
-		options.PrivateKeyString = privKey
-	}
+// Parses the command line and config file. Called again on every reload
+func loadOptions() (config.ServerOptions, error) {
+	return config.ParseServerOptions(os.Args[1:])
 }
 
 func fatal(msg string, args ...any) {
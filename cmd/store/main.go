@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"log"
+	"os"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/xmtp/xmtpd/pkg/config"
+	"github.com/xmtp/xmtpd/pkg/db"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+var options config.StoreOptions
+
+func main() {
+	parser := flags.NewParser(&options, flags.Default)
+	if _, err := parser.Parse(); err != nil {
+		if err, ok := err.(*flags.Error); !ok || err.Type != flags.ErrHelp {
+			fatal("Could not parse options: %s", err)
+		}
+		return
+	}
+
+	logger, err := buildLogger(options)
+	if err != nil {
+		fatal("Could not build logger: %s", err)
+	}
+
+	ctx := context.Background()
+	database, err := db.NewDB(
+		ctx,
+		options.DB.WriterConnectionString,
+		options.DB.WaitForDB,
+		options.DB.ReadTimeout,
+	)
+	if err != nil {
+		logger.Fatal("initializing database", zap.Error(err))
+	}
+	defer database.Close()
+
+	switch parser.Active.Name {
+	case "export":
+		err = export(ctx, logger, database, options.Export)
+	case "import":
+		err = importEnvelopes(ctx, logger, database, options.Import)
+	}
+	if err != nil {
+		logger.Fatal(parser.Active.Name, zap.Error(err))
+	}
+}
+
+func export(
+	ctx context.Context,
+	logger *zap.Logger,
+	database *sql.DB,
+	options config.ExportOptions,
+) error {
+	var w io.Writer = os.Stdout
+	if options.Output != "-" {
+		file, err := os.Create(options.Output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	count, err := db.ExportEnvelopes(ctx, database, w, options.FromID)
+	if err != nil {
+		return err
+	}
+	logger.Info("Exported envelopes", zap.Int64("count", count))
+	return nil
+}
+
+func importEnvelopes(
+	ctx context.Context,
+	logger *zap.Logger,
+	database *sql.DB,
+	options config.ImportOptions,
+) error {
+	var r io.Reader = os.Stdin
+	if options.Input != "-" {
+		file, err := os.Open(options.Input)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+
+	writerLock, err := db.AcquireWriterLock(ctx, database)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = writerLock.Release()
+	}()
+
+	stats, err := db.ImportEnvelopes(ctx, writerLock, r)
+	logger.Info(
+		"Imported envelopes",
+		zap.Int64("imported", stats.Imported),
+		zap.Int64("skipped", stats.Skipped),
+	)
+	return err
+}
+
+func fatal(msg string, args ...any) {
+	log.Fatalf(msg, args...)
+}
+
+func buildLogger(options config.StoreOptions) (*zap.Logger, error) {
+	level := zapcore.InfoLevel
+	if err := level.Set(options.LogLevel); err != nil {
+		return nil, err
+	}
+
+	cfg := zap.NewDevelopmentConfig()
+	cfg.Level = zap.NewAtomicLevelAt(level)
+	cfg.DisableStacktrace = true
+	log, err := cfg.Build()
+	if err != nil {
+		return nil, err
+	}
+
+	return log.Named("store"), nil
+}
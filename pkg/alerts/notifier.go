@@ -0,0 +1,156 @@
+// Package alerts pages node operators about critical events through webhooks, Slack and
+// PagerDuty, for operators who do not run their own alerting on top of the metrics.
+package alerts
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/xmtp/xmtpd/pkg/config"
+	"github.com/xmtp/xmtpd/pkg/utils"
+	"go.uber.org/zap"
+)
+
+const (
+	// Maximum number of alerts waiting to be delivered before new ones are dropped
+	QUEUE_SIZE = 100
+	// Maximum time spent delivering an alert to a single sink
+	SEND_TIMEOUT = 10 * time.Second
+)
+
+type Severity int
+
+const (
+	SeverityWarning Severity = iota
+	SeverityCritical
+)
+
+func (s Severity) String() string {
+	if s == SeverityCritical {
+		return "critical"
+	}
+	return "warning"
+}
+
+type Alert struct {
+	// Identifies the condition being alerted on. Alerts with the same key are deduplicated
+	Key      string
+	Severity Severity
+	Summary  string
+	Details  string
+	// Set when the condition has cleared
+	Resolved bool
+}
+
+/*
+*
+The Notifier delivers alerts to every sink whose minimum severity they meet.
+
+An alert is only sent again for the same key once the dedup window has passed, or after the
+condition was resolved in between. Resolutions are only sent for conditions that were
+alerted on.
+*/
+type Notifier struct {
+	log         *zap.Logger
+	clock       utils.Clock
+	sinks       []Sink
+	dedupWindow time.Duration
+	queue       chan Alert
+
+	mu   sync.Mutex
+	sent map[string]time.Time
+}
+
+// Returns nil, which drops every alert, if no sinks are configured
+func NewNotifier(log *zap.Logger, options config.AlertOptions) *Notifier {
+	sinks := newSinks(log, options)
+	if len(sinks) == 0 {
+		return nil
+	}
+	return newNotifier(log, utils.RealClock{}, sinks, options.DedupWindow)
+}
+
+func newNotifier(
+	log *zap.Logger,
+	clock utils.Clock,
+	sinks []Sink,
+	dedupWindow time.Duration,
+) *Notifier {
+	return &Notifier{
+		log:         log.Named("alerts"),
+		clock:       clock,
+		sinks:       sinks,
+		dedupWindow: dedupWindow,
+		queue:       make(chan Alert, QUEUE_SIZE),
+		sent:        make(map[string]time.Time),
+	}
+}
+
+// Delivers queued alerts until the context is cancelled
+func (n *Notifier) Start(ctx context.Context) {
+	if n == nil {
+		return
+	}
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case alert := <-n.queue:
+				n.deliver(ctx, alert)
+			}
+		}
+	}()
+}
+
+// Queues an alert for delivery without blocking. Safe to call on a nil Notifier
+func (n *Notifier) Notify(alert Alert) {
+	if n == nil || !n.shouldSend(alert) {
+		return
+	}
+	select {
+	case n.queue <- alert:
+	default:
+		n.log.Error(
+			"Alert queue is full, dropping alert",
+			zap.String("key", alert.Key),
+			zap.String("summary", alert.Summary),
+		)
+	}
+}
+
+func (n *Notifier) shouldSend(alert Alert) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	lastSent, ok := n.sent[alert.Key]
+	if alert.Resolved {
+		delete(n.sent, alert.Key)
+		return ok
+	}
+	if ok && n.clock.Now().Sub(lastSent) < n.dedupWindow {
+		return false
+	}
+	n.sent[alert.Key] = n.clock.Now()
+	return true
+}
+
+func (n *Notifier) deliver(ctx context.Context, alert Alert) {
+	for _, sink := range n.sinks {
+		if alert.Severity < sink.MinSeverity() {
+			continue
+		}
+		sendCtx, cancel := context.WithTimeout(ctx, SEND_TIMEOUT)
+		err := sink.Send(sendCtx, alert)
+		cancel()
+		if err != nil {
+			n.log.Error(
+				"Failed to deliver alert",
+				zap.String("sink", sink.Name()),
+				zap.String("key", alert.Key),
+				zap.Error(err),
+			)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package alerts
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xmtp/xmtpd/pkg/registry"
+	test "github.com/xmtp/xmtpd/pkg/testing"
+)
+
+type fakeSink struct {
+	minSeverity Severity
+	mu          sync.Mutex
+	alerts      []Alert
+}
+
+func (s *fakeSink) Name() string {
+	return "fake"
+}
+
+func (s *fakeSink) MinSeverity() Severity {
+	return s.minSeverity
+}
+
+func (s *fakeSink) Send(ctx context.Context, alert Alert) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.alerts = append(s.alerts, alert)
+	return nil
+}
+
+func (s *fakeSink) summaries() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	summaries := []string{}
+	for _, alert := range s.alerts {
+		summary := alert.Summary
+		if alert.Resolved {
+			summary = "resolved " + summary
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries
+}
+
+func newTestNotifier(t *testing.T, sinks ...Sink) (*Notifier, *test.FakeClock) {
+	clock := test.NewFakeClock()
+	notifier := newNotifier(test.NewLog(t), clock, sinks, time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	notifier.Start(ctx)
+	return notifier, clock
+}
+
+func TestNotifierDedup(t *testing.T) {
+	sink := &fakeSink{}
+	notifier, clock := newTestNotifier(t, sink)
+
+	notifier.Notify(Alert{Key: "a", Summary: "first"})
+	notifier.Notify(Alert{Key: "a", Summary: "repeated"})
+	notifier.Notify(Alert{Key: "b", Summary: "other"})
+	clock.Advance(time.Hour)
+	notifier.Notify(Alert{Key: "a", Summary: "after window"})
+
+	require.Eventually(t, func() bool {
+		return len(sink.summaries()) == 3
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, []string{"first", "other", "after window"}, sink.summaries())
+}
+
+func TestNotifierResolve(t *testing.T) {
+	sink := &fakeSink{}
+	notifier, _ := newTestNotifier(t, sink)
+
+	// Resolving a condition that was never alerted on sends nothing
+	notifier.Notify(Alert{Key: "a", Summary: "a", Resolved: true})
+	notifier.Notify(Alert{Key: "a", Summary: "a"})
+	notifier.Notify(Alert{Key: "a", Summary: "a", Resolved: true})
+	notifier.Notify(Alert{Key: "a", Summary: "a", Resolved: true})
+	// The condition recurring alerts again, within the dedup window
+	notifier.Notify(Alert{Key: "a", Summary: "a"})
+
+	require.Eventually(t, func() bool {
+		return len(sink.summaries()) == 3
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, []string{"a", "resolved a", "a"}, sink.summaries())
+}
+
+func TestNotifierSeverityRouting(t *testing.T) {
+	all := &fakeSink{minSeverity: SeverityWarning}
+	critical := &fakeSink{minSeverity: SeverityCritical}
+	notifier, _ := newTestNotifier(t, all, critical)
+
+	notifier.Notify(Alert{Key: "a", Severity: SeverityWarning, Summary: "warning"})
+	notifier.Notify(Alert{Key: "b", Severity: SeverityCritical, Summary: "critical"})
+
+	require.Eventually(t, func() bool {
+		return len(all.summaries()) == 2
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, []string{"critical"}, critical.summaries())
+}
+
+func TestNilNotifier(t *testing.T) {
+	var notifier *Notifier
+	notifier.Start(context.Background())
+	notifier.Notify(Alert{Key: "a"})
+	WatchRegistry(context.Background(), notifier, registry.NewFixedNodeRegistry(nil), 1)
+}
+
+func TestPagerDutySink(t *testing.T) {
+	events := make(chan pagerDutyEvent, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event pagerDutyEvent
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&event))
+		events <- event
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+	sink := &pagerDutySink{url: server.URL, routingKey: "key", source: "node-1"}
+
+	alert := Alert{Key: "check-db", Severity: SeverityCritical, Summary: "db", Details: "down"}
+	require.NoError(t, sink.Send(context.Background(), alert))
+	alert.Resolved = true
+	require.NoError(t, sink.Send(context.Background(), alert))
+
+	trigger := <-events
+	require.Equal(t, "trigger", trigger.EventAction)
+	require.Equal(t, "node-1/check-db", trigger.DedupKey)
+	require.Equal(t, "critical", trigger.Payload.Severity)
+	require.Equal(t, "down", trigger.Payload.CustomDetails["details"])
+	resolve := <-events
+	require.Equal(t, "resolve", resolve.EventAction)
+	require.Equal(t, "node-1/check-db", resolve.DedupKey)
+	require.Nil(t, resolve.Payload)
+}
+
+func TestWebhookSinkError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	sink := &webhookSink{url: server.URL, source: "node-1"}
+
+	require.ErrorContains(t, sink.Send(context.Background(), Alert{Key: "a"}), "500")
+}
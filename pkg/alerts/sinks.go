@@ -0,0 +1,182 @@
+package alerts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/xmtp/xmtpd/pkg/config"
+	"go.uber.org/zap"
+)
+
+const PAGERDUTY_EVENTS_URL = "https://events.pagerduty.com/v2/enqueue"
+
+// A destination for alerts
+type Sink interface {
+	Name() string
+	// Alerts below this severity are not sent to the sink
+	MinSeverity() Severity
+	Send(ctx context.Context, alert Alert) error
+}
+
+// Webhooks and Slack receive every alert. PagerDuty is reserved for critical alerts, as it
+// pages someone
+func newSinks(log *zap.Logger, options config.AlertOptions) []Sink {
+	source := options.Source
+	sinks := []Sink{}
+	for _, url := range options.WebhookURLs {
+		sinks = append(sinks, &webhookSink{url: url, source: source})
+	}
+	if options.SlackWebhookURL != "" {
+		sinks = append(sinks, &slackSink{url: options.SlackWebhookURL, source: source})
+	}
+	if options.PagerDutyRoutingKey != "" {
+		sinks = append(sinks, &pagerDutySink{
+			url:        PAGERDUTY_EVENTS_URL,
+			routingKey: options.PagerDutyRoutingKey,
+			source:     source,
+		})
+	}
+	for _, sink := range sinks {
+		log.Info(
+			"Sending alerts",
+			zap.String("sink", sink.Name()),
+			zap.Stringer("minSeverity", sink.MinSeverity()),
+		)
+	}
+	return sinks
+}
+
+// Posts alerts as JSON to an arbitrary URL
+type webhookSink struct {
+	url    string
+	source string
+}
+
+type webhookPayload struct {
+	Key      string `json:"key"`
+	Severity string `json:"severity"`
+	Summary  string `json:"summary"`
+	Details  string `json:"details,omitempty"`
+	Resolved bool   `json:"resolved"`
+	Source   string `json:"source"`
+}
+
+func (s *webhookSink) Name() string {
+	return "webhook"
+}
+
+func (s *webhookSink) MinSeverity() Severity {
+	return SeverityWarning
+}
+
+func (s *webhookSink) Send(ctx context.Context, alert Alert) error {
+	return postJSON(ctx, s.url, webhookPayload{
+		Key:      alert.Key,
+		Severity: alert.Severity.String(),
+		Summary:  alert.Summary,
+		Details:  alert.Details,
+		Resolved: alert.Resolved,
+		Source:   s.source,
+	})
+}
+
+// Posts alerts to a Slack incoming webhook
+type slackSink struct {
+	url    string
+	source string
+}
+
+func (s *slackSink) Name() string {
+	return "slack"
+}
+
+func (s *slackSink) MinSeverity() Severity {
+	return SeverityWarning
+}
+
+func (s *slackSink) Send(ctx context.Context, alert Alert) error {
+	status := fmt.Sprintf("[%s]", alert.Severity)
+	if alert.Resolved {
+		status = "[resolved]"
+	}
+	text := fmt.Sprintf("%s %s: %s", status, s.source, alert.Summary)
+	if alert.Details != "" && !alert.Resolved {
+		text = fmt.Sprintf("%s\n%s", text, alert.Details)
+	}
+	return postJSON(ctx, s.url, map[string]string{"text": text})
+}
+
+// Triggers and resolves PagerDuty incidents through the Events API v2
+type pagerDutySink struct {
+	url        string
+	routingKey string
+	source     string
+}
+
+type pagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"`
+	DedupKey    string            `json:"dedup_key"`
+	Payload     *pagerDutyPayload `json:"payload,omitempty"`
+}
+
+type pagerDutyPayload struct {
+	Summary       string            `json:"summary"`
+	Source        string            `json:"source"`
+	Severity      string            `json:"severity"`
+	CustomDetails map[string]string `json:"custom_details,omitempty"`
+}
+
+func (s *pagerDutySink) Name() string {
+	return "pagerduty"
+}
+
+func (s *pagerDutySink) MinSeverity() Severity {
+	return SeverityCritical
+}
+
+func (s *pagerDutySink) Send(ctx context.Context, alert Alert) error {
+	// Scoping the key to the source lets several nodes share a routing key
+	event := pagerDutyEvent{
+		RoutingKey:  s.routingKey,
+		EventAction: "resolve",
+		DedupKey:    fmt.Sprintf("%s/%s", s.source, alert.Key),
+	}
+	if !alert.Resolved {
+		event.EventAction = "trigger"
+		event.Payload = &pagerDutyPayload{
+			Summary:  alert.Summary,
+			Source:   s.source,
+			Severity: alert.Severity.String(),
+		}
+		if alert.Details != "" {
+			event.Payload.CustomDetails = map[string]string{"details": alert.Details}
+		}
+	}
+	return postJSON(ctx, s.url, event)
+}
+
+func postJSON(ctx context.Context, url string, body any) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package alerts
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/xmtp/xmtpd/pkg/health"
+	"github.com/xmtp/xmtpd/pkg/overload"
+	"github.com/xmtp/xmtpd/pkg/registry"
+)
+
+// Alerts when the node is marked unhealthy on, or removed from, the Nodes contract
+func WatchRegistry(
+	ctx context.Context,
+	notifier *Notifier,
+	nodeRegistry registry.NodeRegistry,
+	nodeID uint16,
+) {
+	if notifier == nil {
+		return
+	}
+	changes, cancel := nodeRegistry.OnChangedNode(nodeID)
+	removals, cancelRemovals := nodeRegistry.OnRemovedNodes()
+	go func() {
+		defer cancel()
+		defer cancelRemovals()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case node, ok := <-changes:
+				if !ok {
+					return
+				}
+				notifier.Notify(Alert{
+					Key:      "registry-unhealthy",
+					Severity: SeverityCritical,
+					Summary: fmt.Sprintf(
+						"Node %d is marked unhealthy on the Nodes contract",
+						nodeID,
+					),
+					Resolved: node.IsHealthy,
+				})
+			case nodes, ok := <-removals:
+				if !ok {
+					return
+				}
+				isRemoved := slices.ContainsFunc(nodes, func(node registry.Node) bool {
+					return node.NodeID == nodeID
+				})
+				if isRemoved {
+					notifier.Notify(Alert{
+						Key:      "registry-removed",
+						Severity: SeverityCritical,
+						Summary: fmt.Sprintf(
+							"Node %d was removed from the Nodes contract",
+							nodeID,
+						),
+					})
+				}
+			}
+		}
+	}()
+}
+
+/*
+*
+Runs the checks on an interval, and alerts on every check that fails. An alert is resolved
+once its check passes again.
+
+The same checks back the readiness probe, so operators without an orchestrator watching
+/readyz still find out when a dependency is lost.
+*/
+func WatchChecks(
+	ctx context.Context,
+	notifier *Notifier,
+	interval time.Duration,
+	severity Severity,
+	checks []health.Check,
+) {
+	if notifier == nil || interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := notifier.clock.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C():
+				for _, check := range checks {
+					runCheck(ctx, notifier, severity, check)
+				}
+			}
+		}
+	}()
+}
+
+func runCheck(ctx context.Context, notifier *Notifier, severity Severity, check health.Check) {
+	checkCtx, cancel := context.WithTimeout(ctx, health.CHECK_TIMEOUT)
+	defer cancel()
+	err := check.Run(checkCtx)
+	alert := Alert{
+		Key:      fmt.Sprintf("check-%s", check.Name),
+		Severity: severity,
+		Summary:  fmt.Sprintf("Health check %q is failing", check.Name),
+		Resolved: err == nil,
+	}
+	if err != nil {
+		alert.Details = err.Error()
+	}
+	notifier.Notify(alert)
+}
+
+// Fails while the node is shedding queries, meaning a resource limit has been reached
+func OverloadCheck(monitor *overload.Monitor) health.Check {
+	return health.Check{
+		Name: "overload",
+		Run: func(ctx context.Context) error {
+			if level := monitor.Level(); level >= overload.LevelShedQueries {
+				return fmt.Errorf("overload level is %s", level)
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,50 @@
+package alerts
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xmtp/xmtpd/pkg/health"
+)
+
+func TestWatchChecks(t *testing.T) {
+	sink := &fakeSink{}
+	notifier, clock := newTestNotifier(t, sink)
+	var failing atomic.Bool
+	check := health.Check{
+		Name: "db",
+		Run: func(ctx context.Context) error {
+			if failing.Load() {
+				return errors.New("connection refused")
+			}
+			return nil
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	WatchChecks(ctx, notifier, time.Minute, SeverityCritical, []health.Check{check})
+	require.Eventually(t, func() bool {
+		return clock.TickerCount() == 1
+	}, time.Second, 10*time.Millisecond)
+
+	tick := func(expected int) {
+		clock.Advance(time.Minute)
+		require.Eventually(t, func() bool {
+			return len(sink.summaries()) == expected
+		}, time.Second, 10*time.Millisecond)
+	}
+	failing.Store(true)
+	tick(1)
+	failing.Store(false)
+	tick(2)
+
+	require.Equal(
+		t,
+		[]string{`Health check "db" is failing`, `resolved Health check "db" is failing`},
+		sink.summaries(),
+	)
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"context"
+	"encoding/hex"
+	"time"
+
+	"github.com/xmtp/xmtpd/pkg/audit"
+	"github.com/xmtp/xmtpd/pkg/proto/xmtpv4/message_api"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// Records a sample of requests to the audit log, including requests rejected by the
+// interceptors that follow
+func auditUnaryInterceptor(auditor *audit.Auditor) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		kind, ok := requestKinds[info.FullMethod]
+		if !ok || !auditor.Sample(kind) {
+			return handler(ctx, req)
+		}
+
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		record := newAuditRecord(ctx, info.FullMethod, start, err)
+		addRequestDetails(&record, req)
+		switch resp := resp.(type) {
+		case *message_api.PublishEnvelopeResponse:
+			if resp.GetOriginatorEnvelope() != nil {
+				record.Envelopes = 1
+			}
+		case *message_api.QueryEnvelopesResponse:
+			record.Envelopes = len(resp.GetEnvelopes())
+		}
+		auditor.Log(record)
+		return resp, err
+	}
+}
+
+func auditStreamInterceptor(auditor *audit.Auditor) grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		stream grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		kind, ok := requestKinds[info.FullMethod]
+		if !ok || !auditor.Sample(kind) {
+			return handler(srv, stream)
+		}
+
+		start := time.Now()
+		audited := &auditStream{ServerStream: stream}
+		err := handler(srv, audited)
+		record := newAuditRecord(stream.Context(), info.FullMethod, start, err)
+		addRequestDetails(&record, audited.req)
+		record.Envelopes = audited.envelopes
+		auditor.Log(record)
+		return err
+	}
+}
+
+// Captures the request and counts the envelopes sent on a stream
+type auditStream struct {
+	grpc.ServerStream
+	req       interface{}
+	envelopes int
+}
+
+func (s *auditStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil && s.req == nil {
+		s.req = m
+	}
+	return err
+}
+
+func (s *auditStream) SendMsg(m interface{}) error {
+	err := s.ServerStream.SendMsg(m)
+	if resp, ok := m.(*message_api.BatchSubscribeEnvelopesResponse); ok && err == nil {
+		s.envelopes += len(resp.GetEnvelopes())
+	}
+	return err
+}
+
+func newAuditRecord(
+	ctx context.Context,
+	method string,
+	start time.Time,
+	err error,
+) audit.Record {
+	record := audit.Record{
+		Time:      start,
+		RequestID: requestIDFromContext(ctx),
+		Method:    method,
+		Latency:   time.Since(start),
+		Code:      status.Code(err).String(),
+	}
+	if ip := clientIP(ctx); ip != nil {
+		record.ClientIP = ip.String()
+	}
+	if err != nil {
+		record.Error = status.Convert(err).Message()
+	}
+	return record
+}
+
+func addRequestDetails(record *audit.Record, req interface{}) {
+	addQuery := func(query *message_api.EnvelopesQuery) {
+		switch filter := query.GetFilter().(type) {
+		case *message_api.EnvelopesQuery_Topic:
+			record.Topics = append(record.Topics, hex.EncodeToString(filter.Topic))
+		case *message_api.EnvelopesQuery_OriginatorId:
+			record.OriginatorIDs = append(record.OriginatorIDs, filter.OriginatorId)
+		}
+	}
+
+	switch req := req.(type) {
+	case *message_api.PublishEnvelopeRequest:
+		clientEnv := &message_api.ClientEnvelope{}
+		err := proto.Unmarshal(req.GetPayerEnvelope().GetUnsignedClientEnvelope(), clientEnv)
+		if err == nil && clientEnv.GetAad() != nil {
+			record.Topics = []string{hex.EncodeToString(clientEnv.GetAad().GetTargetTopic())}
+		}
+	case *message_api.QueryEnvelopesRequest:
+		addQuery(req.GetQuery())
+	case *message_api.BatchSubscribeEnvelopesRequest:
+		for _, subReq := range req.GetRequests() {
+			addQuery(subReq.GetQuery())
+		}
+	}
+}
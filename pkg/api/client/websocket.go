@@ -0,0 +1,123 @@
+// Package client connects to a node's API over transports other than native gRPC.
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/websocket"
+	"github.com/xmtp/xmtpd/pkg/api"
+	"github.com/xmtp/xmtpd/pkg/proto/xmtpv4/message_api"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+type WebSocketOptions struct {
+	// Exchange messages as JSON, like browsers usually do, rather than binary protobuf
+	JSON bool
+	// Headers sent with the WebSocket handshake
+	Header http.Header
+}
+
+// A subscription over the WebSocket endpoint of a node's HTTP gateway, as browser clients
+// use it
+type WebSocketSubscription struct {
+	conn        *websocket.Conn
+	messageType int
+}
+
+/*
+*
+Subscribes to envelopes over WebSocket. url is the node's HTTP gateway with a ws or wss
+scheme, such as ws://localhost:5055, and its query parameters are kept.
+
+Pings from the node are answered while Recv is being called.
+*/
+func SubscribeWebSocket(
+	ctx context.Context,
+	url string,
+	req *message_api.BatchSubscribeEnvelopesRequest,
+	options WebSocketOptions,
+) (*WebSocketSubscription, error) {
+	endpoint, query, hasQuery := strings.Cut(url, "?")
+	endpoint = strings.TrimSuffix(endpoint, "/") + api.WebSocketSubscribePath
+	if hasQuery {
+		endpoint += "?" + query
+	}
+	conn, resp, err := websocket.DefaultDialer.DialContext(ctx, endpoint, options.Header)
+	if resp != nil && resp.Body != nil {
+		_ = resp.Body.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	messageType := websocket.BinaryMessage
+	var data []byte
+	if options.JSON {
+		messageType = websocket.TextMessage
+		data, err = protojson.Marshal(req)
+	} else {
+		data, err = proto.Marshal(req)
+	}
+	if err == nil {
+		err = conn.WriteMessage(messageType, data)
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return &WebSocketSubscription{conn: conn, messageType: messageType}, nil
+}
+
+/*
+*
+Returns the next batch of envelopes. When the node ends the subscription, returns io.EOF if
+it ended normally, and otherwise the gRPC status error the node ended it with.
+*/
+func (s *WebSocketSubscription) Recv() (*message_api.BatchSubscribeEnvelopesResponse, error) {
+	_, data, err := s.conn.ReadMessage()
+	if err != nil {
+		return nil, closeError(err)
+	}
+	resp := &message_api.BatchSubscribeEnvelopesResponse{}
+	if s.messageType == websocket.TextMessage {
+		err = protojson.Unmarshal(data, resp)
+	} else {
+		err = proto.Unmarshal(data, resp)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Ends the subscription
+func (s *WebSocketSubscription) Close() error {
+	_ = s.conn.WriteMessage(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+	)
+	return s.conn.Close()
+}
+
+// Converts the close code the node sent to the error it stands for
+func closeError(err error) error {
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) {
+		return err
+	}
+	if closeErr.Code == websocket.CloseNormalClosure {
+		return io.EOF
+	}
+	code := closeErr.Code - api.WebSocketCloseCodeBase
+	if code < 0 || code > int(codes.Unauthenticated) {
+		return err
+	}
+	return status.Error(codes.Code(code), closeErr.Text)
+}
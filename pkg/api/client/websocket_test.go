@@ -0,0 +1,169 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xmtp/xmtpd/pkg/api"
+	"github.com/xmtp/xmtpd/pkg/proto/xmtpv4/message_api"
+	test "github.com/xmtp/xmtpd/pkg/testing"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Serves subscriptions from a list of responses, followed by err
+type fakeReplicationClient struct {
+	message_api.ReplicationApiClient
+	responses []*message_api.BatchSubscribeEnvelopesResponse
+	err       error
+	requests  chan *message_api.BatchSubscribeEnvelopesRequest
+	metadata  chan metadata.MD
+	done      chan struct{}
+}
+
+func newFakeReplicationClient(
+	err error,
+	responses ...*message_api.BatchSubscribeEnvelopesResponse,
+) *fakeReplicationClient {
+	return &fakeReplicationClient{
+		responses: responses,
+		err:       err,
+		requests:  make(chan *message_api.BatchSubscribeEnvelopesRequest, 1),
+		metadata:  make(chan metadata.MD, 1),
+		done:      make(chan struct{}),
+	}
+}
+
+func (c *fakeReplicationClient) BatchSubscribeEnvelopes(
+	ctx context.Context,
+	req *message_api.BatchSubscribeEnvelopesRequest,
+	_ ...grpc.CallOption,
+) (message_api.ReplicationApi_BatchSubscribeEnvelopesClient, error) {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	c.requests <- req
+	c.metadata <- md
+	return &fakeSubscribeStream{ctx: ctx, client: c}, nil
+}
+
+type fakeSubscribeStream struct {
+	grpc.ClientStream
+	ctx    context.Context
+	client *fakeReplicationClient
+	sent   int
+}
+
+func (s *fakeSubscribeStream) Recv() (*message_api.BatchSubscribeEnvelopesResponse, error) {
+	if s.sent < len(s.client.responses) {
+		s.sent++
+		return s.client.responses[s.sent-1], nil
+	}
+	if s.client.err != nil {
+		return nil, s.client.err
+	}
+	// Without an error, the stream stays open until the subscriber leaves
+	<-s.ctx.Done()
+	close(s.client.done)
+	return nil, s.ctx.Err()
+}
+
+func startWebSocketServer(t *testing.T, client message_api.ReplicationApiClient) string {
+	server := httptest.NewServer(api.NewWebSocketSubscribeHandler(test.NewLog(t), client))
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func response(sids ...uint64) *message_api.BatchSubscribeEnvelopesResponse {
+	resp := &message_api.BatchSubscribeEnvelopesResponse{}
+	for _, sid := range sids {
+		resp.Envelopes = append(resp.Envelopes, &message_api.GatewayEnvelope{GatewaySid: sid})
+	}
+	return resp
+}
+
+func subscribeRequest(topic string) *message_api.BatchSubscribeEnvelopesRequest {
+	return &message_api.BatchSubscribeEnvelopesRequest{
+		Requests: []*message_api.BatchSubscribeEnvelopesRequest_SubscribeEnvelopesRequest{{
+			Query: &message_api.EnvelopesQuery{
+				Filter: &message_api.EnvelopesQuery_Topic{Topic: []byte(topic)},
+			},
+		}},
+	}
+}
+
+func TestWebSocketSubscription(t *testing.T) {
+	for _, json := range []bool{false, true} {
+		fake := newFakeReplicationClient(
+			status.Error(codes.Unavailable, "node is shutting down"),
+			response(1, 2),
+			response(3),
+		)
+		url := startWebSocketServer(t, fake)
+
+		sub, err := SubscribeWebSocket(
+			context.Background(),
+			url+"?"+api.TopicPatternsHeader+"=true",
+			subscribeRequest("topic*"),
+			WebSocketOptions{JSON: json},
+		)
+		require.NoError(t, err)
+		defer sub.Close()
+
+		require.Equal(t, []byte("topic*"), (<-fake.requests).GetRequests()[0].GetQuery().GetTopic())
+		md := <-fake.metadata
+		require.Equal(t, []string{"true"}, md.Get(api.TopicPatternsHeader))
+		require.Equal(t, []string{"127.0.0.1"}, md.Get("x-forwarded-for"))
+
+		resp, err := sub.Recv()
+		require.NoError(t, err)
+		require.Len(t, resp.GetEnvelopes(), 2)
+		resp, err = sub.Recv()
+		require.NoError(t, err)
+		require.Equal(t, uint64(3), resp.GetEnvelopes()[0].GetGatewaySid())
+
+		// The node's error is passed on through the close code
+		_, err = sub.Recv()
+		require.Equal(t, codes.Unavailable, status.Code(err), json)
+		require.ErrorContains(t, err, "node is shutting down")
+	}
+}
+
+func TestWebSocketSubscriptionEnds(t *testing.T) {
+	sub, err := SubscribeWebSocket(
+		context.Background(),
+		startWebSocketServer(t, newFakeReplicationClient(io.EOF)),
+		subscribeRequest("topic"),
+		WebSocketOptions{},
+	)
+	require.NoError(t, err)
+	defer sub.Close()
+
+	_, err = sub.Recv()
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestWebSocketSubscriberLeaves(t *testing.T) {
+	fake := newFakeReplicationClient(nil)
+	sub, err := SubscribeWebSocket(
+		context.Background(),
+		startWebSocketServer(t, fake),
+		subscribeRequest("topic"),
+		WebSocketOptions{},
+	)
+	require.NoError(t, err)
+	<-fake.requests
+
+	// Closing the WebSocket cancels the subscription on the node
+	require.NoError(t, sub.Close())
+	select {
+	case <-fake.done:
+	case <-time.After(time.Second):
+		t.Fatal("subscription was not cancelled")
+	}
+}
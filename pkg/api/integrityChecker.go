@@ -0,0 +1,93 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"math/rand"
+
+	"github.com/xmtp/xmtpd/pkg/db/queries"
+	"github.com/xmtp/xmtpd/pkg/envelopes"
+	"github.com/xmtp/xmtpd/pkg/metrics"
+	"github.com/xmtp/xmtpd/pkg/proto/xmtpv4/message_api"
+	"github.com/xmtp/xmtpd/pkg/registry"
+	"github.com/xmtp/xmtpd/pkg/utils"
+	"go.uber.org/zap"
+)
+
+/*
+The IntegrityChecker verifies a random sample of the envelopes returned by queries against
+their originator's signing key, so that corrupted or tampered rows are noticed during
+normal operation. Mismatches are logged and counted, but the envelopes are still returned.
+*/
+type IntegrityChecker struct {
+	log        *zap.Logger
+	registry   registry.NodeRegistry
+	sampleRate float64
+	random     func() float64
+}
+
+// Returns nil, which disables checks, if sampleRate is not positive
+func NewIntegrityChecker(
+	log *zap.Logger,
+	registry registry.NodeRegistry,
+	sampleRate float64,
+) *IntegrityChecker {
+	if sampleRate <= 0 {
+		return nil
+	}
+	return &IntegrityChecker{
+		log:        log.Named("integrity"),
+		registry:   registry,
+		sampleRate: sampleRate,
+		random:     rand.Float64,
+	}
+}
+
+func (c *IntegrityChecker) sample(
+	row queries.GatewayEnvelope,
+	env *message_api.GatewayEnvelope,
+) {
+	if c == nil || c.random() >= c.sampleRate {
+		return
+	}
+
+	originatorID := uint16(row.OriginatorNodeID)
+	signingKey, err := c.signingKey(originatorID)
+	if err != nil {
+		c.log.Warn(
+			"Could not check envelope integrity",
+			zap.Uint16("originatorID", originatorID),
+			zap.Error(err),
+		)
+		return
+	}
+
+	originatorSid := utils.SID(originatorID, row.OriginatorSequenceID)
+	err = envelopes.VerifyOriginatorEnvelope(
+		env.GetOriginatorEnvelope(),
+		originatorSid,
+		signingKey,
+	)
+	if err != nil {
+		metrics.EmitIntegrityMismatch()
+		c.log.Error(
+			"Stored envelope failed integrity check",
+			zap.Int64("gatewayID", row.ID),
+			zap.Uint64("originatorSid", originatorSid),
+			zap.Error(err),
+		)
+	}
+}
+
+func (c *IntegrityChecker) signingKey(nodeID uint16) (*ecdsa.PublicKey, error) {
+	nodes, err := c.registry.GetNodes()
+	if err != nil {
+		return nil, err
+	}
+	for _, node := range nodes {
+		if node.NodeID == nodeID && node.SigningKey != nil {
+			return node.SigningKey, nil
+		}
+	}
+	return nil, fmt.Errorf("node %d has no signing key in the registry", nodeID)
+}
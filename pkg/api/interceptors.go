@@ -0,0 +1,338 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/xmtp/xmtpd/pkg/metrics"
+	"github.com/xmtp/xmtpd/pkg/overload"
+	"github.com/xmtp/xmtpd/pkg/proto/xmtpv4/message_api"
+	"github.com/xmtp/xmtpd/pkg/ratelimit"
+	"github.com/xmtp/xmtpd/pkg/tracing"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// Metadata key carrying the request ID, in both directions
+	requestIDHeader = "x-request-id"
+	// Longest client-supplied request ID that is accepted
+	maxRequestIDLength = 64
+)
+
+type requestLoggerKey struct{}
+
+type requestIDKey struct{}
+
+// Bounds the time spent serving a unary request.
+//
+// grpc-go already attaches the deadline sent by the client (grpc-timeout) to the incoming
+// context, so the shorter of the two deadlines applies. Since the context is passed down to
+// the database, queries are abandoned as soon as the client gives up.
+func timeoutUnaryInterceptor(timeout time.Duration) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		resp, err := handler(ctx, req)
+		if err != nil && ctx.Err() != nil {
+			// Surface DeadlineExceeded/Canceled rather than whatever error the handler
+			// wrapped the context error in
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}
+		return resp, err
+	}
+}
+
+/*
+*
+Applies the timeout an HTTP client sent in header, such as 5s or 500ms, to the request. The
+gateway forwards the deadline to the gRPC server as grpc-timeout, where it bounds the request
+like a deadline sent by a native gRPC client. The gateway already honors Grpc-Timeout; this
+is for clients and proxies that can only send a header of their choosing.
+*/
+func deadlineHandler(header string, next http.Handler) http.Handler {
+	if header == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		value := r.Header.Get(header)
+		if value == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout <= 0 {
+			http.Error(w, fmt.Sprintf("invalid %s header %q", header, value), http.StatusBadRequest)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Tags every request with an ID, which is returned to the client in the response headers.
+// Clients may supply their own ID in the x-request-id header to correlate retries.
+//
+// The request ID is attached to a span for the request, and to a logger that handlers
+// retrieve with requestLogger, so that all log lines for a request can be found by ID.
+func requestIDUnaryInterceptor(log *zap.Logger) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		ctx, span := startRequest(ctx, log, info.FullMethod)
+		resp, err := handler(ctx, req)
+		span.Finish(tracing.WithError(err))
+		return resp, err
+	}
+}
+
+func requestIDStreamInterceptor(log *zap.Logger) grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		stream grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		ctx, span := startRequest(stream.Context(), log, info.FullMethod)
+		err := handler(srv, &requestStream{ServerStream: stream, ctx: ctx})
+		span.Finish(tracing.WithError(err))
+		return err
+	}
+}
+
+func startRequest(
+	ctx context.Context,
+	log *zap.Logger,
+	method string,
+) (context.Context, tracing.Span) {
+	requestID := incomingRequestID(ctx)
+	if requestID == "" {
+		requestID = uuid.NewString()
+	}
+	// Fails only if the headers have already been sent, which can't happen before the handler
+	_ = grpc.SetHeader(ctx, metadata.Pairs(requestIDHeader, requestID))
+
+	span, ctx := tracing.StartSpanFromContext(ctx, "grpc.request")
+	tracing.SpanResource(span, method)
+	tracing.SpanTag(span, "request_id", requestID)
+
+	logger := tracing.Link(span, log).With(
+		zap.String("requestID", requestID),
+		zap.String("method", method),
+	)
+	ctx = context.WithValue(ctx, requestIDKey{}, requestID)
+	return context.WithValue(ctx, requestLoggerKey{}, logger), span
+}
+
+func incomingRequestID(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	values := md.Get(requestIDHeader)
+	if len(values) == 0 || len(values[0]) > maxRequestIDLength {
+		return ""
+	}
+	return values[0]
+}
+
+// Returns the logger for the request in ctx, or fallback outside of a request
+func requestLogger(ctx context.Context, fallback *zap.Logger) *zap.Logger {
+	if logger, ok := ctx.Value(requestLoggerKey{}).(*zap.Logger); ok {
+		return logger
+	}
+	return fallback
+}
+
+// Returns the ID of the request in ctx, or "" outside of a request
+func requestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+type requestStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *requestStream) Context() context.Context {
+	return s.ctx
+}
+
+// The rate limit that applies to each method. Methods not listed here are not limited.
+var requestKinds = map[string]string{
+	message_api.ReplicationApi_PublishEnvelope_FullMethodName:         ratelimit.Publish,
+	message_api.ReplicationApi_QueryEnvelopes_FullMethodName:          ratelimit.Query,
+	message_api.ReplicationApi_BatchSubscribeEnvelopes_FullMethodName: ratelimit.Subscribe,
+}
+
+// Rejects queries and subscriptions with Unavailable while the node is shedding load
+func overloadUnaryInterceptor(monitor *overload.Monitor) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		if err := checkOverload(monitor, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func overloadStreamInterceptor(monitor *overload.Monitor) grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		stream grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		if err := checkOverload(monitor, info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, stream)
+	}
+}
+
+func checkOverload(monitor *overload.Monitor, method string) error {
+	kind, ok := requestKinds[method]
+	if !ok || monitor.Allow(kind) {
+		return nil
+	}
+	return status.Errorf(codes.Unavailable, "node is overloaded, %s requests are rejected", kind)
+}
+
+// Rejects requests with ResourceExhausted once the client IP has used up its tokens
+func rateLimitUnaryInterceptor(limiter *ratelimit.Limiter) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		if err := checkRateLimit(ctx, limiter, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func rateLimitStreamInterceptor(limiter *ratelimit.Limiter) grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		stream grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		if err := checkRateLimit(stream.Context(), limiter, info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, stream)
+	}
+}
+
+func checkRateLimit(ctx context.Context, limiter *ratelimit.Limiter, method string) error {
+	kind, ok := requestKinds[method]
+	if !ok {
+		return nil
+	}
+	ip := clientIP(ctx)
+	if ip == nil {
+		return nil
+	}
+	if !limiter.Allow(kind, ip) {
+		requestLogger(ctx, zap.NewNop()).Debug("Rate limited", zap.String("ip", ip.String()))
+		return status.Errorf(codes.ResourceExhausted, "%s rate limit exceeded", kind)
+	}
+	return nil
+}
+
+// Returns the address of the client. The listener handles the PROXY protocol from trusted
+// proxies, so this is the original client address when the node is behind a load balancer.
+// Requests from the HTTP gateway arrive over loopback, and carry the client address in
+// x-forwarded-for.
+func clientIP(ctx context.Context) net.IP {
+	ip := peerIP(ctx)
+	if ip == nil || !ip.IsLoopback() {
+		return ip
+	}
+	forwardedFor := metadata.ValueFromIncomingContext(ctx, "x-forwarded-for")
+	if len(forwardedFor) == 0 {
+		return ip
+	}
+	// The gateway appends the address it saw to any value sent by the client, so only the
+	// last entry can be trusted
+	entries := strings.Split(forwardedFor[len(forwardedFor)-1], ",")
+	forwardedIP := net.ParseIP(strings.TrimSpace(entries[len(entries)-1]))
+	if forwardedIP == nil {
+		return ip
+	}
+	return forwardedIP
+}
+
+func peerIP(ctx context.Context) net.IP {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	if tcpAddr, ok := p.Addr.(*net.TCPAddr); ok {
+		return tcpAddr.IP
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
+
+// Records the duration and status code of each request
+func metricsUnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		metrics.EmitAPIRequest(info.FullMethod, status.Code(err).String(), time.Since(start))
+		return resp, err
+	}
+}
+
+func metricsStreamInterceptor() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		stream grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		start := time.Now()
+		err := handler(srv, stream)
+		metrics.EmitAPIRequest(info.FullMethod, status.Code(err).String(), time.Since(start))
+		return err
+	}
+}
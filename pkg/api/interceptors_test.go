@@ -0,0 +1,295 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xmtp/xmtpd/pkg/audit"
+	"github.com/xmtp/xmtpd/pkg/config"
+	"github.com/xmtp/xmtpd/pkg/proto/xmtpv4/message_api"
+	"github.com/xmtp/xmtpd/pkg/ratelimit"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func blockingHandler(ctx context.Context, req interface{}) (interface{}, error) {
+	<-ctx.Done()
+	return nil, fmt.Errorf("could not insert staged envelope: %w", ctx.Err())
+}
+
+func TestTimeoutInterceptorServerTimeout(t *testing.T) {
+	interceptor := timeoutUnaryInterceptor(10 * time.Millisecond)
+
+	_, err := interceptor(
+		context.Background(),
+		nil,
+		&grpc.UnaryServerInfo{},
+		blockingHandler,
+	)
+	require.Equal(t, codes.DeadlineExceeded, status.Code(err))
+}
+
+func TestTimeoutInterceptorClientDeadline(t *testing.T) {
+	interceptor := timeoutUnaryInterceptor(time.Minute)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{}, blockingHandler)
+	require.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	require.Less(t, time.Since(start), time.Second)
+}
+
+func TestTimeoutInterceptorClientCanceled(t *testing.T) {
+	interceptor := timeoutUnaryInterceptor(time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{}, blockingHandler)
+	require.Equal(t, codes.Canceled, status.Code(err))
+}
+
+func TestTimeoutInterceptorPassesErrors(t *testing.T) {
+	interceptor := timeoutUnaryInterceptor(time.Minute)
+
+	_, err := interceptor(
+		context.Background(),
+		nil,
+		&grpc.UnaryServerInfo{},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, status.Errorf(codes.InvalidArgument, "missing target topic")
+		},
+	)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestDeadlineHandler(t *testing.T) {
+	var deadline time.Time
+	var hasDeadline bool
+	handler := deadlineHandler(
+		"X-Request-Timeout",
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			deadline, hasDeadline = r.Context().Deadline()
+		}),
+	)
+
+	req := httptest.NewRequest(http.MethodPost, "/mls/v2/query-envelopes", nil)
+	req.Header.Set("X-Request-Timeout", "5s")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	require.True(t, hasDeadline)
+	require.WithinDuration(t, time.Now().Add(5*time.Second), deadline, time.Second)
+
+	req = httptest.NewRequest(http.MethodPost, "/mls/v2/query-envelopes", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	require.False(t, hasDeadline)
+
+	req = httptest.NewRequest(http.MethodPost, "/mls/v2/query-envelopes", nil)
+	req.Header.Set("X-Request-Timeout", "soon")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	require.Equal(t, http.StatusBadRequest, recorder.Code)
+}
+
+func loggedRequestID(t *testing.T, ctx context.Context) string {
+	core, logs := observer.New(zap.InfoLevel)
+	interceptor := requestIDUnaryInterceptor(zap.New(core))
+
+	_, err := interceptor(
+		ctx,
+		nil,
+		&grpc.UnaryServerInfo{FullMethod: "/xmtp.xmtpv4.ReplicationApi/QueryEnvelopes"},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			requestLogger(ctx, zap.NewNop()).Info("handling request")
+			return nil, nil
+		},
+	)
+	require.NoError(t, err)
+	require.Equal(t, 1, logs.Len())
+	fields := logs.All()[0].ContextMap()
+	require.Equal(t, "/xmtp.xmtpv4.ReplicationApi/QueryEnvelopes", fields["method"])
+	return fields["requestID"].(string)
+}
+
+func TestRequestIDInterceptorGeneratesID(t *testing.T) {
+	first := loggedRequestID(t, context.Background())
+	second := loggedRequestID(t, context.Background())
+	require.NotEmpty(t, first)
+	require.NotEqual(t, first, second)
+}
+
+func TestRequestIDInterceptorUsesClientID(t *testing.T) {
+	ctx := metadata.NewIncomingContext(
+		context.Background(),
+		metadata.Pairs(requestIDHeader, "client-id"),
+	)
+	require.Equal(t, "client-id", loggedRequestID(t, ctx))
+
+	ctx = metadata.NewIncomingContext(
+		context.Background(),
+		metadata.Pairs(requestIDHeader, strings.Repeat("a", maxRequestIDLength+1)),
+	)
+	require.Len(t, loggedRequestID(t, ctx), 36)
+}
+
+func TestRequestLoggerFallback(t *testing.T) {
+	fallback := zap.NewNop()
+	require.Same(t, fallback, requestLogger(context.Background(), fallback))
+}
+
+func TestRateLimitInterceptor(t *testing.T) {
+	limiter, err := ratelimit.NewLimiter(config.RateLimitOptions{
+		PublishRate:  1,
+		PublishBurst: 1,
+	})
+	require.NoError(t, err)
+	interceptor := rateLimitUnaryInterceptor(limiter)
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, nil
+	}
+	ctx := peer.NewContext(context.Background(), &peer.Peer{
+		Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1234},
+	})
+	publish := &grpc.UnaryServerInfo{
+		FullMethod: message_api.ReplicationApi_PublishEnvelope_FullMethodName,
+	}
+
+	_, err = interceptor(ctx, nil, publish, handler)
+	require.NoError(t, err)
+	_, err = interceptor(ctx, nil, publish, handler)
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	// Methods without a limit are not throttled
+	health := &grpc.UnaryServerInfo{FullMethod: "/grpc.health.v1.Health/Check"}
+	_, err = interceptor(ctx, nil, health, handler)
+	require.NoError(t, err)
+}
+
+func TestClientIPFromGateway(t *testing.T) {
+	loopback := peer.NewContext(context.Background(), &peer.Peer{
+		Addr: &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 1234},
+	})
+	require.Equal(t, "127.0.0.1", clientIP(loopback).String())
+
+	forwarded := metadata.NewIncomingContext(
+		loopback,
+		metadata.Pairs("x-forwarded-for", "1.1.1.1, 10.0.0.1"),
+	)
+	require.Equal(t, "10.0.0.1", clientIP(forwarded).String())
+
+	// Only requests arriving over loopback may set the client address
+	remote := metadata.NewIncomingContext(
+		peer.NewContext(context.Background(), &peer.Peer{
+			Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 1234},
+		}),
+		metadata.Pairs("x-forwarded-for", "10.0.0.1"),
+	)
+	require.Equal(t, "10.0.0.2", clientIP(remote).String())
+}
+
+func TestProxyListener(t *testing.T) {
+	// Returns the client address the listener reports for a connection sending a PROXY
+	// header that claims to be from 1.2.3.4
+	proxiedAddr := func(trustedProxies []string) string {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		proxyListener, err := newProxyListener(listener, trustedProxies)
+		require.NoError(t, err)
+		defer proxyListener.Close()
+
+		client, err := net.Dial("tcp", listener.Addr().String())
+		require.NoError(t, err)
+		defer client.Close()
+		_, err = client.Write([]byte("PROXY TCP4 1.2.3.4 127.0.0.1 1000 2000\r\n"))
+		require.NoError(t, err)
+
+		conn, err := proxyListener.Accept()
+		require.NoError(t, err)
+		defer conn.Close()
+		host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+		require.NoError(t, err)
+		return host
+	}
+
+	require.Equal(t, "1.2.3.4", proxiedAddr([]string{"127.0.0.0/8"}))
+	require.Equal(t, "127.0.0.1", proxiedAddr(nil))
+
+	_, err := newProxyListener(nil, []string{"not an address"})
+	require.ErrorContains(t, err, "invalid trusted proxy")
+}
+
+func TestAuditInterceptor(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	auditor, err := audit.NewAuditor(zap.NewNop(), config.AuditOptions{
+		Path:            path,
+		QuerySampleRate: 1,
+	})
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	auditor.Start(ctx)
+	interceptor := auditUnaryInterceptor(auditor)
+
+	ctx = context.WithValue(ctx, requestIDKey{}, "abc")
+	ctx = peer.NewContext(ctx, &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("1.2.3.4")}})
+	req := &message_api.QueryEnvelopesRequest{
+		Query: &message_api.EnvelopesQuery{
+			Filter: &message_api.EnvelopesQuery_Topic{Topic: []byte{0x0a}},
+		},
+	}
+	_, err = interceptor(
+		ctx,
+		req,
+		&grpc.UnaryServerInfo{
+			FullMethod: message_api.ReplicationApi_QueryEnvelopes_FullMethodName,
+		},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			return &message_api.QueryEnvelopesResponse{
+				Envelopes: []*message_api.GatewayEnvelope{{}, {}},
+			}, nil
+		},
+	)
+	require.NoError(t, err)
+
+	// Publishes are not sampled
+	_, err = interceptor(
+		ctx,
+		&message_api.PublishEnvelopeRequest{},
+		&grpc.UnaryServerInfo{
+			FullMethod: message_api.ReplicationApi_PublishEnvelope_FullMethodName,
+		},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid")
+		},
+	)
+	require.Error(t, err)
+
+	var data []byte
+	require.Eventually(t, func() bool {
+		data, err = os.ReadFile(path)
+		require.NoError(t, err)
+		return len(data) > 0
+	}, time.Second, 10*time.Millisecond)
+	var record audit.Record
+	require.NoError(t, json.Unmarshal(data, &record))
+	require.Equal(t, "abc", record.RequestID)
+	require.Equal(t, message_api.ReplicationApi_QueryEnvelopes_FullMethodName, record.Method)
+	require.Equal(t, "1.2.3.4", record.ClientIP)
+	require.Equal(t, []string{"0a"}, record.Topics)
+	require.Equal(t, 2, record.Envelopes)
+	require.Equal(t, "OK", record.Code)
+}
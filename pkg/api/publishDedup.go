@@ -0,0 +1,91 @@
+package api
+
+import (
+	"crypto/sha256"
+	"sync"
+	"time"
+
+	"github.com/xmtp/xmtpd/pkg/proto/xmtpv4/message_api"
+	"github.com/xmtp/xmtpd/pkg/utils"
+)
+
+const (
+	// Maximum number of publishes remembered per window, to bound memory under load
+	maxDedupEntries = 100_000
+)
+
+/*
+*
+The PublishDeduplicator remembers the response to recently published payer envelopes, so
+that a client retrying a publish gets the original envelope back instead of storing a
+duplicate.
+
+Entries are kept in two generations that rotate every window, so a publish is remembered for
+between one and two windows. Identical publishes that race each other are not deduplicated.
+*/
+type PublishDeduplicator struct {
+	mutex     sync.Mutex
+	clock     utils.Clock
+	window    time.Duration
+	rotatedAt time.Time
+	current   map[[sha256.Size]byte]*message_api.OriginatorEnvelope
+	previous  map[[sha256.Size]byte]*message_api.OriginatorEnvelope
+}
+
+// Returns nil, which disables deduplication, if window is not positive
+func NewPublishDeduplicator(clock utils.Clock, window time.Duration) *PublishDeduplicator {
+	if window <= 0 {
+		return nil
+	}
+	return &PublishDeduplicator{
+		clock:     clock,
+		window:    window,
+		rotatedAt: clock.Now(),
+		current:   make(map[[sha256.Size]byte]*message_api.OriginatorEnvelope),
+		previous:  make(map[[sha256.Size]byte]*message_api.OriginatorEnvelope),
+	}
+}
+
+// Returns the envelope originated for an identical publish within the window, if any
+func (d *PublishDeduplicator) get(payerBytes []byte) *message_api.OriginatorEnvelope {
+	if d == nil {
+		return nil
+	}
+	key := sha256.Sum256(payerBytes)
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.rotate()
+	if env, ok := d.current[key]; ok {
+		return env
+	}
+	return d.previous[key]
+}
+
+func (d *PublishDeduplicator) add(payerBytes []byte, env *message_api.OriginatorEnvelope) {
+	if d == nil {
+		return
+	}
+	key := sha256.Sum256(payerBytes)
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.rotate()
+	if len(d.current) < maxDedupEntries {
+		d.current[key] = env
+	}
+}
+
+func (d *PublishDeduplicator) rotate() {
+	now := d.clock.Now()
+	elapsed := now.Sub(d.rotatedAt)
+	if elapsed < d.window {
+		return
+	}
+	if elapsed >= 2*d.window {
+		// Both generations have expired
+		d.previous = make(map[[sha256.Size]byte]*message_api.OriginatorEnvelope)
+	} else {
+		d.previous = d.current
+	}
+	d.current = make(map[[sha256.Size]byte]*message_api.OriginatorEnvelope)
+	d.rotatedAt = now
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xmtp/xmtpd/pkg/proto/xmtpv4/message_api"
+	test "github.com/xmtp/xmtpd/pkg/testing"
+)
+
+func TestPublishDeduplicatorWindow(t *testing.T) {
+	clock := test.NewFakeClock()
+	dedup := NewPublishDeduplicator(clock, time.Minute)
+	env := &message_api.OriginatorEnvelope{UnsignedOriginatorEnvelope: []byte{1}}
+
+	require.Nil(t, dedup.get([]byte("a")))
+	dedup.add([]byte("a"), env)
+	require.Equal(t, env, dedup.get([]byte("a")))
+	require.Nil(t, dedup.get([]byte("b")))
+
+	// Remembered into the next window
+	clock.Advance(90 * time.Second)
+	require.Equal(t, env, dedup.get([]byte("a")))
+
+	clock.Advance(time.Minute)
+	require.Nil(t, dedup.get([]byte("a")))
+}
+
+func TestPublishDeduplicatorExpiresAfterIdle(t *testing.T) {
+	clock := test.NewFakeClock()
+	dedup := NewPublishDeduplicator(clock, time.Minute)
+	dedup.add([]byte("a"), &message_api.OriginatorEnvelope{})
+
+	clock.Advance(2 * time.Minute)
+	require.Nil(t, dedup.get([]byte("a")))
+}
+
+func TestPublishDeduplicatorDisabled(t *testing.T) {
+	dedup := NewPublishDeduplicator(test.NewFakeClock(), 0)
+	require.Nil(t, dedup)
+
+	dedup.add([]byte("a"), &message_api.OriginatorEnvelope{})
+	require.Nil(t, dedup.get([]byte("a")))
+}
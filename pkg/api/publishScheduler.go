@@ -0,0 +1,144 @@
+package api
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	mlsv1 "github.com/xmtp/xmtpd/pkg/proto/mls/api/v1"
+	"github.com/xmtp/xmtpd/pkg/proto/xmtpv4/message_api"
+	"github.com/xmtp/xmtpd/pkg/utils"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+)
+
+// An envelope the node publishes to a topic on a fixed interval, such as a heartbeat or
+// a connectivity probe
+type ScheduledPublish struct {
+	Interval time.Duration
+	Topic    []byte
+}
+
+// Parses a scheduled publish in the form <interval>:<hex topic>, e.g. 30s:0a0b0c
+func ParseScheduledPublish(value string) (ScheduledPublish, error) {
+	intervalStr, topicStr, ok := strings.Cut(value, ":")
+	if !ok {
+		return ScheduledPublish{}, fmt.Errorf(
+			"invalid scheduled publish %q, expected <interval>:<hex topic>",
+			value,
+		)
+	}
+	interval, err := time.ParseDuration(intervalStr)
+	if err != nil {
+		return ScheduledPublish{}, fmt.Errorf("invalid scheduled publish interval: %v", err)
+	}
+	if interval <= 0 {
+		return ScheduledPublish{}, fmt.Errorf("scheduled publish interval must be positive")
+	}
+	topic, err := hex.DecodeString(topicStr)
+	if err != nil {
+		return ScheduledPublish{}, fmt.Errorf("invalid scheduled publish topic: %v", err)
+	}
+	if len(topic) == 0 {
+		return ScheduledPublish{}, fmt.Errorf("scheduled publish topic must not be empty")
+	}
+
+	return ScheduledPublish{Interval: interval, Topic: topic}, nil
+}
+
+/*
+The PublishScheduler publishes envelopes on behalf of the node itself. Scheduled envelopes
+go through the same PublishEnvelope path as client envelopes, with the node acting as payer.
+Their payload is a group message whose data is the time it was published at, as big-endian
+Unix nanoseconds.
+
+Read-only nodes can't publish, so nothing is scheduled on them.
+*/
+type PublishScheduler struct {
+	ctx     context.Context
+	log     *zap.Logger
+	clock   utils.Clock
+	service *Service
+}
+
+func StartPublishScheduler(
+	ctx context.Context,
+	log *zap.Logger,
+	service *Service,
+	clock utils.Clock,
+	jobs []ScheduledPublish,
+) *PublishScheduler {
+	s := &PublishScheduler{
+		ctx:     ctx,
+		log:     log.Named("publishScheduler"),
+		clock:   clock,
+		service: service,
+	}
+	if service.worker == nil {
+		if len(jobs) > 0 {
+			s.log.Warn("Node is read-only, scheduled publishes are disabled")
+		}
+		return s
+	}
+	for _, job := range jobs {
+		go s.run(job)
+	}
+	return s
+}
+
+func (s *PublishScheduler) run(job ScheduledPublish) {
+	logger := s.log.With(
+		zap.String("topic", hex.EncodeToString(job.Topic)),
+		zap.Duration("interval", job.Interval),
+	)
+	logger.Info("Scheduling publish")
+
+	ticker := s.clock.NewTicker(job.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C():
+			if err := s.publish(job.Topic); err != nil {
+				logger.Error("Failed to publish scheduled envelope", zap.Error(err))
+			}
+		}
+	}
+}
+
+func (s *PublishScheduler) publish(topic []byte) error {
+	// The publish time makes every envelope unique, so that deduplication only collapses
+	// retries of the same scheduled publish
+	publishedAt := binary.BigEndian.AppendUint64(nil, uint64(s.clock.Now().UnixNano()))
+	clientBytes, err := proto.Marshal(&message_api.ClientEnvelope{
+		Payload: &message_api.ClientEnvelope_GroupMessage{
+			GroupMessage: &mlsv1.GroupMessageInput{
+				Version: &mlsv1.GroupMessageInput_V1_{
+					V1: &mlsv1.GroupMessageInput_V1{Data: publishedAt},
+				},
+			},
+		},
+		Aad: &message_api.AuthenticatedData{
+			TargetOriginator: uint32(s.service.registrant.NodeID()),
+			TargetTopic:      topic,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	payerEnv, err := s.service.registrant.SignPayerEnvelope(clientBytes)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.service.publishEnvelope(
+		s.ctx,
+		&message_api.PublishEnvelopeRequest{PayerEnvelope: payerEnv},
+		s.service.dedup,
+	)
+	return err
+}
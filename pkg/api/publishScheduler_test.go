@@ -0,0 +1,70 @@
+package api
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xmtp/xmtpd/pkg/db/queries"
+	test "github.com/xmtp/xmtpd/pkg/testing"
+)
+
+func TestParseScheduledPublish(t *testing.T) {
+	scheduledPublish, err := ParseScheduledPublish("30s:0a0b")
+	require.NoError(t, err)
+	require.Equal(t, 30*time.Second, scheduledPublish.Interval)
+	require.Equal(t, []byte{0x0a, 0x0b}, scheduledPublish.Topic)
+}
+
+func TestParseScheduledPublishInvalid(t *testing.T) {
+	for value, expectedErr := range map[string]string{
+		"30s":      "expected <interval>:<hex topic>",
+		"soon:0a":  "interval",
+		"-1s:0a":   "positive",
+		"30s:zz":   "topic",
+		"30s:":     "empty",
+		":0a0b0c0": "interval",
+	} {
+		_, err := ParseScheduledPublish(value)
+		require.ErrorContains(t, err, expectedErr, value)
+	}
+}
+
+func TestScheduledPublish(t *testing.T) {
+	svc, db, cleanup := newTestService(t)
+	defer cleanup()
+	svc.dedup = NewPublishDeduplicator(test.NewFakeClock(), time.Hour)
+
+	clock := test.NewFakeClock()
+	StartPublishScheduler(svc.ctx, svc.log, svc, clock, []ScheduledPublish{
+		{Interval: time.Minute, Topic: []byte{0x5}},
+	})
+	require.Eventually(t, func() bool {
+		return clock.TickerCount() == 1
+	}, time.Second, time.Millisecond)
+
+	// Each scheduled envelope is unique, so none are deduplicated
+	for expected := 1; expected <= 2; expected++ {
+		clock.Advance(time.Minute)
+		require.Eventually(t, func() bool {
+			envs, err := queries.New(db).
+				SelectGatewayEnvelopes(context.Background(), queries.SelectGatewayEnvelopesParams{})
+			require.NoError(t, err)
+			return len(envs) == expected && envs[expected-1].Topic[0] == 0x5
+		}, 500*time.Millisecond, 50*time.Millisecond)
+	}
+}
+
+func TestScheduledPublishReadOnly(t *testing.T) {
+	svc, _, cleanup := newTestService(t)
+	defer cleanup()
+	svc.worker = nil
+
+	clock := test.NewFakeClock()
+	StartPublishScheduler(svc.ctx, svc.log, svc, clock, []ScheduledPublish{
+		{Interval: time.Minute, Topic: []byte{0x5}},
+	})
+	time.Sleep(50 * time.Millisecond)
+	require.Zero(t, clock.TickerCount())
+}
@@ -3,6 +3,8 @@ package api
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/xmtp/xmtpd/pkg/db"
@@ -12,14 +14,18 @@ import (
 	"google.golang.org/protobuf/proto"
 )
 
+const flushPollInterval = 50 * time.Millisecond
+
 type PublishWorker struct {
 	ctx          context.Context
 	log          *zap.Logger
 	listener     <-chan []queries.StagedOriginatorEnvelope
 	notifier     chan<- bool
 	registrant   *registrant.Registrant
+	stopped      chan struct{}
 	store        *sql.DB
 	subscription db.DBSubscription[queries.StagedOriginatorEnvelope]
+	writerLock   *db.WriterLock
 }
 
 func StartPublishWorker(
@@ -27,6 +33,7 @@ func StartPublishWorker(
 	log *zap.Logger,
 	reg *registrant.Registrant,
 	store *sql.DB,
+	writerLock *db.WriterLock,
 ) (*PublishWorker, error) {
 	q := queries.New(store)
 	query := func(ctx context.Context, lastSeenID int64, numRows int32) ([]queries.StagedOriginatorEnvelope, int64, error) {
@@ -65,7 +72,9 @@ func StartPublishWorker(
 		subscription: *subscription,
 		listener:     listener,
 		registrant:   reg,
+		stopped:      make(chan struct{}),
 		store:        store,
+		writerLock:   writerLock,
 	}
 	go worker.start()
 
@@ -79,14 +88,52 @@ func (p *PublishWorker) NotifyStagedPublish() {
 	}
 }
 
+// Waits until no envelopes are left staged. The worker must still be running
+func (p *PublishWorker) flush(ctx context.Context) error {
+	q := queries.New(p.store)
+	ticker := time.NewTicker(flushPollInterval)
+	defer ticker.Stop()
+	for {
+		staged, err := q.SelectStagedOriginatorEnvelopes(
+			ctx,
+			queries.SelectStagedOriginatorEnvelopesParams{LastSeenID: 0, NumRows: 1},
+		)
+		if err != nil {
+			return err
+		}
+		if len(staged) == 0 {
+			return nil
+		}
+		p.NotifyStagedPublish()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-p.stopped:
+			return fmt.Errorf("publish worker stopped with envelopes still staged")
+		case <-ticker.C:
+		}
+	}
+}
+
 func (p *PublishWorker) start() {
+	defer close(p.stopped)
 	for {
 		select {
 		case <-p.ctx.Done():
 			return
 		case new_batch := <-p.listener:
 			for _, stagedEnv := range new_batch {
-				for !p.publishStagedEnvelope(stagedEnv) {
+				for {
+					err := p.publishStagedEnvelope(stagedEnv)
+					if err == nil {
+						break
+					}
+					if errors.Is(err, db.ErrStaleFencingToken) {
+						// Retrying cannot succeed once another process holds the
+						// writer lock. Liveness fails so that the node is restarted
+						p.log.Error("Writer lock was lost, stopping publish worker")
+						return
+					}
 					// Infinite retry on failure to publish; we cannot
 					// continue to the next envelope until this one is processed
 					time.Sleep(time.Second)
@@ -96,7 +143,7 @@ func (p *PublishWorker) start() {
 	}
 }
 
-func (p *PublishWorker) publishStagedEnvelope(stagedEnv queries.StagedOriginatorEnvelope) bool {
+func (p *PublishWorker) publishStagedEnvelope(stagedEnv queries.StagedOriginatorEnvelope) error {
 	logger := p.log.With(zap.Int64("sequenceID", stagedEnv.ID))
 	originatorEnv, err := p.registrant.SignStagedEnvelope(stagedEnv)
 	if err != nil {
@@ -104,44 +151,47 @@ func (p *PublishWorker) publishStagedEnvelope(stagedEnv queries.StagedOriginator
 			"Failed to sign staged envelope",
 			zap.Error(err),
 		)
-		return false
+		return err
 	}
 	originatorBytes, err := proto.Marshal(originatorEnv)
 	if err != nil {
 		logger.Error("Failed to marshal originator envelope", zap.Error(err))
-		return false
+		return err
 	}
 
-	q := queries.New(p.store)
-
+	// The gateway envelope is stored and the staged one deleted in one fenced transaction,
+	// so that a process that lost the writer lock changes neither.
 	// On unique constraint conflicts, no error is thrown, but numRows is 0
-	inserted, err := q.InsertGatewayEnvelope(
-		p.ctx,
-		queries.InsertGatewayEnvelopeParams{
-			OriginatorID:         int32(p.registrant.NodeID()),
-			OriginatorSequenceID: stagedEnv.ID,
-			Topic:                stagedEnv.Topic,
-			OriginatorEnvelope:   originatorBytes,
-		},
-	)
+	var inserted, deleted int64
+	err = p.writerLock.RunFenced(p.ctx, func(fenced *queries.Queries) error {
+		inserted, err = fenced.InsertGatewayEnvelope(
+			p.ctx,
+			queries.InsertGatewayEnvelopeParams{
+				OriginatorID:         int32(p.registrant.NodeID()),
+				OriginatorSequenceID: stagedEnv.ID,
+				Topic:                stagedEnv.Topic,
+				OriginatorEnvelope:   originatorBytes,
+			},
+		)
+		if err != nil {
+			return err
+		}
+		// Delete the row regardless of if the gateway envelope was inserted elsewhere
+		deleted, err = fenced.DeleteStagedOriginatorEnvelope(p.ctx, stagedEnv.ID)
+		return err
+	})
 	if err != nil {
-		logger.Error("Failed to insert gateway envelope", zap.Error(err))
-		return false
-	} else if inserted == 0 {
+		logger.Error("Failed to store gateway envelope", zap.Error(err))
+		return err
+	}
+	if inserted == 0 {
 		// Envelope was already inserted by another worker
 		logger.Debug("Envelope already inserted")
 	}
-
-	// Try to delete the row regardless of if the gateway envelope was inserted elsewhere
-	deleted, err := q.DeleteStagedOriginatorEnvelope(context.Background(), stagedEnv.ID)
-	if err != nil {
-		logger.Error("Failed to delete staged envelope", zap.Error(err))
-		// Envelope is already inserted, so it is safe to continue
-		return true
-	} else if deleted == 0 {
+	if deleted == 0 {
 		// Envelope was already deleted by another worker
 		logger.Debug("Envelope already deleted")
 	}
 
-	return true
+	return nil
 }
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+
+	"github.com/xmtp/xmtpd/pkg/envelopes"
+	"github.com/xmtp/xmtpd/pkg/proto/xmtpv4/message_api"
+	"github.com/xmtp/xmtpd/pkg/registrant"
+	"github.com/xmtp/xmtpd/pkg/registry"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Response headers carrying the ID of the node that served a query, and its hex encoded
+// signature over the query and response. The HTTP gateway forwards them prefixed with
+// Grpc-Metadata-
+const (
+	ResponseNodeIDHeader    = "xmtpd-node-id"
+	ResponseSignatureHeader = "xmtpd-response-signature"
+)
+
+/*
+*
+Signs every QueryEnvelopes response with the node's signing key, so that clients can detect
+responses altered by intermediaries. Subscriptions are not signed, because stream messages
+have no metadata of their own. Clients can still verify each envelope's originator
+signature.
+
+A nil signer disables signing.
+*/
+func responseSigningUnaryInterceptor(
+	log *zap.Logger,
+	signer *registrant.Registrant,
+) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		resp, err := handler(ctx, req)
+		if err != nil || signer == nil {
+			return resp, err
+		}
+		queryReq, ok := req.(*message_api.QueryEnvelopesRequest)
+		if !ok {
+			return resp, nil
+		}
+		queryResp, ok := resp.(*message_api.QueryEnvelopesResponse)
+		if !ok {
+			return resp, nil
+		}
+
+		sig, err := signer.SignQueryResponse(queryReq, queryResp)
+		if err != nil {
+			requestLogger(ctx, log).Error("could not sign query response", zap.Error(err))
+			return resp, nil
+		}
+		_ = grpc.SetHeader(ctx, metadata.Pairs(
+			ResponseNodeIDHeader, strconv.Itoa(int(signer.NodeID())),
+			ResponseSignatureHeader, hex.EncodeToString(sig),
+		))
+		return resp, nil
+	}
+}
+
+/*
+*
+Verifies the signature a node attached to its response to req, against the signing key the
+node is registered with. header is the response header, as captured with grpc.Header.
+Returns the ID of the node that signed the response.
+*/
+func VerifyQueryResponse(
+	nodeRegistry registry.NodeRegistry,
+	header metadata.MD,
+	req *message_api.QueryEnvelopesRequest,
+	resp *message_api.QueryEnvelopesResponse,
+) (uint16, error) {
+	nodeIDs := header.Get(ResponseNodeIDHeader)
+	sigs := header.Get(ResponseSignatureHeader)
+	if len(nodeIDs) != 1 || len(sigs) != 1 {
+		return 0, fmt.Errorf("response is not signed")
+	}
+	nodeID, err := strconv.ParseUint(nodeIDs[0], 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid node ID %q: %v", nodeIDs[0], err)
+	}
+	sig, err := hex.DecodeString(sigs[0])
+	if err != nil {
+		return 0, fmt.Errorf("invalid response signature: %v", err)
+	}
+
+	nodes, err := nodeRegistry.GetNodes()
+	if err != nil {
+		return 0, err
+	}
+	for _, node := range nodes {
+		if node.NodeID == uint16(nodeID) {
+			// The registry holds no key for nodes registered with an invalid one
+			if node.SigningKey == nil {
+				return 0, fmt.Errorf("node %d has no valid signing key", nodeID)
+			}
+			err := envelopes.VerifyQueryResponse(req, resp, sig, node.SigningKey)
+			return node.NodeID, err
+		}
+	}
+	return 0, fmt.Errorf("node %d is not registered", nodeID)
+}
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/require"
+	"github.com/xmtp/xmtpd/pkg/envelopes"
+	"github.com/xmtp/xmtpd/pkg/proto/xmtpv4/message_api"
+	"github.com/xmtp/xmtpd/pkg/registry"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestVerifyQueryResponseRegistry(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	req := &message_api.QueryEnvelopesRequest{
+		Query: &message_api.EnvelopesQuery{
+			Filter: &message_api.EnvelopesQuery_Topic{Topic: []byte("topic")},
+		},
+	}
+	resp := &message_api.QueryEnvelopesResponse{}
+	digest, err := envelopes.QueryResponseDigest(req, resp)
+	require.NoError(t, err)
+	sig, err := crypto.Sign(digest, key)
+	require.NoError(t, err)
+	header := func(nodeID string) metadata.MD {
+		return metadata.Pairs(
+			ResponseNodeIDHeader, nodeID,
+			ResponseSignatureHeader, hex.EncodeToString(sig),
+		)
+	}
+
+	nodeRegistry := registry.NewFixedNodeRegistry([]registry.Node{
+		{NodeID: 100, SigningKey: &key.PublicKey},
+		// Registered with an invalid public key
+		{NodeID: 200},
+	})
+
+	nodeID, err := VerifyQueryResponse(nodeRegistry, header("100"), req, resp)
+	require.NoError(t, err)
+	require.EqualValues(t, 100, nodeID)
+
+	_, err = VerifyQueryResponse(nodeRegistry, header("200"), req, resp)
+	require.ErrorContains(t, err, "node 200 has no valid signing key")
+	_, err = VerifyQueryResponse(nodeRegistry, header("300"), req, resp)
+	require.ErrorContains(t, err, "node 300 is not registered")
+	_, err = VerifyQueryResponse(nodeRegistry, metadata.MD{}, req, resp)
+	require.ErrorContains(t, err, "not signed")
+}
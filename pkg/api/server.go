@@ -2,19 +2,34 @@ package api
 
 import (
 	"context"
+	"crypto/tls"
 	"database/sql"
+	"errors"
 	"fmt"
 	"net"
+	"net/http"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	"github.com/pires/go-proxyproto"
+	"github.com/xmtp/xmtpd/pkg/audit"
+	"github.com/xmtp/xmtpd/pkg/config"
+	"github.com/xmtp/xmtpd/pkg/db"
+	"github.com/xmtp/xmtpd/pkg/envelopes"
+	"github.com/xmtp/xmtpd/pkg/overload"
+	"github.com/xmtp/xmtpd/pkg/payer"
 	"github.com/xmtp/xmtpd/pkg/proto/xmtpv4/message_api"
+	"github.com/xmtp/xmtpd/pkg/ratelimit"
 	"github.com/xmtp/xmtpd/pkg/registrant"
+	"github.com/xmtp/xmtpd/pkg/registry"
+	"github.com/xmtp/xmtpd/pkg/spam"
 	"github.com/xmtp/xmtpd/pkg/tracing"
+	"github.com/xmtp/xmtpd/pkg/utils"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/health"
 	healthgrpc "google.golang.org/grpc/health/grpc_health_v1"
@@ -22,62 +37,151 @@ import (
 )
 
 type ApiServer struct {
-	ctx          context.Context
-	db           *sql.DB
-	grpcListener net.Listener
-	log          *zap.Logger
-	registrant   *registrant.Registrant
-	service      message_api.ReplicationApiServer
-	wg           sync.WaitGroup
+	ctx                 context.Context
+	db                  *sql.DB
+	gatewayConn         *grpc.ClientConn
+	gatewayGrpcListener net.Listener
+	grpcListener        net.Listener
+	grpcServers         []*grpc.Server
+	healthcheck         *health.Server
+	httpServer          *http.Server
+	log                 *zap.Logger
+	registrant          *registrant.Registrant
+	service             *Service
+	wg                  sync.WaitGroup
 }
 
 func NewAPIServer(
 	ctx context.Context,
 	writerDB *sql.DB,
 	log *zap.Logger,
-	port int,
+	options config.ApiOptions,
 	registrant *registrant.Registrant,
-) (*ApiServer, error) {
-	grpcListener, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", port))
+	nodeRegistry registry.NodeRegistry,
+	writerLock *db.WriterLock,
+	spamFilter *spam.Filter,
+	payerVerifier payer.Verifier,
+	validator *envelopes.Pipeline,
+	rateLimiter *ratelimit.Limiter,
+	overloadMonitor *overload.Monitor,
+	auditor *audit.Auditor,
+) (_ *ApiServer, err error) {
+	if writerLock == nil && len(options.ScheduledPublishes) > 0 {
+		return nil, fmt.Errorf("scheduled publishes are not supported on read-only nodes")
+	}
+	scheduledPublishes := make([]ScheduledPublish, 0, len(options.ScheduledPublishes))
+	for _, value := range options.ScheduledPublishes {
+		scheduledPublish, err := ParseScheduledPublish(value)
+		if err != nil {
+			return nil, err
+		}
+		scheduledPublishes = append(scheduledPublishes, scheduledPublish)
+	}
 
+	grpcListener, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", options.Port))
+
+	if err != nil {
+		return nil, err
+	}
+	proxyListener, err := newProxyListener(grpcListener, options.TrustedProxies)
 	if err != nil {
+		_ = grpcListener.Close()
 		return nil, err
 	}
 	s := &ApiServer{
-		ctx: ctx,
-		db:  writerDB,
-		grpcListener: &proxyproto.Listener{
-			Listener:          grpcListener,
-			ReadHeaderTimeout: 10 * time.Second,
-		},
-		log:        log.Named("api"),
-		registrant: registrant,
-		wg:         sync.WaitGroup{},
+		ctx:          ctx,
+		db:           writerDB,
+		grpcListener: proxyListener,
+		log:          log.Named("api"),
+		registrant:   registrant,
+		wg:           sync.WaitGroup{},
+	}
+	defer func() {
+		// Frees the ports, since the caller has no server to close
+		if err != nil {
+			s.Close()
+			_ = s.grpcListener.Close()
+		}
+	}()
+
+	tlsConfig, err := newTLSConfig(options.TLS)
+	if err != nil {
+		return nil, err
+	}
+	creds := insecure.NewCredentials()
+	if tlsConfig != nil {
+		creds = credentials.NewTLS(tlsConfig)
 	}
 
-	// TODO: Add interceptors
+	responseSigner := registrant
+	if !options.SignResponses {
+		responseSigner = nil
+	}
 
-	options := []grpc.ServerOption{
-		grpc.Creds(insecure.NewCredentials()),
+	serverOptions := []grpc.ServerOption{
 		grpc.KeepaliveParams(keepalive.ServerParameters{
-			Time: 5 * time.Minute,
+			Time:              options.KeepaliveInterval,
+			Timeout:           options.KeepaliveTimeout,
+			MaxConnectionIdle: options.MaxConnectionIdle,
 		}),
 		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
 			PermitWithoutStream: true,
 			MinTime:             15 * time.Second,
 		}),
+		grpc.ChainUnaryInterceptor(
+			requestIDUnaryInterceptor(s.log),
+			metricsUnaryInterceptor(),
+			auditUnaryInterceptor(auditor),
+			overloadUnaryInterceptor(overloadMonitor),
+			rateLimitUnaryInterceptor(rateLimiter),
+			timeoutUnaryInterceptor(options.RequestTimeout),
+			responseSigningUnaryInterceptor(s.log, responseSigner),
+		),
+		grpc.ChainStreamInterceptor(
+			requestIDStreamInterceptor(s.log),
+			metricsStreamInterceptor(),
+			auditStreamInterceptor(auditor),
+			overloadStreamInterceptor(overloadMonitor),
+			rateLimitStreamInterceptor(rateLimiter),
+		),
 		// grpc.MaxRecvMsgSize(s.Config.Options.MaxMsgSize),
 	}
-	grpcServer := grpc.NewServer(options...)
+	grpcServer := grpc.NewServer(append(serverOptions, grpc.Creds(creds))...)
+	s.grpcServers = append(s.grpcServers, grpcServer)
 
-	healthcheck := health.NewServer()
-	healthgrpc.RegisterHealthServer(grpcServer, healthcheck)
+	s.healthcheck = health.NewServer()
+	healthgrpc.RegisterHealthServer(grpcServer, s.healthcheck)
 
-	replicationService, err := NewReplicationApiService(ctx, log, registrant, writerDB)
+	replicationService, err := NewReplicationApiService(
+		ctx,
+		log,
+		registrant,
+		writerDB,
+		writerLock,
+		spamFilter,
+		payerVerifier,
+		validator,
+		envelopes.Limits{
+			MaxClientEnvelopeSize: options.MaxEnvelopeSize,
+			MaxTopicLength:        options.MaxTopicLength,
+		},
+		NewIntegrityChecker(log, nodeRegistry, options.IntegritySampleRate),
+		NewPublishDeduplicator(utils.RealClock{}, options.PublishDedupWindow),
+		options.Subscribe,
+	)
 	if err != nil {
 		return nil, err
 	}
 	s.service = replicationService
+	message_api.RegisterReplicationApiServer(grpcServer, replicationService)
+
+	StartPublishScheduler(
+		ctx,
+		log,
+		replicationService,
+		utils.RealClock{},
+		scheduledPublishes,
+	)
 
 	tracing.GoPanicWrap(s.ctx, &s.wg, "grpc", func(ctx context.Context) {
 		s.log.Info("serving grpc", zap.String("address", s.grpcListener.Addr().String()))
@@ -87,22 +191,201 @@ func NewAPIServer(
 		}
 	})
 
+	if options.HTTPPort > 0 {
+		// The gateway is served its own plaintext gRPC server over loopback, so that it is
+		// not subject to the client certificate requirements of the public listener
+		gatewayServer := grpc.NewServer(
+			append(serverOptions, grpc.Creds(insecure.NewCredentials()))...,
+		)
+		message_api.RegisterReplicationApiServer(gatewayServer, replicationService)
+		s.grpcServers = append(s.grpcServers, gatewayServer)
+		err = s.startHTTPGateway(
+			options.HTTPPort,
+			options.DeadlineHeader,
+			gatewayServer,
+			httpTLSConfig(tlsConfig),
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return s, nil
 }
 
+/*
+*
+Wraps listener to read client addresses from PROXY protocol headers sent by trustedProxies.
+Headers from anyone else are ignored, since they could claim any client address.
+*/
+func newProxyListener(listener net.Listener, trustedProxies []string) (net.Listener, error) {
+	policy, err := proxyproto.LaxWhiteListPolicy(trustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trusted proxy: %v", err)
+	}
+	return &proxyproto.Listener{
+		Listener:          listener,
+		Policy:            policy,
+		ReadHeaderTimeout: 10 * time.Second,
+	}, nil
+}
+
+/*
+*
+Serves the ReplicationApi as HTTP/JSON, including streaming subscriptions, and subscriptions
+over WebSocket at WebSocketSubscribePath. The gateway forwards each request to grpcServer
+over loopback, so requests go through the same interceptors as native gRPC ones. Timeouts
+sent in deadlineHeader bound requests like grpc-timeout does. HTTP is served over TLS when
+tlsConfig is not nil.
+*/
+func (s *ApiServer) startHTTPGateway(
+	port int,
+	deadlineHeader string,
+	grpcServer *grpc.Server,
+	tlsConfig *tls.Config,
+) error {
+	gatewayGrpcListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	s.gatewayGrpcListener = gatewayGrpcListener
+	tracing.GoPanicWrap(s.ctx, &s.wg, "gateway-grpc", func(ctx context.Context) {
+		err := grpcServer.Serve(gatewayGrpcListener)
+		if err != nil && !isErrUseOfClosedConnection(err) {
+			s.log.Error("serving gateway grpc", zap.Error(err))
+		}
+	})
+
+	httpListener, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", port))
+	if err != nil {
+		return err
+	}
+
+	grpcAddr := s.gatewayGrpcListener.Addr().String()
+	gatewayMux := runtime.NewServeMux()
+	err = message_api.RegisterReplicationApiHandlerFromEndpoint(
+		s.ctx,
+		gatewayMux,
+		grpcAddr,
+		[]grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())},
+	)
+	if err != nil {
+		return err
+	}
+	s.gatewayConn, err = grpc.NewClient(
+		grpcAddr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(
+		WebSocketSubscribePath,
+		NewWebSocketSubscribeHandler(s.log, message_api.NewReplicationApiClient(s.gatewayConn)),
+	)
+	mux.Handle("/", deadlineHandler(deadlineHeader, gatewayMux))
+
+	s.httpServer = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		TLSConfig:         tlsConfig,
+		BaseContext: func(net.Listener) context.Context {
+			return s.ctx
+		},
+	}
+	tracing.GoPanicWrap(s.ctx, &s.wg, "http", func(ctx context.Context) {
+		s.log.Info(
+			"serving http",
+			zap.String("address", httpListener.Addr().String()),
+			zap.Bool("tls", tlsConfig != nil),
+		)
+		var err error
+		if tlsConfig != nil {
+			err = s.httpServer.ServeTLS(httpListener, "", "")
+		} else {
+			err = s.httpServer.Serve(httpListener)
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.log.Error("serving http", zap.Error(err))
+		}
+	})
+
+	return nil
+}
+
 func (s *ApiServer) Addr() net.Addr {
 	return s.grpcListener.Addr()
 }
 
+/*
+*
+Stops accepting requests and waits up to timeout for in-flight ones to finish. Open
+subscriptions are ended with Unavailable, so that clients reconnect to another node, and
+staged publishes are stored before the server closes. Anything still running when the
+timeout expires is cut off. A timeout of 0 closes immediately.
+*/
+func (s *ApiServer) Shutdown(timeout time.Duration) {
+	if timeout <= 0 {
+		s.Close()
+		return
+	}
+	s.log.Info("draining", zap.Duration("timeout", timeout))
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	s.healthcheck.Shutdown()
+	s.service.drain()
+
+	stopped := make(chan struct{})
+	go func() {
+		var wg sync.WaitGroup
+		for _, grpcServer := range s.grpcServers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				grpcServer.GracefulStop()
+			}()
+		}
+		if s.httpServer != nil {
+			if err := s.httpServer.Shutdown(ctx); err != nil {
+				s.log.Warn("draining http server", zap.Error(err))
+			}
+		}
+		wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		s.log.Warn("requests were still running when the drain timeout expired")
+	}
+
+	if err := s.service.flushStagedPublishes(ctx); err != nil {
+		s.log.Warn("staged publishes were not all stored", zap.Error(err))
+	}
+
+	s.Close()
+}
+
 func (s *ApiServer) Close() {
 	s.log.Info("closing")
 
-	if s.grpcListener != nil {
-		err := s.grpcListener.Close()
-		if err != nil {
-			s.log.Error("closing grpc listener", zap.Error(err))
+	if s.httpServer != nil {
+		err := s.httpServer.Close()
+		if err != nil && !isErrUseOfClosedConnection(err) {
+			s.log.Error("closing http server", zap.Error(err))
+		}
+	}
+	// Stopping a server also closes its listener
+	for _, grpcServer := range s.grpcServers {
+		grpcServer.Stop()
+	}
+	if s.gatewayConn != nil {
+		if err := s.gatewayConn.Close(); err != nil {
+			s.log.Error("closing gateway connection", zap.Error(err))
 		}
-		s.grpcListener = nil
 	}
 
 	s.wg.Wait()
@@ -3,25 +3,54 @@ package api
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
 
+	"github.com/xmtp/xmtpd/pkg/config"
+	"github.com/xmtp/xmtpd/pkg/db"
 	"github.com/xmtp/xmtpd/pkg/db/queries"
+	"github.com/xmtp/xmtpd/pkg/envelopes"
+	"github.com/xmtp/xmtpd/pkg/metrics"
+	"github.com/xmtp/xmtpd/pkg/payer"
 	"github.com/xmtp/xmtpd/pkg/proto/xmtpv4/message_api"
 	"github.com/xmtp/xmtpd/pkg/registrant"
+	"github.com/xmtp/xmtpd/pkg/spam"
+	"github.com/xmtp/xmtpd/pkg/utils"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
 
 	"go.uber.org/zap"
 )
 
+const (
+	// Maximum number of envelopes returned by a single query
+	maxRequestedRows uint32 = 1000
+)
+
 type Service struct {
 	message_api.UnimplementedReplicationApiServer
 
-	ctx        context.Context
-	log        *zap.Logger
-	registrant *registrant.Registrant
-	store      *sql.DB
-	worker     *PublishWorker
+	ctx             context.Context
+	dedup           *PublishDeduplicator
+	draining        chan struct{}
+	drainOnce       sync.Once
+	integrity       *IntegrityChecker
+	limits          envelopes.Limits
+	log             *zap.Logger
+	payerVerifier   payer.Verifier
+	registrant      *registrant.Registrant
+	slowPolicy      string
+	spamFilter      *spam.Filter
+	store           *sql.DB
+	subscribeWorker *subscribeWorker
+	validator       *envelopes.Pipeline
+	worker          *PublishWorker
+	writerLock      *db.WriterLock
 }
 
 func NewReplicationApiService(
@@ -29,17 +58,68 @@ func NewReplicationApiService(
 	log *zap.Logger,
 	registrant *registrant.Registrant,
 	store *sql.DB,
+	writerLock *db.WriterLock,
+	spamFilter *spam.Filter,
+	payerVerifier payer.Verifier,
+	validator *envelopes.Pipeline,
+	limits envelopes.Limits,
+	integrity *IntegrityChecker,
+	dedup *PublishDeduplicator,
+	subscribeOptions config.SubscribeOptions,
 ) (*Service, error) {
-	worker, err := StartPublishWorker(ctx, log, registrant, store)
+	slowPolicy := subscribeOptions.SlowPolicy
+	switch slowPolicy {
+	case "":
+		slowPolicy = SlowSubscriberDisconnect
+	case SlowSubscriberDisconnect, SlowSubscriberDropOldest, SlowSubscriberCatchUp:
+	default:
+		return nil, fmt.Errorf("invalid slow subscriber policy %q", slowPolicy)
+	}
+	if subscribeOptions.BufferSize < 0 {
+		return nil, fmt.Errorf("subscriber buffer size must not be negative")
+	}
+	if limits.MaxClientEnvelopeSize <= 0 {
+		return nil, fmt.Errorf("max envelope size must be positive")
+	}
+	if limits.MaxTopicLength <= 0 {
+		return nil, fmt.Errorf("max topic length must be positive")
+	}
+
+	// Without the writer lock the node cannot store envelopes, so it only serves reads
+	var worker *PublishWorker
+	if writerLock != nil {
+		var err error
+		worker, err = StartPublishWorker(ctx, log, registrant, store, writerLock)
+		if err != nil {
+			return nil, err
+		}
+	}
+	subscribeWorker, err := startSubscribeWorker(
+		ctx,
+		log,
+		registrant.NodeID(),
+		store,
+		subscribeOptions,
+	)
 	if err != nil {
 		return nil, err
 	}
 	return &Service{
-		ctx:        ctx,
-		log:        log,
-		registrant: registrant,
-		store:      store,
-		worker:     worker,
+		ctx:             ctx,
+		dedup:           dedup,
+		draining:        make(chan struct{}),
+		integrity:       integrity,
+		limits:          limits,
+		log:             log,
+		payerVerifier:   payerVerifier,
+		registrant:      registrant,
+		slowPolicy:      slowPolicy,
+		spamFilter:      spamFilter,
+		store:           store,
+		subscribeWorker: subscribeWorker,
+		validator:       validator,
+		worker:          worker,
+		writerLock:      writerLock,
 	}, nil
 }
 
@@ -47,25 +127,363 @@ func (s *Service) Close() {
 	s.log.Info("closed")
 }
 
+// Ends every open subscription, so that clients reconnect to another node
+func (s *Service) drain() {
+	s.drainOnce.Do(func() {
+		close(s.draining)
+	})
+}
+
+// Waits until the publish worker has stored every staged envelope
+func (s *Service) flushStagedPublishes(ctx context.Context) error {
+	if s.worker == nil {
+		return nil
+	}
+	return s.worker.flush(ctx)
+}
+
 func (s *Service) BatchSubscribeEnvelopes(
 	req *message_api.BatchSubscribeEnvelopesRequest,
 	server message_api.ReplicationApi_BatchSubscribeEnvelopesServer,
 ) error {
-	return status.Errorf(codes.Unimplemented, "method BatchSubscribeEnvelopes not implemented")
+	if len(req.GetRequests()) == 0 {
+		return status.Errorf(codes.InvalidArgument, "missing requests")
+	}
+
+	patterns := topicPatternsEnabled(server.Context())
+	topics := make(map[string]bool)
+	prefixes := [][]byte{}
+	originators := make(map[uint32]bool)
+	isFirehose := false
+	catchUpQueries := []*message_api.EnvelopesQuery{}
+	for _, subReq := range req.GetRequests() {
+		query := subReq.GetQuery()
+		if query.GetLastSeen() != nil {
+			catchUpQueries = append(catchUpQueries, query)
+		}
+		switch filter := query.GetFilter().(type) {
+		case *message_api.EnvelopesQuery_Topic:
+			if len(filter.Topic) == 0 {
+				return status.Errorf(codes.InvalidArgument, "missing topic")
+			}
+			prefix, err := topicPrefix(filter.Topic, patterns)
+			if err != nil {
+				return err
+			}
+			if prefix != nil {
+				prefixes = append(prefixes, prefix)
+			} else {
+				topics[string(filter.Topic)] = true
+			}
+		case *message_api.EnvelopesQuery_OriginatorId:
+			originators[filter.OriginatorId] = true
+		case nil:
+			if query.GetLastSeen() != nil {
+				return status.Errorf(
+					codes.InvalidArgument,
+					"cannot resume a subscription to every envelope",
+				)
+			}
+			// A query without a filter subscribes to every envelope
+			isFirehose = true
+		}
+	}
+	// Subscribers without a cursor start after the latest envelope. It is read before
+	// listening, so that a subscriber dropped before anything is sent resumes from a cursor
+	// that covers every envelope the listener could have delivered
+	latestID, err := queries.New(s.store).SelectLatestGatewayEnvelopeID(server.Context())
+	if err != nil {
+		return status.Errorf(codes.Internal, "could not select latest envelope: %v", err)
+	}
+
+	// Listen before catching up, so that nothing inserted in between is missed
+	l, cancel := s.subscribeWorker.listen(isFirehose, topics, prefixes, originators)
+	defer func() {
+		cancel()
+	}()
+	metrics.EmitSubscriberAdded()
+	defer metrics.EmitSubscriberRemoved()
+	defer func() {
+		if dropped := l.dropped.Load(); dropped > 0 {
+			server.SetTrailer(metadata.Pairs(
+				DroppedEnvelopesTrailer, strconv.FormatUint(dropped, 10),
+			))
+		}
+	}()
+
+	// Send headers so that the client knows the subscription is established
+	if err := server.SendHeader(metadata.MD{}); err != nil {
+		return err
+	}
+
+	// Envelopes up to this ID were delivered by the catch up, and are skipped when they
+	// arrive from the listener
+	caughtUpTo := int64(0)
+	if len(catchUpQueries) > 0 {
+		caughtUpTo, err = s.catchUp(server, catchUpQueries)
+		if err != nil {
+			return err
+		}
+	}
+	// The ID of the last envelope the subscriber is known to have, which is where it
+	// resumes from after falling behind
+	cursor := max(caughtUpTo, latestID)
+
+	for {
+		select {
+		case <-server.Context().Done():
+			return nil
+		case <-s.draining:
+			return status.Errorf(codes.Unavailable, "node is shutting down")
+		case envs, ok := <-l.ch:
+			if !ok {
+				if s.ctx.Err() != nil {
+					return status.Errorf(codes.Unavailable, "node is shutting down")
+				}
+				if s.slowPolicy != SlowSubscriberCatchUp || isFirehose {
+					server.SetTrailer(metadata.Pairs(
+						ResumeGatewaySidTrailer,
+						strconv.FormatUint(utils.SID(s.registrant.NodeID(), cursor), 10),
+					))
+					return status.Errorf(codes.ResourceExhausted, "subscriber fell too far behind")
+				}
+
+				// Read everything missed from the database, listening again first for
+				// the same reason as above
+				metrics.EmitSlowSubscriber(SlowSubscriberCatchUp)
+				cancel()
+				l, cancel = s.subscribeWorker.listen(false, topics, prefixes, originators)
+				resumeQueries := make([]*message_api.EnvelopesQuery, 0, len(req.GetRequests()))
+				for _, subReq := range req.GetRequests() {
+					resumeQueries = append(resumeQueries, &message_api.EnvelopesQuery{
+						Filter: subReq.GetQuery().GetFilter(),
+						LastSeen: &message_api.EnvelopesQuery_GatewaySid{
+							GatewaySid: utils.SID(s.registrant.NodeID(), cursor),
+						},
+					})
+				}
+				var err error
+				caughtUpTo, err = s.catchUp(server, resumeQueries)
+				if err != nil {
+					return err
+				}
+				cursor = max(cursor, caughtUpTo)
+				continue
+			}
+			envs = slices.DeleteFunc(envs, func(env *message_api.GatewayEnvelope) bool {
+				return int64(utils.SequenceID(env.GetGatewaySid())) <= caughtUpTo
+			})
+			if len(envs) == 0 {
+				continue
+			}
+			err := server.Send(&message_api.BatchSubscribeEnvelopesResponse{Envelopes: envs})
+			if err != nil {
+				return err
+			}
+			cursor = max(cursor, int64(utils.SequenceID(envs[len(envs)-1].GetGatewaySid())))
+		}
+	}
+}
+
+/*
+Sends every envelope after each query's last_seen cursor, up to the latest envelope at the
+time of the call, and returns the ID of that envelope. A cursor of 0 sends the full
+history of the query. Envelopes matching several queries are only sent once.
+*/
+func (s *Service) catchUp(
+	server message_api.ReplicationApi_BatchSubscribeEnvelopesServer,
+	catchUpQueries []*message_api.EnvelopesQuery,
+) (int64, error) {
+	ctx := server.Context()
+	patterns := topicPatternsEnabled(ctx)
+	q := queries.New(s.store)
+	latestID, err := q.SelectLatestGatewayEnvelopeID(ctx)
+	if err != nil {
+		return 0, status.Errorf(codes.Internal, "could not select latest envelope: %v", err)
+	}
+
+	// Only needed to deduplicate when queries can overlap
+	var sent map[int64]bool
+	if len(catchUpQueries) > 1 {
+		sent = make(map[int64]bool)
+	}
+	for _, query := range catchUpQueries {
+		params, err := s.queryReqToDBParams(
+			&message_api.QueryEnvelopesRequest{Query: query},
+			patterns,
+		)
+		if err != nil {
+			return 0, err
+		}
+		for {
+			rows, err := q.SelectGatewayEnvelopes(ctx, *params)
+			if err != nil {
+				return 0, status.Errorf(codes.Internal, "could not select envelopes: %v", err)
+			}
+			done := len(rows) < int(params.RowLimit.Int32)
+			envs := make([]*message_api.GatewayEnvelope, 0, len(rows))
+			for _, row := range rows {
+				if row.ID > latestID {
+					done = true
+					break
+				}
+				params.GatewaySequenceID = db.NullInt64(row.ID)
+				if sent[row.ID] {
+					continue
+				}
+				if sent != nil {
+					sent[row.ID] = true
+				}
+				env, err := toGatewayEnvelope(s.registrant.NodeID(), row)
+				if err != nil {
+					// We expect to have already validated the envelope when it was inserted
+					requestLogger(ctx, s.log).
+						Error("could not unmarshal originator envelope", zap.Error(err))
+					continue
+				}
+				envs = append(envs, env)
+			}
+			if len(envs) > 0 {
+				err := server.Send(&message_api.BatchSubscribeEnvelopesResponse{Envelopes: envs})
+				if err != nil {
+					return 0, err
+				}
+			}
+			if done {
+				break
+			}
+		}
+	}
+
+	return latestID, nil
 }
 
 func (s *Service) QueryEnvelopes(
 	ctx context.Context,
 	req *message_api.QueryEnvelopesRequest,
 ) (*message_api.QueryEnvelopesResponse, error) {
-	return nil, status.Errorf(codes.Unimplemented, "method QueryEnvelopes not implemented")
+	params, err := s.queryReqToDBParams(req, topicPatternsEnabled(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := queries.New(s.store).SelectGatewayEnvelopes(ctx, *params)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "could not select envelopes: %v", err)
+	}
+
+	envs := make([]*message_api.GatewayEnvelope, 0, len(rows))
+	for _, row := range rows {
+		env, err := toGatewayEnvelope(s.registrant.NodeID(), row)
+		if err != nil {
+			// We expect to have already validated the envelope when it was inserted
+			requestLogger(ctx, s.log).
+				Error("could not unmarshal originator envelope", zap.Error(err))
+			continue
+		}
+		s.integrity.sample(row, env)
+		envs = append(envs, env)
+	}
+
+	return &message_api.QueryEnvelopesResponse{Envelopes: envs}, nil
+}
+
+/*
+Envelopes are returned in the order they were inserted on this node. To page through a
+query, clients pass the gateway SID of the last envelope they received as the cursor.
+
+When patterns is set, topic filters ending in '*' match by prefix.
+*/
+func (s *Service) queryReqToDBParams(
+	req *message_api.QueryEnvelopesRequest,
+	patterns bool,
+) (*queries.SelectGatewayEnvelopesParams, error) {
+	params := queries.SelectGatewayEnvelopesParams{
+		RowLimit: db.NullInt32(int32(maxRequestedRows)),
+	}
+	if limit := req.GetLimit(); limit > 0 && limit < maxRequestedRows {
+		params.RowLimit = db.NullInt32(int32(limit))
+	}
+
+	query := req.GetQuery()
+	if query == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "missing query")
+	}
+
+	switch filter := query.GetFilter().(type) {
+	case *message_api.EnvelopesQuery_Topic:
+		if len(filter.Topic) == 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "missing topic")
+		}
+		prefix, err := topicPrefix(filter.Topic, patterns)
+		if err != nil {
+			return nil, err
+		}
+		if prefix != nil {
+			// A range scan of the topic index
+			params.TopicFrom = prefix
+			params.TopicTo = prefixEnd(prefix)
+		} else {
+			params.Topic = filter.Topic
+		}
+	case *message_api.EnvelopesQuery_OriginatorId:
+		params.OriginatorNodeID = db.NullInt32(int32(filter.OriginatorId))
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "invalid filter")
+	}
+
+	switch lastSeen := query.GetLastSeen().(type) {
+	case *message_api.EnvelopesQuery_GatewaySid:
+		if lastSeen.GatewaySid == 0 {
+			break
+		}
+		if utils.NodeID(lastSeen.GatewaySid) != s.registrant.NodeID() {
+			return nil, status.Errorf(
+				codes.InvalidArgument,
+				"gateway sid was issued by node %d",
+				utils.NodeID(lastSeen.GatewaySid),
+			)
+		}
+		params.GatewaySequenceID = db.NullInt64(utils.SequenceID(lastSeen.GatewaySid))
+	case *message_api.EnvelopesQuery_OriginatorSid:
+		if lastSeen.OriginatorSid == 0 {
+			break
+		}
+		originatorID := int32(utils.NodeID(lastSeen.OriginatorSid))
+		if params.OriginatorNodeID.Valid && params.OriginatorNodeID.Int32 != originatorID {
+			return nil, status.Errorf(
+				codes.InvalidArgument,
+				"originator sid does not match originator filter",
+			)
+		}
+		params.OriginatorNodeID = db.NullInt32(originatorID)
+		params.OriginatorSequenceID = db.NullInt64(utils.SequenceID(lastSeen.OriginatorSid))
+	}
+
+	return &params, nil
 }
 
 func (s *Service) PublishEnvelope(
 	ctx context.Context,
 	req *message_api.PublishEnvelopeRequest,
 ) (*message_api.PublishEnvelopeResponse, error) {
-	clientEnv, err := s.validatePayerInfo(req.GetPayerEnvelope())
+	return s.publishEnvelope(ctx, req, s.dedup)
+}
+
+// Publishes the envelope, answering retries from dedup when it is not nil
+func (s *Service) publishEnvelope(
+	ctx context.Context,
+	req *message_api.PublishEnvelopeRequest,
+	dedup *PublishDeduplicator,
+) (*message_api.PublishEnvelopeResponse, error) {
+	if s.worker == nil {
+		return nil, status.Errorf(
+			codes.FailedPrecondition,
+			"node is read-only, publish to a writable node instead",
+		)
+	}
+
+	clientEnv, err := s.validatePayerInfo(ctx, req.GetPayerEnvelope())
 	if err != nil {
 		return nil, err
 	}
@@ -75,18 +493,40 @@ func (s *Service) PublishEnvelope(
 		return nil, err
 	}
 
-	// TODO(rich): If it is a commit, publish it to blockchain instead
-
-	payerBytes, err := proto.Marshal(req.GetPayerEnvelope())
+	payerBytes, err := proto.MarshalOptions{Deterministic: true}.Marshal(req.GetPayerEnvelope())
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "could not marshal envelope: %v", err)
 	}
 
-	stagedEnv, err := queries.New(s.store).
-		InsertStagedOriginatorEnvelope(ctx, queries.InsertStagedOriginatorEnvelopeParams{
-			Topic:         topic,
-			PayerEnvelope: payerBytes,
-		})
+	// Retries are answered before the spam filter, so that they don't count against the client
+	if originatorEnv := dedup.get(payerBytes); originatorEnv != nil {
+		metrics.EmitPublishDedupHit()
+		return &message_api.PublishEnvelopeResponse{OriginatorEnvelope: originatorEnv}, nil
+	}
+
+	if err = s.checkSpam(topic, clientEnv); err != nil {
+		return nil, err
+	}
+
+	// TODO(rich): If it is a commit, publish it to blockchain instead
+
+	var stagedEnv queries.StagedOriginatorEnvelope
+	err = s.writerLock.RunFenced(ctx, func(fenced *queries.Queries) error {
+		stagedEnv, err = fenced.InsertStagedOriginatorEnvelope(
+			ctx,
+			queries.InsertStagedOriginatorEnvelopeParams{
+				Topic:         topic,
+				PayerEnvelope: payerBytes,
+			},
+		)
+		return err
+	})
+	if errors.Is(err, db.ErrStaleFencingToken) {
+		return nil, status.Errorf(
+			codes.Unavailable,
+			"node lost the writer lock, publish to another node instead",
+		)
+	}
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "could not insert staged envelope: %v", err)
 	}
@@ -96,46 +536,65 @@ func (s *Service) PublishEnvelope(
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "could not sign envelope: %v", err)
 	}
+	dedup.add(payerBytes, originatorEnv)
 
 	return &message_api.PublishEnvelopeResponse{OriginatorEnvelope: originatorEnv}, nil
 }
 
 func (s *Service) validatePayerInfo(
+	ctx context.Context,
 	payerEnv *message_api.PayerEnvelope,
 ) (*message_api.ClientEnvelope, error) {
-	clientBytes := payerEnv.GetUnsignedClientEnvelope()
-	sig := payerEnv.GetPayerSignature()
-	if (clientBytes == nil) || (sig == nil) {
-		return nil, status.Errorf(codes.InvalidArgument, "missing envelope or signature")
-	}
-	// TODO(rich): Verify payer signature
-
-	clientEnv := &message_api.ClientEnvelope{}
-	err := proto.Unmarshal(clientBytes, clientEnv)
+	clientEnv, err := envelopes.ValidatePayerEnvelope(
+		payerEnv,
+		s.limits.MaxClientEnvelopeSize,
+	)
 	if err != nil {
-		return nil, status.Errorf(
-			codes.InvalidArgument,
-			"could not unmarshal client envelope: %v",
-			err,
-		)
+		return nil, err
+	}
+	if _, err := s.payerVerifier.Verify(ctx, payerEnv); err != nil {
+		return nil, err
 	}
-
 	return clientEnv, nil
 }
 
 func (s *Service) validateClientInfo(clientEnv *message_api.ClientEnvelope) ([]byte, error) {
-	if clientEnv.GetAad().GetTargetOriginator() != uint32(s.registrant.NodeID()) {
-		return nil, status.Errorf(codes.InvalidArgument, "invalid target originator")
+	if err := envelopes.ValidateClientEnvelope(
+		clientEnv,
+		uint32(s.registrant.NodeID()),
+		s.limits.MaxTopicLength,
+	); err != nil {
+		return nil, err
 	}
-
-	topic := clientEnv.GetAad().GetTargetTopic()
-	if len(topic) == 0 {
-		return nil, status.Errorf(codes.InvalidArgument, "missing target topic")
+	if err := s.validator.Validate(clientEnv); err != nil {
+		return nil, err
 	}
 
 	// TODO(rich): Verify all originators have synced past `last_originator_sids`
 	// TODO(rich): Check that the blockchain sequence ID is equal to the latest on the group
 	// TODO(rich): Perform any payload-specific validation (e.g. identity updates)
 
-	return topic, nil
+	return clientEnv.GetAad().GetTargetTopic(), nil
+}
+
+func (s *Service) checkSpam(topic []byte, clientEnv *message_api.ClientEnvelope) error {
+	// Score the payload without the authenticated data, so that identical content sent to
+	// different topics is recognized as duplicate
+	aad := clientEnv.GetAad()
+	clientEnv.Aad = nil
+	payload, err := proto.Marshal(clientEnv)
+	clientEnv.Aad = aad
+	if err != nil {
+		return status.Errorf(codes.Internal, "could not marshal client envelope: %v", err)
+	}
+
+	verdict := s.spamFilter.Check(topic, payload)
+	if verdict.IsSpam() && s.spamFilter.Enforced() {
+		return status.Errorf(
+			codes.ResourceExhausted,
+			"envelope rejected by spam filter: %v",
+			verdict.Labels,
+		)
+	}
+	return nil
 }
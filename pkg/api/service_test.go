@@ -3,18 +3,29 @@ package api
 import (
 	"context"
 	"database/sql"
+	"strconv"
 	"testing"
 	"time"
 
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/stretchr/testify/require"
+	"github.com/xmtp/xmtpd/pkg/config"
+	xmtpdDB "github.com/xmtp/xmtpd/pkg/db"
 	"github.com/xmtp/xmtpd/pkg/db/queries"
+	"github.com/xmtp/xmtpd/pkg/envelopes"
 	"github.com/xmtp/xmtpd/pkg/mocks"
+	"github.com/xmtp/xmtpd/pkg/payer"
 	"github.com/xmtp/xmtpd/pkg/proto/identity/associations"
 	"github.com/xmtp/xmtpd/pkg/proto/xmtpv4/message_api"
 	"github.com/xmtp/xmtpd/pkg/registrant"
 	"github.com/xmtp/xmtpd/pkg/registry"
+	"github.com/xmtp/xmtpd/pkg/spam"
 	test "github.com/xmtp/xmtpd/pkg/testing"
+	"github.com/xmtp/xmtpd/pkg/utils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -32,11 +43,31 @@ func newTestService(t *testing.T) (*Service, *sql.DB, func()) {
 	registrant, err := registrant.NewRegistrant(ctx, queries.New(db), mockRegistry, privKeyStr)
 	require.NoError(t, err)
 
-	svc, err := NewReplicationApiService(ctx, log, registrant, db)
+	writerLock, err := xmtpdDB.AcquireWriterLock(ctx, db)
+	require.NoError(t, err)
+
+	spamFilter, err := spam.NewFilter(log, config.SpamOptions{})
+	require.NoError(t, err)
+
+	svc, err := NewReplicationApiService(
+		ctx,
+		log,
+		registrant,
+		db,
+		writerLock,
+		spamFilter,
+		payer.NoopVerifier{},
+		envelopes.NewPipeline(),
+		envelopes.Limits{MaxClientEnvelopeSize: 4 * 1024 * 1024, MaxTopicLength: 256},
+		nil,
+		nil,
+		config.SubscribeOptions{},
+	)
 	require.NoError(t, err)
 
 	return svc, db, func() {
 		svc.Close()
+		require.NoError(t, writerLock.Release())
 		dbCleanup()
 	}
 }
@@ -109,6 +140,119 @@ func TestSimplePublish(t *testing.T) {
 	}, 500*time.Millisecond, 50*time.Millisecond)
 }
 
+func TestDuplicatePublish(t *testing.T) {
+	svc, db, cleanup := newTestService(t)
+	defer cleanup()
+	svc.dedup = NewPublishDeduplicator(test.NewFakeClock(), time.Minute)
+
+	publish := func() *message_api.OriginatorEnvelope {
+		resp, err := svc.PublishEnvelope(
+			context.Background(),
+			&message_api.PublishEnvelopeRequest{PayerEnvelope: createPayerEnvelope(t)},
+		)
+		require.NoError(t, err)
+		return resp.GetOriginatorEnvelope()
+	}
+	first := publish()
+	require.True(t, proto.Equal(first, publish()))
+
+	staged, err := queries.New(db).SelectStagedOriginatorEnvelopes(
+		context.Background(),
+		queries.SelectStagedOriginatorEnvelopesParams{NumRows: 10},
+	)
+	require.NoError(t, err)
+	require.LessOrEqual(t, len(staged), 1)
+	require.Eventually(t, func() bool {
+		envs, err := queries.New(db).
+			SelectGatewayEnvelopes(context.Background(), queries.SelectGatewayEnvelopesParams{})
+		require.NoError(t, err)
+		return len(envs) == 1
+	}, 500*time.Millisecond, 50*time.Millisecond)
+}
+
+func TestPublishPayerVerification(t *testing.T) {
+	svc, _, cleanup := newTestService(t)
+	defer cleanup()
+	verifier, err := payer.NewSignatureVerifier(nil)
+	require.NoError(t, err)
+	svc.payerVerifier = verifier
+
+	// The test payer envelope is not signed
+	_, err = svc.PublishEnvelope(
+		context.Background(),
+		&message_api.PublishEnvelopeRequest{PayerEnvelope: createPayerEnvelope(t)},
+	)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	clientEnvBytes, err := proto.Marshal(createClientEnvelope())
+	require.NoError(t, err)
+	payerEnv, err := svc.registrant.SignPayerEnvelope(clientEnvBytes)
+	require.NoError(t, err)
+	_, err = svc.PublishEnvelope(
+		context.Background(),
+		&message_api.PublishEnvelopeRequest{PayerEnvelope: payerEnv},
+	)
+	require.NoError(t, err)
+}
+
+func TestReadOnlyPublish(t *testing.T) {
+	svc, _, cleanup := newTestService(t)
+	defer cleanup()
+	svc.worker = nil
+
+	_, err := svc.PublishEnvelope(
+		context.Background(),
+		&message_api.PublishEnvelopeRequest{PayerEnvelope: createPayerEnvelope(t)},
+	)
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
+
+func TestPublishStaleWriterLock(t *testing.T) {
+	svc, db, cleanup := newTestService(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	staged, err := queries.New(db).InsertStagedOriginatorEnvelope(
+		ctx,
+		queries.InsertStagedOriginatorEnvelopeParams{
+			Topic:         []byte{0x5},
+			PayerEnvelope: []byte("payer"),
+		},
+	)
+	require.NoError(t, err)
+	// Another process takes over the database while this one is still running
+	_, err = queries.New(db).IncrementFencingToken(ctx)
+	require.NoError(t, err)
+
+	_, err = svc.PublishEnvelope(
+		ctx,
+		&message_api.PublishEnvelopeRequest{PayerEnvelope: createPayerEnvelope(t)},
+	)
+	require.Equal(t, codes.Unavailable, status.Code(err))
+
+	// The publish worker neither stores nor deletes the staged envelope, and stops
+	// instead of retrying forever
+	svc.worker.NotifyStagedPublish()
+	select {
+	case <-svc.worker.stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("publish worker did not stop")
+	}
+	require.True(t, svc.worker.writerLock.Lost())
+	require.ErrorContains(t, svc.worker.flush(ctx), "still staged")
+	envs, err := queries.New(db).
+		SelectGatewayEnvelopes(ctx, queries.SelectGatewayEnvelopesParams{})
+	require.NoError(t, err)
+	require.Empty(t, envs)
+	remaining, err := queries.New(db).SelectStagedOriginatorEnvelopes(
+		ctx,
+		queries.SelectStagedOriginatorEnvelopesParams{NumRows: 10},
+	)
+	require.NoError(t, err)
+	require.Len(t, remaining, 1)
+	require.Equal(t, staged.ID, remaining[0].ID)
+}
+
 func TestUnmarshalError(t *testing.T) {
 	svc, _, cleanup := newTestService(t)
 	defer cleanup()
@@ -153,3 +297,389 @@ func TestMissingTopic(t *testing.T) {
 	)
 	require.ErrorContains(t, err, "topic")
 }
+
+func insertGatewayEnvelopes(t *testing.T, db *sql.DB, topics ...[]byte) {
+	for i, topic := range topics {
+		originatorBytes, err := proto.Marshal(&message_api.OriginatorEnvelope{
+			UnsignedOriginatorEnvelope: []byte{byte(i)},
+		})
+		require.NoError(t, err)
+		_, err = queries.New(db).InsertGatewayEnvelope(
+			context.Background(),
+			queries.InsertGatewayEnvelopeParams{
+				OriginatorID:         2,
+				OriginatorSequenceID: int64(i + 1),
+				Topic:                topic,
+				OriginatorEnvelope:   originatorBytes,
+			},
+		)
+		require.NoError(t, err)
+	}
+}
+
+func TestQueryPagination(t *testing.T) {
+	svc, db, cleanup := newTestService(t)
+	defer cleanup()
+	insertGatewayEnvelopes(t, db, []byte("a"), []byte("b"), []byte("a"), []byte("a"))
+
+	query := func(lastSeen uint64) []*message_api.GatewayEnvelope {
+		resp, err := svc.QueryEnvelopes(
+			context.Background(),
+			&message_api.QueryEnvelopesRequest{
+				Query: &message_api.EnvelopesQuery{
+					Filter:   &message_api.EnvelopesQuery_Topic{Topic: []byte("a")},
+					LastSeen: &message_api.EnvelopesQuery_GatewaySid{GatewaySid: lastSeen},
+				},
+				Limit: 2,
+			},
+		)
+		require.NoError(t, err)
+		return resp.GetEnvelopes()
+	}
+
+	page := query(0)
+	require.Len(t, page, 2)
+	require.Equal(t, []byte{0}, page[0].GetOriginatorEnvelope().GetUnsignedOriginatorEnvelope())
+	require.Equal(t, []byte{2}, page[1].GetOriginatorEnvelope().GetUnsignedOriginatorEnvelope())
+
+	page = query(page[1].GetGatewaySid())
+	require.Len(t, page, 1)
+	require.Equal(t, []byte{3}, page[0].GetOriginatorEnvelope().GetUnsignedOriginatorEnvelope())
+
+	require.Empty(t, query(page[0].GetGatewaySid()))
+}
+
+func TestQueryTopicPattern(t *testing.T) {
+	svc, db, cleanup := newTestService(t)
+	defer cleanup()
+	insertGatewayEnvelopes(t, db, []byte("ab"), []byte("b"), []byte("a*"), []byte("a"))
+
+	query := func(ctx context.Context, topic string) []byte {
+		resp, err := svc.QueryEnvelopes(ctx, &message_api.QueryEnvelopesRequest{
+			Query: &message_api.EnvelopesQuery{
+				Filter: &message_api.EnvelopesQuery_Topic{Topic: []byte(topic)},
+			},
+		})
+		require.NoError(t, err)
+		found := []byte{}
+		for _, env := range resp.GetEnvelopes() {
+			found = append(found, env.GetOriginatorEnvelope().GetUnsignedOriginatorEnvelope()...)
+		}
+		return found
+	}
+
+	// Without the header, '*' is part of the topic
+	require.Equal(t, []byte{2}, query(context.Background(), "a*"))
+
+	ctx := metadata.NewIncomingContext(
+		context.Background(),
+		metadata.Pairs(TopicPatternsHeader, "true"),
+	)
+	require.Equal(t, []byte{0, 2, 3}, query(ctx, "a*"))
+	require.Equal(t, []byte{0}, query(ctx, "ab*"))
+}
+
+func TestQueryByOriginatorSid(t *testing.T) {
+	svc, db, cleanup := newTestService(t)
+	defer cleanup()
+	insertGatewayEnvelopes(t, db, []byte("a"), []byte("b"), []byte("c"))
+
+	resp, err := svc.QueryEnvelopes(
+		context.Background(),
+		&message_api.QueryEnvelopesRequest{
+			Query: &message_api.EnvelopesQuery{
+				Filter: &message_api.EnvelopesQuery_OriginatorId{OriginatorId: 2},
+				LastSeen: &message_api.EnvelopesQuery_OriginatorSid{
+					OriginatorSid: utils.SID(2, 1),
+				},
+			},
+		},
+	)
+	require.NoError(t, err)
+	require.Len(t, resp.GetEnvelopes(), 2)
+}
+
+func TestQueryInvalidRequest(t *testing.T) {
+	svc, _, cleanup := newTestService(t)
+	defer cleanup()
+
+	for name, query := range map[string]*message_api.EnvelopesQuery{
+		"missing query":  nil,
+		"invalid filter": {},
+		"gateway sid": {
+			Filter:   &message_api.EnvelopesQuery_Topic{Topic: []byte("a")},
+			LastSeen: &message_api.EnvelopesQuery_GatewaySid{GatewaySid: utils.SID(2, 1)},
+		},
+		"originator sid": {
+			Filter:   &message_api.EnvelopesQuery_OriginatorId{OriginatorId: 2},
+			LastSeen: &message_api.EnvelopesQuery_OriginatorSid{OriginatorSid: utils.SID(3, 1)},
+		},
+	} {
+		_, err := svc.QueryEnvelopes(
+			context.Background(),
+			&message_api.QueryEnvelopesRequest{Query: query},
+		)
+		require.Equal(t, codes.InvalidArgument, status.Code(err), name)
+	}
+}
+
+type subscribeStream struct {
+	grpc.ServerStream
+	ctx       context.Context
+	envelopes chan []*message_api.GatewayEnvelope
+	trailer   metadata.MD
+}
+
+func (s *subscribeStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *subscribeStream) SendHeader(metadata.MD) error {
+	return nil
+}
+
+func (s *subscribeStream) SetTrailer(md metadata.MD) {
+	s.trailer = metadata.Join(s.trailer, md)
+}
+
+func (s *subscribeStream) Send(resp *message_api.BatchSubscribeEnvelopesResponse) error {
+	s.envelopes <- resp.GetEnvelopes()
+	return nil
+}
+
+func TestBatchSubscribe(t *testing.T) {
+	svc, _, cleanup := newTestService(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := &subscribeStream{
+		ctx:       ctx,
+		envelopes: make(chan []*message_api.GatewayEnvelope, 10),
+	}
+	done := make(chan error)
+	go func() {
+		done <- svc.BatchSubscribeEnvelopes(
+			&message_api.BatchSubscribeEnvelopesRequest{
+				Requests: []*message_api.BatchSubscribeEnvelopesRequest_SubscribeEnvelopesRequest{
+					{
+						Query: &message_api.EnvelopesQuery{
+							Filter: &message_api.EnvelopesQuery_Topic{Topic: []byte{0x5}},
+						},
+					},
+				},
+			},
+			stream,
+		)
+	}()
+	require.Eventually(t, func() bool {
+		svc.subscribeWorker.mutex.Lock()
+		defer svc.subscribeWorker.mutex.Unlock()
+		return len(svc.subscribeWorker.listeners) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	resp, err := svc.PublishEnvelope(
+		context.Background(),
+		&message_api.PublishEnvelopeRequest{PayerEnvelope: createPayerEnvelope(t)},
+	)
+	require.NoError(t, err)
+
+	select {
+	case envs := <-stream.envelopes:
+		require.Len(t, envs, 1)
+		require.True(
+			t,
+			proto.Equal(resp.GetOriginatorEnvelope(), envs[0].GetOriginatorEnvelope()),
+		)
+	case <-time.After(time.Second):
+		require.Fail(t, "timed out waiting for envelope")
+	}
+
+	cancel()
+	require.NoError(t, <-done)
+}
+
+func TestBatchSubscribeCatchUp(t *testing.T) {
+	svc, db, cleanup := newTestService(t)
+	defer cleanup()
+	insertGatewayEnvelopes(t, db, []byte("a"), []byte("b"), []byte("a"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := &subscribeStream{
+		ctx:       ctx,
+		envelopes: make(chan []*message_api.GatewayEnvelope, 10),
+	}
+	done := make(chan error)
+	go func() {
+		done <- svc.BatchSubscribeEnvelopes(
+			&message_api.BatchSubscribeEnvelopesRequest{
+				Requests: []*message_api.BatchSubscribeEnvelopesRequest_SubscribeEnvelopesRequest{
+					{
+						Query: &message_api.EnvelopesQuery{
+							Filter:   &message_api.EnvelopesQuery_Topic{Topic: []byte("a")},
+							LastSeen: &message_api.EnvelopesQuery_GatewaySid{GatewaySid: 0},
+						},
+					},
+					{
+						Query: &message_api.EnvelopesQuery{
+							Filter: &message_api.EnvelopesQuery_Topic{Topic: []byte{0x5}},
+						},
+					},
+				},
+			},
+			stream,
+		)
+	}()
+
+	receive := func() []*message_api.GatewayEnvelope {
+		select {
+		case envs := <-stream.envelopes:
+			return envs
+		case <-time.After(time.Second):
+			require.Fail(t, "timed out waiting for envelopes")
+			return nil
+		}
+	}
+
+	// The history of topic a is delivered first
+	envs := receive()
+	require.Len(t, envs, 2)
+	require.Equal(t, []byte{0}, envs[0].GetOriginatorEnvelope().GetUnsignedOriginatorEnvelope())
+	require.Equal(t, []byte{2}, envs[1].GetOriginatorEnvelope().GetUnsignedOriginatorEnvelope())
+
+	// Followed by new envelopes
+	resp, err := svc.PublishEnvelope(
+		context.Background(),
+		&message_api.PublishEnvelopeRequest{PayerEnvelope: createPayerEnvelope(t)},
+	)
+	require.NoError(t, err)
+	envs = receive()
+	require.Len(t, envs, 1)
+	require.True(t, proto.Equal(resp.GetOriginatorEnvelope(), envs[0].GetOriginatorEnvelope()))
+
+	cancel()
+	require.NoError(t, <-done)
+}
+
+func TestBatchSubscribeDroppedBeforeSend(t *testing.T) {
+	svc, db, cleanup := newTestService(t)
+	defer cleanup()
+	insertGatewayEnvelopes(t, db, []byte("a"), []byte("b"), []byte("a"))
+
+	stream := &subscribeStream{
+		ctx:       context.Background(),
+		envelopes: make(chan []*message_api.GatewayEnvelope, 10),
+	}
+	done := make(chan error)
+	go func() {
+		done <- svc.BatchSubscribeEnvelopes(
+			&message_api.BatchSubscribeEnvelopesRequest{
+				Requests: []*message_api.BatchSubscribeEnvelopesRequest_SubscribeEnvelopesRequest{
+					{
+						Query: &message_api.EnvelopesQuery{
+							Filter: &message_api.EnvelopesQuery_Topic{Topic: []byte("a")},
+						},
+					},
+				},
+			},
+			stream,
+		)
+	}()
+	require.Eventually(t, func() bool {
+		svc.subscribeWorker.mutex.Lock()
+		defer svc.subscribeWorker.mutex.Unlock()
+		return len(svc.subscribeWorker.listeners) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// Drop the subscriber before anything was sent to it
+	svc.subscribeWorker.mutex.Lock()
+	for l := range svc.subscribeWorker.listeners {
+		svc.subscribeWorker.remove(l)
+	}
+	svc.subscribeWorker.mutex.Unlock()
+
+	// The resume cursor skips the history, which the subscriber never asked for
+	err := <-done
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	require.Equal(
+		t,
+		[]string{strconv.FormatUint(utils.SID(svc.registrant.NodeID(), 3), 10)},
+		stream.trailer.Get(ResumeGatewaySidTrailer),
+	)
+	require.Empty(t, stream.envelopes)
+}
+
+func TestBatchSubscribeInvalidRequest(t *testing.T) {
+	svc, _, cleanup := newTestService(t)
+	defer cleanup()
+
+	err := svc.BatchSubscribeEnvelopes(
+		&message_api.BatchSubscribeEnvelopesRequest{},
+		&subscribeStream{ctx: context.Background()},
+	)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// The firehose cannot be resumed
+	err = svc.BatchSubscribeEnvelopes(
+		&message_api.BatchSubscribeEnvelopesRequest{
+			Requests: []*message_api.BatchSubscribeEnvelopesRequest_SubscribeEnvelopesRequest{
+				{
+					Query: &message_api.EnvelopesQuery{
+						LastSeen: &message_api.EnvelopesQuery_GatewaySid{GatewaySid: 0},
+					},
+				},
+			},
+		},
+		&subscribeStream{ctx: context.Background()},
+	)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestDrain(t *testing.T) {
+	svc, db, cleanup := newTestService(t)
+	defer cleanup()
+
+	stream := &subscribeStream{
+		ctx:       context.Background(),
+		envelopes: make(chan []*message_api.GatewayEnvelope, 10),
+	}
+	done := make(chan error)
+	go func() {
+		done <- svc.BatchSubscribeEnvelopes(
+			&message_api.BatchSubscribeEnvelopesRequest{
+				Requests: []*message_api.BatchSubscribeEnvelopesRequest_SubscribeEnvelopesRequest{
+					{Query: &message_api.EnvelopesQuery{}},
+				},
+			},
+			stream,
+		)
+	}()
+	require.Eventually(t, func() bool {
+		svc.subscribeWorker.mutex.Lock()
+		defer svc.subscribeWorker.mutex.Unlock()
+		return len(svc.subscribeWorker.listeners) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	_, err := svc.PublishEnvelope(
+		context.Background(),
+		&message_api.PublishEnvelopeRequest{PayerEnvelope: createPayerEnvelope(t)},
+	)
+	require.NoError(t, err)
+
+	svc.drain()
+	select {
+	case err := <-done:
+		require.Equal(t, codes.Unavailable, status.Code(err))
+	case <-time.After(time.Second):
+		require.Fail(t, "subscription was not ended")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, svc.flushStagedPublishes(ctx))
+	staged, err := queries.New(db).SelectStagedOriginatorEnvelopes(
+		ctx,
+		queries.SelectStagedOriginatorEnvelopesParams{LastSeenID: 0, NumRows: 10},
+	)
+	require.NoError(t, err)
+	require.Empty(t, staged)
+}
@@ -0,0 +1,333 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xmtp/xmtpd/pkg/config"
+	"github.com/xmtp/xmtpd/pkg/db"
+	"github.com/xmtp/xmtpd/pkg/db/queries"
+	"github.com/xmtp/xmtpd/pkg/metrics"
+	"github.com/xmtp/xmtpd/pkg/proto/xmtpv4/message_api"
+	"github.com/xmtp/xmtpd/pkg/utils"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// Default number of batches buffered per subscriber before it is considered too slow
+	subscriberBufferSize = 64
+)
+
+// Policies for subscribers whose buffer is full
+const (
+	// Close the subscription. Clients resume from the last envelope they received
+	SlowSubscriberDisconnect = "disconnect"
+	// Discard the oldest buffered batch to make room
+	SlowSubscriberDropOldest = "drop-oldest"
+	// Close the listener, and catch the subscription up from the database
+	SlowSubscriberCatchUp = "catch-up"
+)
+
+// Stream trailers telling a subscriber what it missed. The resume cursor is sent when the
+// subscription is closed for falling behind, and can be passed back as last_seen
+const (
+	ResumeGatewaySidTrailer = "xmtpd-resume-gateway-sid"
+	DroppedEnvelopesTrailer = "xmtpd-dropped-envelopes"
+)
+
+// A single subscriber. An envelope is delivered if it matches any of the topics, topic
+// prefixes or originators, or unconditionally if isFirehose is set.
+type listener struct {
+	ch          chan []*message_api.GatewayEnvelope
+	dropped     atomic.Uint64
+	topics      map[string]bool
+	prefixes    [][]byte
+	originators map[uint32]bool
+}
+
+// A gateway envelope along with the columns subscribers are matched against
+type gatewayEnvelopeRow struct {
+	topic        []byte
+	originatorID uint32
+	envelope     *message_api.GatewayEnvelope
+}
+
+/*
+The subscribeWorker polls for newly inserted gateway envelopes and fans them out to every
+listener. A single database subscription serves all subscribers, regardless of how many
+topics they follow.
+
+Listeners that fall more than bufferSize batches behind never block delivery to everyone
+else. With dropOldest their oldest batch is discarded, and otherwise the listener is dropped
+and its channel closed.
+*/
+type subscribeWorker struct {
+	ctx        context.Context
+	log        *zap.Logger
+	updates    <-chan []*gatewayEnvelopeRow
+	bufferSize int
+	dropOldest bool
+	listeners  map[*listener]bool
+	// Indexes of listeners by what they match, so that dispatching an envelope only visits
+	// the listeners it is delivered to
+	firehose    map[*listener]bool
+	topics      map[string]map[*listener]bool
+	prefixes    *topicTrie
+	originators map[uint32]map[*listener]bool
+	mutex       sync.Mutex
+}
+
+func startSubscribeWorker(
+	ctx context.Context,
+	log *zap.Logger,
+	nodeID uint16,
+	store *sql.DB,
+	options config.SubscribeOptions,
+) (*subscribeWorker, error) {
+	log = log.Named("subscribeWorker")
+	q := queries.New(store)
+	query := func(ctx context.Context, lastSeenID int64, numRows int32) ([]*gatewayEnvelopeRow, int64, error) {
+		rows, err := q.SelectGatewayEnvelopes(
+			ctx,
+			queries.SelectGatewayEnvelopesParams{
+				GatewaySequenceID: db.NullInt64(lastSeenID),
+				RowLimit:          db.NullInt32(numRows),
+			},
+		)
+		if err != nil {
+			return nil, 0, err
+		}
+		envs := make([]*gatewayEnvelopeRow, 0, len(rows))
+		for _, row := range rows {
+			lastSeenID = row.ID
+			env, err := toGatewayEnvelope(nodeID, row)
+			if err != nil {
+				// We expect to have already validated the envelope when it was inserted
+				log.Error("could not unmarshal originator envelope", zap.Error(err))
+				continue
+			}
+			envs = append(envs, &gatewayEnvelopeRow{
+				topic:        row.Topic,
+				originatorID: uint32(row.OriginatorNodeID),
+				envelope:     env,
+			})
+		}
+		return envs, lastSeenID, nil
+	}
+
+	// Subscriptions only deliver envelopes inserted after the node started
+	latestID, err := q.SelectLatestGatewayEnvelopeID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	subscription := db.NewDBSubscription(
+		ctx,
+		log,
+		query,
+		latestID,
+		db.PollingOptions{Interval: 100 * time.Millisecond, NumRows: 100},
+	)
+	updates, err := subscription.Start()
+	if err != nil {
+		return nil, err
+	}
+
+	worker := newSubscribeWorker(ctx, log, updates)
+	if options.BufferSize > 0 {
+		worker.bufferSize = options.BufferSize
+	}
+	worker.dropOldest = options.SlowPolicy == SlowSubscriberDropOldest
+	go worker.start()
+
+	return worker, nil
+}
+
+func newSubscribeWorker(
+	ctx context.Context,
+	log *zap.Logger,
+	updates <-chan []*gatewayEnvelopeRow,
+) *subscribeWorker {
+	return &subscribeWorker{
+		ctx:         ctx,
+		log:         log,
+		updates:     updates,
+		bufferSize:  subscriberBufferSize,
+		listeners:   make(map[*listener]bool),
+		firehose:    make(map[*listener]bool),
+		topics:      make(map[string]map[*listener]bool),
+		prefixes:    newTopicTrie(),
+		originators: make(map[uint32]map[*listener]bool),
+	}
+}
+
+func (s *subscribeWorker) start() {
+	for {
+		select {
+		case <-s.ctx.Done():
+			s.closeListeners()
+			return
+		case batch, ok := <-s.updates:
+			if !ok {
+				s.closeListeners()
+				return
+			}
+			s.dispatch(batch)
+		}
+	}
+}
+
+func (s *subscribeWorker) dispatch(batch []*gatewayEnvelopeRow) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	matched := make(map[*listener][]*message_api.GatewayEnvelope)
+	for _, env := range batch {
+		deliver := func(l *listener) {
+			envs := matched[l]
+			// Listeners matching an envelope in several ways only receive it once
+			if len(envs) > 0 && envs[len(envs)-1] == env.envelope {
+				return
+			}
+			matched[l] = append(envs, env.envelope)
+		}
+		for l := range s.firehose {
+			deliver(l)
+		}
+		for l := range s.topics[string(env.topic)] {
+			deliver(l)
+		}
+		s.prefixes.match(env.topic, deliver)
+		for l := range s.originators[env.originatorID] {
+			deliver(l)
+		}
+	}
+
+	for l, envs := range matched {
+		select {
+		case l.ch <- envs:
+			continue
+		default:
+		}
+		if s.dropOldest {
+			s.replaceOldest(l, envs)
+			continue
+		}
+		s.log.Info("Dropping slow subscriber")
+		metrics.EmitSlowSubscriber(SlowSubscriberDisconnect)
+		s.remove(l)
+	}
+}
+
+// Makes room in a full listener's buffer by discarding its oldest batch. Only the
+// dispatcher sends to listeners, so the freed slot can't be taken by anyone else
+func (s *subscribeWorker) replaceOldest(l *listener, envs []*message_api.GatewayEnvelope) {
+	metrics.EmitSlowSubscriber(SlowSubscriberDropOldest)
+	select {
+	case oldest := <-l.ch:
+		l.dropped.Add(uint64(len(oldest)))
+		metrics.EmitSubscriberDroppedEnvelopes(len(oldest))
+	default:
+	}
+	select {
+	case l.ch <- envs:
+	default:
+		l.dropped.Add(uint64(len(envs)))
+		metrics.EmitSubscriberDroppedEnvelopes(len(envs))
+	}
+}
+
+func (s *subscribeWorker) listen(
+	isFirehose bool,
+	topics map[string]bool,
+	prefixes [][]byte,
+	originators map[uint32]bool,
+) (*listener, func()) {
+	l := &listener{
+		ch:          make(chan []*message_api.GatewayEnvelope, s.bufferSize),
+		topics:      topics,
+		prefixes:    prefixes,
+		originators: originators,
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.ctx.Err() != nil {
+		close(l.ch)
+		return l, func() {}
+	}
+	s.listeners[l] = true
+	if isFirehose {
+		s.firehose[l] = true
+	}
+	for topic := range topics {
+		if s.topics[topic] == nil {
+			s.topics[topic] = make(map[*listener]bool)
+		}
+		s.topics[topic][l] = true
+	}
+	for _, prefix := range prefixes {
+		s.prefixes.add(prefix, l)
+	}
+	for originator := range originators {
+		if s.originators[originator] == nil {
+			s.originators[originator] = make(map[*listener]bool)
+		}
+		s.originators[originator][l] = true
+	}
+
+	return l, func() {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		if s.listeners[l] {
+			s.remove(l)
+		}
+	}
+}
+
+// Closes the listener's channel and removes it from every index. The mutex must be held
+func (s *subscribeWorker) remove(l *listener) {
+	close(l.ch)
+	delete(s.listeners, l)
+	delete(s.firehose, l)
+	for topic := range l.topics {
+		delete(s.topics[topic], l)
+		if len(s.topics[topic]) == 0 {
+			delete(s.topics, topic)
+		}
+	}
+	for _, prefix := range l.prefixes {
+		s.prefixes.remove(prefix, l)
+	}
+	for originator := range l.originators {
+		delete(s.originators[originator], l)
+		if len(s.originators[originator]) == 0 {
+			delete(s.originators, originator)
+		}
+	}
+}
+
+func (s *subscribeWorker) closeListeners() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for l := range s.listeners {
+		s.remove(l)
+	}
+}
+
+func toGatewayEnvelope(
+	nodeID uint16,
+	row queries.GatewayEnvelope,
+) (*message_api.GatewayEnvelope, error) {
+	originatorEnv := &message_api.OriginatorEnvelope{}
+	if err := proto.Unmarshal(row.OriginatorEnvelope, originatorEnv); err != nil {
+		return nil, err
+	}
+	return &message_api.GatewayEnvelope{
+		GatewaySid:         utils.SID(nodeID, row.ID),
+		OriginatorEnvelope: originatorEnv,
+	}, nil
+}
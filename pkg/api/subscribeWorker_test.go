@@ -0,0 +1,145 @@
+package api
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xmtp/xmtpd/pkg/proto/xmtpv4/message_api"
+	test "github.com/xmtp/xmtpd/pkg/testing"
+)
+
+func newTestSubscribeWorker(t *testing.T, ctx context.Context) *subscribeWorker {
+	return newSubscribeWorker(ctx, test.NewLog(t), nil)
+}
+
+func newRow(sid uint64, topic string, originatorID uint32) *gatewayEnvelopeRow {
+	return &gatewayEnvelopeRow{
+		topic:        []byte(topic),
+		originatorID: originatorID,
+		envelope:     &message_api.GatewayEnvelope{GatewaySid: sid},
+	}
+}
+
+func sids(envs []*message_api.GatewayEnvelope) []uint64 {
+	out := make([]uint64, 0, len(envs))
+	for _, env := range envs {
+		out = append(out, env.GetGatewaySid())
+	}
+	return out
+}
+
+func TestSubscribeWorkerDispatch(t *testing.T) {
+	worker := newTestSubscribeWorker(t, context.Background())
+	byTopic, cancelTopic := worker.listen(false, map[string]bool{"a": true}, nil, nil)
+	defer cancelTopic()
+	byOriginator, cancelOriginator := worker.listen(false, nil, nil, map[uint32]bool{2: true})
+	defer cancelOriginator()
+	firehose, cancelFirehose := worker.listen(true, nil, nil, nil)
+	defer cancelFirehose()
+
+	worker.dispatch([]*gatewayEnvelopeRow{
+		newRow(1, "a", 1),
+		newRow(2, "b", 2),
+		newRow(3, "c", 1),
+	})
+
+	require.Equal(t, []uint64{1}, sids(<-byTopic.ch))
+	require.Equal(t, []uint64{2}, sids(<-byOriginator.ch))
+	require.Equal(t, []uint64{1, 2, 3}, sids(<-firehose.ch))
+
+	// Listeners with no matching envelopes are not sent empty batches
+	worker.dispatch([]*gatewayEnvelopeRow{newRow(4, "c", 1)})
+	require.Empty(t, byTopic.ch)
+	require.Empty(t, byOriginator.ch)
+	require.Equal(t, []uint64{4}, sids(<-firehose.ch))
+}
+
+func TestSubscribeWorkerDispatchPrefixes(t *testing.T) {
+	worker := newTestSubscribeWorker(t, context.Background())
+	byPrefix, cancelPrefix := worker.listen(
+		false,
+		map[string]bool{"b": true},
+		[][]byte{[]byte("a"), []byte("ab")},
+		map[uint32]bool{1: true},
+	)
+	other, cancelOther := worker.listen(false, nil, [][]byte{[]byte("abc")}, nil)
+	defer cancelOther()
+
+	worker.dispatch([]*gatewayEnvelopeRow{
+		newRow(1, "abc", 1),
+		newRow(2, "b", 2),
+		newRow(3, "ax", 2),
+		newRow(4, "c", 2),
+	})
+
+	// Envelopes matching several patterns are delivered once
+	require.Equal(t, []uint64{1, 2, 3}, sids(<-byPrefix.ch))
+	require.Equal(t, []uint64{1}, sids(<-other.ch))
+
+	// Cancelling removes the listener from every index
+	cancelPrefix()
+	require.Empty(t, worker.topics)
+	require.Empty(t, worker.originators)
+	worker.dispatch([]*gatewayEnvelopeRow{newRow(5, "abcd", 1)})
+	require.Equal(t, []uint64{5}, sids(<-other.ch))
+	cancelOther()
+	require.Empty(t, worker.prefixes.children)
+}
+
+func TestSubscribeWorkerDropsSlowListener(t *testing.T) {
+	worker := newTestSubscribeWorker(t, context.Background())
+	l, cancel := worker.listen(true, nil, nil, nil)
+	defer cancel()
+
+	for i := 0; i <= subscriberBufferSize; i++ {
+		worker.dispatch([]*gatewayEnvelopeRow{newRow(uint64(i), "a", 1)})
+	}
+	require.Empty(t, worker.listeners)
+
+	received := 0
+	for range l.ch {
+		received++
+	}
+	require.Equal(t, subscriberBufferSize, received)
+}
+
+func TestSubscribeWorkerDropOldest(t *testing.T) {
+	worker := newTestSubscribeWorker(t, context.Background())
+	worker.bufferSize = 2
+	worker.dropOldest = true
+	l, cancel := worker.listen(true, nil, nil, nil)
+	defer cancel()
+
+	for i := 1; i <= 5; i++ {
+		worker.dispatch([]*gatewayEnvelopeRow{newRow(uint64(i), "a", 1)})
+	}
+	// The listener is kept, holding the newest batches
+	require.Len(t, worker.listeners, 1)
+	require.EqualValues(t, 3, l.dropped.Load())
+	require.Equal(t, []uint64{4}, sids(<-l.ch))
+	require.Equal(t, []uint64{5}, sids(<-l.ch))
+}
+
+func TestSubscribeWorkerCancel(t *testing.T) {
+	worker := newTestSubscribeWorker(t, context.Background())
+	l, cancel := worker.listen(true, nil, nil, nil)
+	cancel()
+	// Cancelling twice is a no-op
+	cancel()
+
+	_, ok := <-l.ch
+	require.False(t, ok)
+	require.Empty(t, worker.listeners)
+}
+
+func TestSubscribeWorkerListenAfterShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	worker := newTestSubscribeWorker(t, ctx)
+
+	l, cancelListener := worker.listen(true, nil, nil, nil)
+	defer cancelListener()
+	_, ok := <-l.ch
+	require.False(t, ok)
+}
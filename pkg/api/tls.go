@@ -0,0 +1,94 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"github.com/xmtp/xmtpd/pkg/config"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+/*
+*
+Builds the TLS configuration shared by the gRPC and HTTP listeners, or returns nil if TLS is
+not configured.
+
+Certificates come either from files, or from an ACME CA such as Let's Encrypt. ACME
+certificates are obtained with the TLS-ALPN-01 challenge, so the gRPC or HTTP port must be
+reachable on port 443 for issuance to succeed.
+
+When a client CA is configured, client certificates signed by it are verified, which lets
+node-to-node traffic use mutual TLS. Clients without a certificate are still accepted unless
+client certificates are required, which only applies to gRPC. See httpTLSConfig.
+*/
+func newTLSConfig(options config.TLSOptions) (*tls.Config, error) {
+	hasFiles := options.CertFile != "" || options.KeyFile != ""
+	hasAutocert := len(options.AutocertHosts) > 0
+	if hasFiles && hasAutocert {
+		return nil, fmt.Errorf("TLS certificate files and autocert hosts are mutually exclusive")
+	}
+	if !hasFiles && !hasAutocert {
+		if options.ClientCAFile != "" || options.RequireClientCert {
+			return nil, fmt.Errorf("client certificates require a TLS certificate")
+		}
+		return nil, nil
+	}
+
+	var tlsConfig *tls.Config
+	if hasAutocert {
+		if options.AutocertCacheDir == "" {
+			return nil, fmt.Errorf("an autocert cache directory is required")
+		}
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(options.AutocertHosts...),
+			Cache:      autocert.DirCache(options.AutocertCacheDir),
+			Email:      options.AutocertEmail,
+		}
+		tlsConfig = manager.TLSConfig()
+	} else {
+		cert, err := tls.LoadX509KeyPair(options.CertFile, options.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load TLS certificate: %v", err)
+		}
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+	tlsConfig.MinVersion = tls.VersionTLS12
+
+	if options.ClientCAFile != "" {
+		pem, err := os.ReadFile(options.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read client CA: %v", err)
+		}
+		clientCAs := x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in client CA %s", options.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = clientCAs
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		if options.RequireClientCert {
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	} else if options.RequireClientCert {
+		return nil, fmt.Errorf("requiring client certificates needs a client CA")
+	}
+
+	return tlsConfig, nil
+}
+
+/*
+*
+Returns the TLS configuration for the HTTP gateway. The gateway serves browsers and other
+clients that have no certificate, so client certificates are verified if given but never
+required there, even when the gRPC listener requires them for node-to-node traffic.
+*/
+func httpTLSConfig(tlsConfig *tls.Config) *tls.Config {
+	if tlsConfig == nil || tlsConfig.ClientAuth != tls.RequireAndVerifyClientCert {
+		return tlsConfig
+	}
+	httpConfig := tlsConfig.Clone()
+	httpConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	return httpConfig
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xmtp/xmtpd/pkg/config"
+)
+
+// Writes a self-signed certificate and its key to dir, returning their paths
+func writeTestCertificate(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(
+		certFile,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		0o600,
+	))
+	require.NoError(t, os.WriteFile(
+		keyFile,
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}),
+		0o600,
+	))
+	return certFile, keyFile
+}
+
+func TestTLSConfigDisabled(t *testing.T) {
+	tlsConfig, err := newTLSConfig(config.TLSOptions{})
+	require.NoError(t, err)
+	require.Nil(t, tlsConfig)
+}
+
+func TestTLSConfigFromFiles(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t, t.TempDir())
+
+	tlsConfig, err := newTLSConfig(config.TLSOptions{CertFile: certFile, KeyFile: keyFile})
+	require.NoError(t, err)
+	require.Len(t, tlsConfig.Certificates, 1)
+	require.Equal(t, tls.NoClientCert, tlsConfig.ClientAuth)
+}
+
+func TestTLSConfigClientCA(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t, t.TempDir())
+	options := config.TLSOptions{CertFile: certFile, KeyFile: keyFile, ClientCAFile: certFile}
+
+	tlsConfig, err := newTLSConfig(options)
+	require.NoError(t, err)
+	require.NotNil(t, tlsConfig.ClientCAs)
+	require.Equal(t, tls.VerifyClientCertIfGiven, tlsConfig.ClientAuth)
+
+	options.RequireClientCert = true
+	tlsConfig, err = newTLSConfig(options)
+	require.NoError(t, err)
+	require.Equal(t, tls.RequireAndVerifyClientCert, tlsConfig.ClientAuth)
+
+	// The HTTP gateway still accepts clients without a certificate
+	httpConfig := httpTLSConfig(tlsConfig)
+	require.Equal(t, tls.VerifyClientCertIfGiven, httpConfig.ClientAuth)
+	require.Equal(t, tlsConfig.ClientCAs, httpConfig.ClientCAs)
+	require.Equal(t, tls.RequireAndVerifyClientCert, tlsConfig.ClientAuth)
+}
+
+func TestHTTPTLSConfig(t *testing.T) {
+	require.Nil(t, httpTLSConfig(nil))
+
+	tlsConfig := &tls.Config{ClientAuth: tls.VerifyClientCertIfGiven}
+	require.Same(t, tlsConfig, httpTLSConfig(tlsConfig))
+}
+
+func TestTLSConfigInvalid(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t, t.TempDir())
+
+	for name, options := range map[string]config.TLSOptions{
+		"mutually exclusive": {
+			CertFile:      certFile,
+			KeyFile:       keyFile,
+			AutocertHosts: []string{"example.com"},
+		},
+		"require a TLS certificate": {ClientCAFile: certFile},
+		"needs a client CA": {
+			CertFile:          certFile,
+			KeyFile:           keyFile,
+			RequireClientCert: true,
+		},
+		"cache directory": {AutocertHosts: []string{"example.com"}},
+		"unable to load":  {CertFile: certFile, KeyFile: certFile},
+		"no certificates": {CertFile: certFile, KeyFile: keyFile, ClientCAFile: keyFile},
+	} {
+		_, err := newTLSConfig(options)
+		require.ErrorContains(t, err, name)
+	}
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+/*
+*
+Request header that turns on topic patterns for Query and Subscribe. When it is "true", a
+topic filter ending in '*' matches every topic starting with the bytes before it.
+
+Topics are arbitrary bytes, so patterns are opt in. Otherwise topics that happen to end in
+'*' could no longer be queried exactly. The HTTP gateway accepts the header as
+Grpc-Metadata-Xmtpd-Topic-Patterns.
+*/
+const TopicPatternsHeader = "xmtpd-topic-patterns"
+
+const topicWildcard = '*'
+
+func topicPatternsEnabled(ctx context.Context) bool {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return false
+	}
+	values := md.Get(TopicPatternsHeader)
+	return len(values) > 0 && strings.EqualFold(values[0], "true")
+}
+
+// Returns the prefix a topic filter matches, or nil if the filter is an exact topic
+func topicPrefix(topic []byte, patterns bool) ([]byte, error) {
+	if !patterns || len(topic) == 0 || topic[len(topic)-1] != topicWildcard {
+		return nil, nil
+	}
+	prefix := topic[:len(topic)-1]
+	if len(prefix) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "topic pattern must have a prefix")
+	}
+	return prefix, nil
+}
+
+// Returns the smallest topic greater than every topic starting with prefix, or nil if
+// there is none
+func prefixEnd(prefix []byte) []byte {
+	end := bytes.Clone(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+/*
+*
+A byte-wise trie of topic prefixes, each node holding the listeners subscribed to the prefix
+ending there. Matching a topic walks one node per byte, so the cost of dispatching an
+envelope does not grow with the number of patterns.
+*/
+type topicTrie struct {
+	children  map[byte]*topicTrie
+	listeners map[*listener]bool
+}
+
+func newTopicTrie() *topicTrie {
+	return &topicTrie{}
+}
+
+func (t *topicTrie) add(prefix []byte, l *listener) {
+	node := t
+	for _, b := range prefix {
+		if node.children == nil {
+			node.children = make(map[byte]*topicTrie)
+		}
+		child, ok := node.children[b]
+		if !ok {
+			child = newTopicTrie()
+			node.children[b] = child
+		}
+		node = child
+	}
+	if node.listeners == nil {
+		node.listeners = make(map[*listener]bool)
+	}
+	node.listeners[l] = true
+}
+
+// Removes the listener from the prefix, pruning nodes that are left empty
+func (t *topicTrie) remove(prefix []byte, l *listener) {
+	if len(prefix) == 0 {
+		delete(t.listeners, l)
+		return
+	}
+	child, ok := t.children[prefix[0]]
+	if !ok {
+		return
+	}
+	child.remove(prefix[1:], l)
+	if len(child.listeners) == 0 && len(child.children) == 0 {
+		delete(t.children, prefix[0])
+	}
+}
+
+// Calls fn for every listener subscribed to a prefix of topic
+func (t *topicTrie) match(topic []byte, fn func(l *listener)) {
+	node := t
+	for _, b := range topic {
+		child, ok := node.children[b]
+		if !ok {
+			return
+		}
+		node = child
+		for l := range node.listeners {
+			fn(l)
+		}
+	}
+}
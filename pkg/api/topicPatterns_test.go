@@ -0,0 +1,66 @@
+package api
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestTopicPrefix(t *testing.T) {
+	prefix, err := topicPrefix([]byte("/xmtp/0/dm-0xabc*"), true)
+	require.NoError(t, err)
+	require.Equal(t, []byte("/xmtp/0/dm-0xabc"), prefix)
+
+	// Patterns are opt in
+	prefix, err = topicPrefix([]byte("/xmtp/0/dm-0xabc*"), false)
+	require.NoError(t, err)
+	require.Nil(t, prefix)
+
+	prefix, err = topicPrefix([]byte("topic"), true)
+	require.NoError(t, err)
+	require.Nil(t, prefix)
+
+	_, err = topicPrefix([]byte("*"), true)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestTopicPatternsEnabled(t *testing.T) {
+	require.False(t, topicPatternsEnabled(context.Background()))
+	require.True(t, topicPatternsEnabled(metadata.NewIncomingContext(
+		context.Background(),
+		metadata.Pairs(TopicPatternsHeader, "true"),
+	)))
+}
+
+func TestPrefixEnd(t *testing.T) {
+	require.Equal(t, []byte("ac"), prefixEnd([]byte("ab")))
+	require.Equal(t, []byte{0x02}, prefixEnd([]byte{0x01, 0xff}))
+	require.Nil(t, prefixEnd([]byte{0xff, 0xff}))
+}
+
+func TestTopicTrie(t *testing.T) {
+	trie := newTopicTrie()
+	a, ab := &listener{}, &listener{}
+	trie.add([]byte("a"), a)
+	trie.add([]byte("ab"), ab)
+
+	matches := func(topic string) []*listener {
+		out := []*listener{}
+		trie.match([]byte(topic), func(l *listener) {
+			out = append(out, l)
+		})
+		return out
+	}
+	require.Equal(t, []*listener{a, ab}, matches("abc"))
+	require.Equal(t, []*listener{a}, matches("ax"))
+	require.Empty(t, matches("b"))
+
+	trie.remove([]byte("ab"), ab)
+	require.Equal(t, []*listener{a}, matches("abc"))
+	trie.remove([]byte("a"), a)
+	require.Empty(t, trie.children)
+}
@@ -0,0 +1,206 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/xmtp/xmtpd/pkg/proto/xmtpv4/message_api"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// Path of the WebSocket subscription endpoint on the HTTP gateway
+	WebSocketSubscribePath = "/mls/v2/subscribe-envelopes/ws"
+	/*
+		Subscriptions ended by an error are closed with this code plus the gRPC status code,
+		such as 4014 for Unavailable, and the status message as the reason. Subscriptions the
+		server ends without an error are closed with 1000 (normal closure).
+	*/
+	WebSocketCloseCodeBase = 4000
+
+	wsPingInterval = 30 * time.Second
+	// Connections that have not answered a ping within this time are closed
+	wsPongTimeout  = 2 * wsPingInterval
+	wsWriteTimeout = 10 * time.Second
+	// Maximum size of the subscribe request
+	wsMaxRequestSize = 1 << 20
+	// Close reasons must fit in a control frame
+	wsMaxCloseReasonSize = 123
+)
+
+/*
+*
+Serves BatchSubscribeEnvelopes over WebSocket, for browsers that can't consume the streaming
+HTTP gateway. The client sends a single BatchSubscribeEnvelopesRequest, and receives each
+BatchSubscribeEnvelopesResponse as a message of its own. A request sent as a text message is
+read and answered as JSON, and a binary one as protobuf.
+
+Browsers can't set headers on WebSocket requests, so topic patterns are turned on with the
+xmtpd-topic-patterns=true query parameter. The subscription is forwarded to client, so it goes
+through the same interceptors as native gRPC subscriptions.
+*/
+func NewWebSocketSubscribeHandler(
+	log *zap.Logger,
+	client message_api.ReplicationApiClient,
+) http.Handler {
+	upgrader := websocket.Upgrader{
+		// Subscriptions are public and don't use cookies, so pages on any origin may subscribe
+		CheckOrigin: func(*http.Request) bool { return true },
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// The upgrader has already responded with an HTTP error
+			return
+		}
+		defer conn.Close()
+		serveWebSocketSubscription(r.Context(), log, client, r, conn)
+	})
+}
+
+func serveWebSocketSubscription(
+	ctx context.Context,
+	log *zap.Logger,
+	client message_api.ReplicationApiClient,
+	r *http.Request,
+	conn *websocket.Conn,
+) {
+	conn.SetReadLimit(wsMaxRequestSize)
+	_ = conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	})
+
+	messageType, data, err := conn.ReadMessage()
+	if err != nil {
+		return
+	}
+	req := &message_api.BatchSubscribeEnvelopesRequest{}
+	if messageType == websocket.TextMessage {
+		err = protojson.Unmarshal(data, req)
+	} else {
+		err = proto.Unmarshal(data, req)
+	}
+	if err != nil {
+		closeWebSocket(conn, status.Errorf(codes.InvalidArgument, "invalid request: %v", err))
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := client.BatchSubscribeEnvelopes(
+		metadata.NewOutgoingContext(ctx, webSocketMetadata(r)),
+		req,
+	)
+	if err != nil {
+		closeWebSocket(conn, err)
+		return
+	}
+
+	// Reading handles pongs and close messages. Clients have nothing else to send
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	go func() {
+		ticker := time.NewTicker(wsPingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				err := conn.WriteControl(
+					websocket.PingMessage,
+					nil,
+					time.Now().Add(wsWriteTimeout),
+				)
+				if err != nil {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			// The client went away, so there is no one to tell
+			if ctx.Err() == nil {
+				closeWebSocket(conn, err)
+			}
+			return
+		}
+		if messageType == websocket.TextMessage {
+			data, err = protojson.Marshal(resp)
+		} else {
+			data, err = proto.Marshal(resp)
+		}
+		if err != nil {
+			log.Error("could not marshal subscription response", zap.Error(err))
+			closeWebSocket(conn, status.Error(codes.Internal, "could not marshal response"))
+			return
+		}
+		_ = conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		if err := conn.WriteMessage(messageType, data); err != nil {
+			return
+		}
+	}
+}
+
+// Forwards the client address and Grpc-Metadata- headers, as the HTTP gateway does
+func webSocketMetadata(r *http.Request) metadata.MD {
+	md := metadata.MD{}
+	for key, values := range r.Header {
+		if name, ok := strings.CutPrefix(key, "Grpc-Metadata-"); ok {
+			md.Append(strings.ToLower(name), values...)
+		}
+	}
+	if r.URL.Query().Get(TopicPatternsHeader) == "true" {
+		md.Set(TopicPatternsHeader, "true")
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		forwardedFor := host
+		if prior := r.Header.Get("X-Forwarded-For"); prior != "" {
+			forwardedFor = fmt.Sprintf("%s, %s", prior, host)
+		}
+		md.Set("x-forwarded-for", forwardedFor)
+	}
+	return md
+}
+
+// Closes the connection with the close code for err. See WebSocketCloseCodeBase
+func closeWebSocket(conn *websocket.Conn, err error) {
+	code := websocket.CloseNormalClosure
+	reason := ""
+	if !errors.Is(err, io.EOF) {
+		st := status.Convert(err)
+		code = WebSocketCloseCodeBase + int(st.Code())
+		reason = st.Message()
+		if len(reason) > wsMaxCloseReasonSize {
+			reason = reason[:wsMaxCloseReasonSize]
+		}
+	}
+	_ = conn.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(code, reason),
+		time.Now().Add(wsWriteTimeout),
+	)
+}
@@ -0,0 +1,126 @@
+// Package audit records the API operations served by the node, so that operators can
+// investigate abuse and usage patterns without scraping debug logs.
+package audit
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/xmtp/xmtpd/pkg/config"
+	"github.com/xmtp/xmtpd/pkg/ratelimit"
+	"go.uber.org/zap"
+)
+
+const (
+	// Maximum number of records waiting to be written before new ones are dropped
+	QUEUE_SIZE = 1000
+)
+
+// A single API operation
+type Record struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"requestId"`
+	Method    string    `json:"method"`
+	ClientIP  string    `json:"clientIp,omitempty"`
+	// Hex encoded topics the operation published to or read from
+	Topics        []string `json:"topics,omitempty"`
+	OriginatorIDs []uint32 `json:"originatorIds,omitempty"`
+	// Number of envelopes published, returned or streamed
+	Envelopes int           `json:"envelopes"`
+	Latency   time.Duration `json:"latencyNs"`
+	Code      string        `json:"code"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// A destination for audit records. Sinks are only written to from a single goroutine
+type Sink interface {
+	Write(record Record) error
+	Close() error
+}
+
+/*
+*
+The Auditor writes a sample of API operations to a sink. Operations are sampled
+independently for each kind of request, so that a high rate of publishes does not drown out
+subscriptions.
+
+Records are written in the background. When the sink falls behind, records are dropped
+rather than slowing down requests.
+*/
+type Auditor struct {
+	log         *zap.Logger
+	sink        Sink
+	sampleRates map[string]float64
+	random      func() float64
+	queue       chan Record
+}
+
+// Returns nil, which records nothing, if no audit log is configured
+func NewAuditor(log *zap.Logger, options config.AuditOptions) (*Auditor, error) {
+	if options.Path == "" {
+		return nil, nil
+	}
+	sink, err := NewFileSink(options.Path)
+	if err != nil {
+		return nil, err
+	}
+	return newAuditor(log, sink, map[string]float64{
+		ratelimit.Publish:   options.PublishSampleRate,
+		ratelimit.Query:     options.QuerySampleRate,
+		ratelimit.Subscribe: options.SubscribeSampleRate,
+	}), nil
+}
+
+func newAuditor(log *zap.Logger, sink Sink, sampleRates map[string]float64) *Auditor {
+	return &Auditor{
+		log:         log.Named("audit"),
+		sink:        sink,
+		sampleRates: sampleRates,
+		random:      rand.Float64,
+		queue:       make(chan Record, QUEUE_SIZE),
+	}
+}
+
+// Writes records until the context is cancelled, then closes the sink
+func (a *Auditor) Start(ctx context.Context) {
+	if a == nil {
+		return
+	}
+	go func() {
+		defer func() {
+			if err := a.sink.Close(); err != nil {
+				a.log.Error("Failed to close audit sink", zap.Error(err))
+			}
+		}()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case record := <-a.queue:
+				if err := a.sink.Write(record); err != nil {
+					a.log.Error("Failed to write audit record", zap.Error(err))
+				}
+			}
+		}
+	}()
+}
+
+// Returns whether an operation of the given ratelimit kind should be recorded. Safe to call
+// on a nil Auditor
+func (a *Auditor) Sample(kind string) bool {
+	if a == nil {
+		return false
+	}
+	rate, ok := a.sampleRates[kind]
+	return ok && a.random() < rate
+}
+
+// Queues a record for writing without blocking
+func (a *Auditor) Log(record Record) {
+	select {
+	case a.queue <- record:
+	default:
+		a.log.Warn("Audit queue is full, dropping record", zap.String("method", record.Method))
+	}
+}
@@ -0,0 +1,70 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xmtp/xmtpd/pkg/config"
+	"github.com/xmtp/xmtpd/pkg/ratelimit"
+	test "github.com/xmtp/xmtpd/pkg/testing"
+)
+
+func TestAuditorSampling(t *testing.T) {
+	auditor := newAuditor(test.NewLog(t), newWriterSink(&bytes.Buffer{}, nil), map[string]float64{
+		ratelimit.Publish: 1,
+		ratelimit.Query:   0.5,
+	})
+	auditor.random = func() float64 { return 0.6 }
+
+	require.True(t, auditor.Sample(ratelimit.Publish))
+	require.False(t, auditor.Sample(ratelimit.Query))
+	require.False(t, auditor.Sample(ratelimit.Subscribe))
+
+	auditor.random = func() float64 { return 0.4 }
+	require.True(t, auditor.Sample(ratelimit.Query))
+}
+
+func TestDisabledAuditor(t *testing.T) {
+	auditor, err := NewAuditor(test.NewLog(t), config.AuditOptions{PublishSampleRate: 1})
+	require.NoError(t, err)
+	require.Nil(t, auditor)
+
+	auditor.Start(context.Background())
+	require.False(t, auditor.Sample(ratelimit.Publish))
+}
+
+func TestAuditorWritesJSONLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	auditor, err := NewAuditor(test.NewLog(t), config.AuditOptions{
+		Path:              path,
+		PublishSampleRate: 1,
+	})
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	auditor.Start(ctx)
+
+	auditor.Log(Record{RequestID: "1", Method: "publish", Topics: []string{"0a"}, Envelopes: 1})
+	auditor.Log(Record{RequestID: "2", Method: "query", Code: "OK"})
+
+	require.Eventually(t, func() bool {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		return bytes.Count(data, []byte("\n")) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+	var record Record
+	require.NoError(t, json.Unmarshal(lines[0], &record))
+	require.Equal(t, "1", record.RequestID)
+	require.Equal(t, []string{"0a"}, record.Topics)
+	require.Equal(t, 1, record.Envelopes)
+}
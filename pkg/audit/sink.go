@@ -0,0 +1,40 @@
+package audit
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+)
+
+// Writes records as JSON lines
+type fileSink struct {
+	encoder *json.Encoder
+	closer  io.Closer
+}
+
+// Appends records to the file at path, or writes them to stdout if path is "-"
+func NewFileSink(path string) (Sink, error) {
+	if path == "-" {
+		return newWriterSink(os.Stdout, nil), nil
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return newWriterSink(file, file), nil
+}
+
+func newWriterSink(writer io.Writer, closer io.Closer) *fileSink {
+	return &fileSink{encoder: json.NewEncoder(writer), closer: closer}
+}
+
+func (s *fileSink) Write(record Record) error {
+	return s.encoder.Encode(record)
+}
+
+func (s *fileSink) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer.Close()
+}
@@ -0,0 +1,336 @@
+// Package chainrpc reads from a blockchain through several RPC endpoints, so that the node
+// keeps working when an RPC provider is unavailable.
+package chainrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/xmtp/xmtpd/pkg/metrics"
+	"go.uber.org/zap"
+)
+
+const (
+	// Maximum time an endpoint has to answer a health probe
+	probeTimeout = 5 * time.Second
+)
+
+// A client for a single RPC endpoint
+type RpcClient interface {
+	ethereum.BlockNumberReader
+	ethereum.LogFilterer
+	ethereum.ChainIDReader
+	bind.ContractCaller
+}
+
+// An RPC endpoint, named by position because RPC URLs often embed API keys
+type endpoint struct {
+	name    string
+	client  RpcClient
+	healthy atomic.Bool
+}
+
+/*
+*
+A MultiClient spreads calls over several RPC endpoints for the same chain. Calls go to the
+first healthy endpoint, in the order the endpoints were given, and fail over to the next one
+when an endpoint can't be reached. Endpoints that fail are skipped until a health probe finds
+them working again.
+
+Errors returned by the chain itself, such as reverted calls, are returned without failing
+over, since every endpoint would return them too.
+
+With a hedge delay, BlockNumber and FilterLogs reads are also sent to the next endpoint when
+the first has not answered within the delay, and the first answer wins.
+*/
+type MultiClient struct {
+	logger     *zap.Logger
+	endpoints  []*endpoint
+	hedgeDelay time.Duration
+}
+
+func NewMultiClient(
+	logger *zap.Logger,
+	clients []RpcClient,
+	hedgeDelay time.Duration,
+) (*MultiClient, error) {
+	if len(clients) == 0 {
+		return nil, fmt.Errorf("at least one RPC endpoint is required")
+	}
+	endpoints := make([]*endpoint, 0, len(clients))
+	for idx, client := range clients {
+		e := &endpoint{name: fmt.Sprintf("rpc-%d", idx+1), client: client}
+		e.healthy.Store(true)
+		endpoints = append(endpoints, e)
+	}
+	return &MultiClient{
+		logger:     logger.Named("multiClient"),
+		endpoints:  endpoints,
+		hedgeDelay: hedgeDelay,
+	}, nil
+}
+
+func DialMultiClient(
+	ctx context.Context,
+	logger *zap.Logger,
+	rpcUrls []string,
+	hedgeDelay time.Duration,
+) (*MultiClient, error) {
+	clients := make([]RpcClient, 0, len(rpcUrls))
+	for _, rpcUrl := range rpcUrls {
+		client, err := ethclient.DialContext(ctx, rpcUrl)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+	return NewMultiClient(logger, clients, hedgeDelay)
+}
+
+/*
+*
+Probes every endpoint on an interval, so that endpoints that failed are used again once they
+recover. An interval of 0 disables probing, in which case failed endpoints are only retried
+when every endpoint has failed.
+
+To stop probing callers should cancel the context
+*/
+func (c *MultiClient) Start(ctx context.Context, interval time.Duration) {
+	if interval <= 0 || len(c.endpoints) < 2 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				c.probe(ctx)
+			}
+		}
+	}()
+}
+
+func (c *MultiClient) probe(ctx context.Context) {
+	for _, e := range c.endpoints {
+		probeCtx, cancel := context.WithTimeout(ctx, probeTimeout)
+		_, err := e.client.BlockNumber(probeCtx)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		c.setHealthy(e, "probe", err)
+	}
+}
+
+func (c *MultiClient) setHealthy(e *endpoint, method string, err error) {
+	if err == nil {
+		if e.healthy.CompareAndSwap(false, true) {
+			c.logger.Info("RPC endpoint recovered", zap.String("endpoint", e.name))
+		}
+		return
+	}
+	metrics.EmitChainRpcError(e.name, method)
+	if e.healthy.CompareAndSwap(true, false) {
+		c.logger.Warn(
+			"RPC endpoint failed, failing over",
+			zap.String("endpoint", e.name),
+			zap.String("method", method),
+			zap.Error(err),
+		)
+	}
+}
+
+// Returns the healthy endpoints in order, followed by the unhealthy ones as a last resort
+func (c *MultiClient) candidates() []*endpoint {
+	healthy := make([]*endpoint, 0, len(c.endpoints))
+	unhealthy := []*endpoint{}
+	for _, e := range c.endpoints {
+		if e.healthy.Load() {
+			healthy = append(healthy, e)
+		} else {
+			unhealthy = append(unhealthy, e)
+		}
+	}
+	return append(healthy, unhealthy...)
+}
+
+// Reports whether err came from the chain rather than from reaching the endpoint
+func isChainError(err error) bool {
+	var rpcErr rpc.Error
+	return errors.As(err, &rpcErr)
+}
+
+// Calls fn on each endpoint in turn, until one succeeds
+func call[T any](
+	ctx context.Context,
+	c *MultiClient,
+	method string,
+	fn func(ctx context.Context, client RpcClient) (T, error),
+) (T, error) {
+	var zero T
+	errs := []error{}
+	for _, e := range c.candidates() {
+		result, err := fn(ctx, e.client)
+		if err == nil {
+			c.setHealthy(e, method, nil)
+			return result, nil
+		}
+		if ctx.Err() != nil || isChainError(err) {
+			return zero, err
+		}
+		c.setHealthy(e, method, err)
+		errs = append(errs, fmt.Errorf("%s: %w", e.name, err))
+	}
+	return zero, errors.Join(errs...)
+}
+
+// Like call, but also calls the next endpoint whenever the last one called has not answered
+// within the hedge delay. Returns the first successful result
+func hedge[T any](
+	ctx context.Context,
+	c *MultiClient,
+	method string,
+	fn func(ctx context.Context, client RpcClient) (T, error),
+) (T, error) {
+	if c.hedgeDelay <= 0 {
+		return call(ctx, c, method, fn)
+	}
+
+	type result struct {
+		value    T
+		err      error
+		endpoint *endpoint
+	}
+	var zero T
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	candidates := c.candidates()
+	results := make(chan result, len(candidates))
+	launched := 0
+	launch := func() {
+		e := candidates[launched]
+		launched++
+		go func() {
+			value, err := fn(ctx, e.client)
+			results <- result{value: value, err: err, endpoint: e}
+		}()
+	}
+
+	launch()
+	pending := 1
+	timer := time.NewTimer(c.hedgeDelay)
+	defer timer.Stop()
+	errs := []error{}
+	for {
+		select {
+		case <-ctx.Done():
+			return zero, ctx.Err()
+		case <-timer.C:
+			if launched < len(candidates) {
+				metrics.EmitChainRpcHedge()
+				launch()
+				pending++
+				timer.Reset(c.hedgeDelay)
+			}
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				c.setHealthy(r.endpoint, method, nil)
+				return r.value, nil
+			}
+			if isChainError(r.err) {
+				return zero, r.err
+			}
+			c.setHealthy(r.endpoint, method, r.err)
+			errs = append(errs, fmt.Errorf("%s: %w", r.endpoint.name, r.err))
+			if launched < len(candidates) {
+				launch()
+				pending++
+				timer.Reset(c.hedgeDelay)
+			} else if pending == 0 {
+				return zero, errors.Join(errs...)
+			}
+		}
+	}
+}
+
+func (c *MultiClient) BlockNumber(ctx context.Context) (uint64, error) {
+	return hedge(
+		ctx,
+		c,
+		"BlockNumber",
+		func(ctx context.Context, client RpcClient) (uint64, error) {
+			return client.BlockNumber(ctx)
+		},
+	)
+}
+
+func (c *MultiClient) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	return hedge(
+		ctx,
+		c,
+		"FilterLogs",
+		func(ctx context.Context, client RpcClient) ([]types.Log, error) {
+			return client.FilterLogs(ctx, q)
+		},
+	)
+}
+
+func (c *MultiClient) SubscribeFilterLogs(
+	ctx context.Context,
+	q ethereum.FilterQuery,
+	ch chan<- types.Log,
+) (ethereum.Subscription, error) {
+	return call(
+		ctx,
+		c,
+		"SubscribeFilterLogs",
+		func(ctx context.Context, client RpcClient) (ethereum.Subscription, error) {
+			return client.SubscribeFilterLogs(ctx, q, ch)
+		},
+	)
+}
+
+func (c *MultiClient) ChainID(ctx context.Context) (*big.Int, error) {
+	return call(ctx, c, "ChainID", func(ctx context.Context, client RpcClient) (*big.Int, error) {
+		return client.ChainID(ctx)
+	})
+}
+
+func (c *MultiClient) CodeAt(
+	ctx context.Context,
+	contract common.Address,
+	blockNumber *big.Int,
+) ([]byte, error) {
+	return call(ctx, c, "CodeAt", func(ctx context.Context, client RpcClient) ([]byte, error) {
+		return client.CodeAt(ctx, contract, blockNumber)
+	})
+}
+
+func (c *MultiClient) CallContract(
+	ctx context.Context,
+	msg ethereum.CallMsg,
+	blockNumber *big.Int,
+) ([]byte, error) {
+	return call(
+		ctx,
+		c,
+		"CallContract",
+		func(ctx context.Context, client RpcClient) ([]byte, error) {
+			return client.CallContract(ctx, msg, blockNumber)
+		},
+	)
+}
@@ -0,0 +1,129 @@
+package chainrpc
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	testutils "github.com/xmtp/xmtpd/pkg/testing"
+)
+
+// Implements BlockNumber with blockNumber, and counts the calls. The other methods are not
+// implemented
+type fakeRpcClient struct {
+	RpcClient
+	blockNumber func(ctx context.Context) (uint64, error)
+	calls       atomic.Int32
+}
+
+func (f *fakeRpcClient) BlockNumber(ctx context.Context) (uint64, error) {
+	f.calls.Add(1)
+	return f.blockNumber(ctx)
+}
+
+type chainError struct{}
+
+func (chainError) Error() string  { return "execution reverted" }
+func (chainError) ErrorCode() int { return 3 }
+
+func returnBlock(block uint64, err error) func(context.Context) (uint64, error) {
+	return func(context.Context) (uint64, error) {
+		return block, err
+	}
+}
+
+func newTestMultiClient(
+	t *testing.T,
+	hedgeDelay time.Duration,
+	clients ...*fakeRpcClient,
+) *MultiClient {
+	rpcClients := make([]RpcClient, 0, len(clients))
+	for _, client := range clients {
+		rpcClients = append(rpcClients, client)
+	}
+	multiClient, err := NewMultiClient(testutils.NewLog(t), rpcClients, hedgeDelay)
+	require.NoError(t, err)
+	return multiClient
+}
+
+func TestMultiClientFailover(t *testing.T) {
+	ctx := context.Background()
+	first := &fakeRpcClient{blockNumber: returnBlock(0, errors.New("connection refused"))}
+	second := &fakeRpcClient{blockNumber: returnBlock(10, nil)}
+	client := newTestMultiClient(t, 0, first, second)
+
+	block, err := client.BlockNumber(ctx)
+	require.NoError(t, err)
+	require.EqualValues(t, 10, block)
+
+	// The failed endpoint is skipped until it recovers
+	_, err = client.BlockNumber(ctx)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, first.calls.Load())
+	require.EqualValues(t, 2, second.calls.Load())
+
+	first.blockNumber = returnBlock(11, nil)
+	client.probe(ctx)
+	block, err = client.BlockNumber(ctx)
+	require.NoError(t, err)
+	require.EqualValues(t, 11, block)
+}
+
+func TestMultiClientAllEndpointsFail(t *testing.T) {
+	first := &fakeRpcClient{blockNumber: returnBlock(0, errors.New("connection refused"))}
+	second := &fakeRpcClient{blockNumber: returnBlock(0, errors.New("timeout"))}
+	client := newTestMultiClient(t, 0, first, second)
+
+	_, err := client.BlockNumber(context.Background())
+	require.ErrorContains(t, err, "rpc-1: connection refused")
+	require.ErrorContains(t, err, "rpc-2: timeout")
+
+	// Unhealthy endpoints are still tried when there is nothing else
+	second.blockNumber = returnBlock(10, nil)
+	block, err := client.BlockNumber(context.Background())
+	require.NoError(t, err)
+	require.EqualValues(t, 10, block)
+}
+
+func TestMultiClientChainErrorsDoNotFailOver(t *testing.T) {
+	first := &fakeRpcClient{blockNumber: returnBlock(0, chainError{})}
+	second := &fakeRpcClient{blockNumber: returnBlock(10, nil)}
+	client := newTestMultiClient(t, 0, first, second)
+
+	_, err := client.BlockNumber(context.Background())
+	require.ErrorIs(t, err, chainError{})
+	require.EqualValues(t, 0, second.calls.Load())
+	require.True(t, client.endpoints[0].healthy.Load())
+}
+
+func TestMultiClientHedging(t *testing.T) {
+	// The first endpoint hangs until the hedged request is answered
+	slow := &fakeRpcClient{blockNumber: func(ctx context.Context) (uint64, error) {
+		<-ctx.Done()
+		return 0, ctx.Err()
+	}}
+	fast := &fakeRpcClient{blockNumber: returnBlock(10, nil)}
+	client := newTestMultiClient(t, 10*time.Millisecond, slow, fast)
+
+	block, err := client.BlockNumber(context.Background())
+	require.NoError(t, err)
+	require.EqualValues(t, 10, block)
+	require.EqualValues(t, 1, fast.calls.Load())
+	// A slow endpoint is not a failed one
+	require.True(t, client.endpoints[0].healthy.Load())
+
+	// Failures are failed over without waiting for the hedge delay
+	failing := &fakeRpcClient{blockNumber: returnBlock(0, errors.New("connection refused"))}
+	client = newTestMultiClient(t, time.Hour, failing, fast)
+	block, err = client.BlockNumber(context.Background())
+	require.NoError(t, err)
+	require.EqualValues(t, 10, block)
+}
+
+func TestNewMultiClientRequiresEndpoint(t *testing.T) {
+	_, err := NewMultiClient(testutils.NewLog(t), nil, 0)
+	require.Error(t, err)
+}
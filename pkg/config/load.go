@@ -0,0 +1,56 @@
+package config
+
+import (
+	"os"
+
+	"github.com/jessevdk/go-flags"
+)
+
+/*
+*
+Parses the node's options from args and, when --config-file is set, from an INI file.
+Options given on the command line take precedence over the file, and environment variables
+take precedence over both.
+
+Every option can be set in the file, named as on the command line without the leading
+dashes:
+
+	[Application Options]
+	log-level = DEBUG
+	ratelimit.publish-rate = 20
+*/
+func ParseServerOptions(args []string) (ServerOptions, error) {
+	var file struct {
+		ConfigFile string `long:"config-file"`
+	}
+	if _, err := flags.NewParser(&file, flags.IgnoreUnknown).ParseArgs(args); err != nil {
+		return ServerOptions{}, err
+	}
+
+	var options ServerOptions
+	parser := flags.NewParser(&options, flags.Default)
+	if file.ConfigFile != "" {
+		if err := flags.NewIniParser(parser).ParseFile(file.ConfigFile); err != nil {
+			return ServerOptions{}, err
+		}
+	}
+	if _, err := parser.ParseArgs(args); err != nil {
+		return ServerOptions{}, err
+	}
+	addEnvVars(&options)
+	return options, nil
+}
+
+func addEnvVars(options *ServerOptions) {
+	if connStr, hasConnstr := os.LookupEnv("WRITER_DB_CONNECTION_STRING"); hasConnstr {
+		options.DB.WriterConnectionString = connStr
+	}
+
+	if connStr, hasConnstr := os.LookupEnv("READER_DB_CONNECTION_STRING"); hasConnstr {
+		options.DB.ReaderConnectionString = connStr
+	}
+
+	if privKey, hasPrivKey := os.LookupEnv("PRIVATE_KEY"); hasPrivKey {
+		options.PrivateKeyString = privKey
+	}
+}
@@ -0,0 +1,45 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseServerOptionsConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "xmtpd.ini")
+	require.NoError(t, os.WriteFile(path, []byte(`[Application Options]
+log-level = DEBUG
+api.port = 7000
+ratelimit.allow = 10.0.0.1
+ratelimit.allow = 10.0.0.2
+db.writer-connection-string = postgres://file
+`), 0o600))
+
+	options, err := ParseServerOptions([]string{
+		"--config-file", path,
+		"--api.port", "8000",
+		"--ratelimit.allow", "10.0.0.3",
+	})
+	require.NoError(t, err)
+	require.Equal(t, path, options.ConfigFile)
+	require.Equal(t, "DEBUG", options.LogLevel)
+	// The command line takes precedence over the file
+	require.Equal(t, 8000, options.API.Port)
+	require.Equal(t, []string{"10.0.0.3"}, options.RateLimit.Allowlist)
+	// Options in neither keep their defaults
+	require.Equal(t, 64, options.API.Subscribe.BufferSize)
+}
+
+func TestParseServerOptionsInvalidConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "xmtpd.ini")
+	require.NoError(t, os.WriteFile(path, []byte("[Application Options]\nport = 1\n"), 0o600))
+
+	_, err := ParseServerOptions([]string{"--config-file", path})
+	require.ErrorContains(t, err, "unknown option: port")
+
+	_, err = ParseServerOptions([]string{"--config-file", path + ".missing"})
+	require.Error(t, err)
+}
@@ -5,23 +5,127 @@ import (
 )
 
 type ApiOptions struct {
-	Port int `short:"p" long:"port" description:"Port to listen on" default:"5050"`
+	Port                int           `short:"p" long:"port"                  description:"Port to listen on"                                                                                                     default:"5050"`
+	HTTPPort            int           `          long:"http-port"             description:"Port to serve the HTTP/JSON gateway on. 0 disables the gateway"`
+	RequestTimeout      time.Duration `          long:"request-timeout"       description:"Maximum time spent serving a unary request. Shorter client deadlines take precedence"                                  default:"30s"`
+	DeadlineHeader      string        `          long:"deadline-header"       description:"HTTP header that gateway clients may send a timeout in, such as 5s, in addition to Grpc-Timeout. Empty disables"`
+	ScheduledPublishes  []string      `          long:"scheduled-publish"     description:"Publish a timestamped envelope to a topic on an interval, as <interval>:<hex topic>. May be repeated"`
+	KeepaliveInterval   time.Duration `          long:"keepalive-interval"    description:"Ping idle connections after this long, so intermediaries keep them open"                                               default:"5m"`
+	KeepaliveTimeout    time.Duration `          long:"keepalive-timeout"     description:"Close connections that do not answer a keepalive ping within this time"                                                default:"20s"`
+	MaxConnectionIdle   time.Duration `          long:"max-connection-idle"   description:"Close connections with no active RPCs or streams after this long. 0 disables"`
+	MaxEnvelopeSize     int           `          long:"max-envelope-size"     description:"Maximum size of a published client envelope in bytes"                                                                  default:"4194304"`
+	MaxTopicLength      int           `          long:"max-topic-length"      description:"Maximum length of a published topic in bytes"                                                                          default:"256"`
+	PublishDedupWindow  time.Duration `          long:"publish-dedup-window"  description:"Time within which an identical publish returns the original envelope instead of storing a duplicate. 0 disables"       default:"5m"`
+	IntegritySampleRate float64       `          long:"integrity-sample-rate" description:"Fraction of queried envelopes whose originator signature is verified. 0 disables"`
+	SignResponses       bool          `          long:"sign-responses"        description:"Sign query responses with the node's signing key, so clients can verify them against the registry"`
+	TrustedProxies      []string      `          long:"trusted-proxy"         description:"IP address or CIDR range of a load balancer trusted to send client addresses with the PROXY protocol. May be repeated"`
+	DrainTimeout        time.Duration `          long:"drain-timeout"         description:"On shutdown, time to wait for in-flight requests to finish and staged publishes to be stored. 0 closes immediately"    default:"10s"`
+
+	TLS       TLSOptions       `group:"TLS Options"       namespace:"tls"`
+	Subscribe SubscribeOptions `group:"Subscribe Options" namespace:"subscribe"`
+}
+
+type SubscribeOptions struct {
+	BufferSize int    `long:"buffer-size" description:"Number of envelope batches buffered per subscriber"                                                                                       default:"64"`
+	SlowPolicy string `long:"slow-policy" description:"What to do when a subscriber's buffer is full. disconnect (with a resumable cursor), drop-oldest, or catch-up (resume from the database)" default:"disconnect"`
+}
+
+type TLSOptions struct {
+	CertFile          string   `long:"cert-file"           description:"PEM encoded certificate to serve TLS with"`
+	KeyFile           string   `long:"key-file"            description:"PEM encoded private key of the certificate"`
+	AutocertHosts     []string `long:"autocert-host"       description:"Host name to obtain a certificate for from Let's Encrypt. May be repeated"`
+	AutocertCacheDir  string   `long:"autocert-cache-dir"  description:"Directory to store certificates obtained from Let's Encrypt in"`
+	AutocertEmail     string   `long:"autocert-email"      description:"Contact email for the Let's Encrypt account"`
+	ClientCAFile      string   `long:"client-ca-file"      description:"PEM encoded CA that client certificates are verified against, for mutual TLS between nodes"`
+	RequireClientCert bool     `long:"require-client-cert" description:"Reject gRPC clients that do not present a certificate signed by the client CA. The HTTP gateway still accepts them"`
 }
 
 type ContractsOptions struct {
-	RpcUrl                  string        `long:"rpc-url"          description:"Blockchain RPC URL"`
-	NodesContractAddress    string        `long:"nodes-address"    description:"Node contract address"`
-	MessagesContractAddress string        `long:"messages-address" description:"Message contract address"`
-	RefreshInterval         time.Duration `long:"refresh-interval" description:"Refresh interval"         default:"60s"`
+	RpcUrls                 []string      `long:"rpc-url"              description:"Blockchain RPC URL. May be repeated, to fail over to the next URL when one is unavailable"`
+	RpcProbeInterval        time.Duration `long:"rpc-probe-interval"   description:"How often to check the RPC URLs, so that failed ones are used again once they recover. 0 disables"                                            default:"30s"`
+	RpcHedgeDelay           time.Duration `long:"rpc-hedge-delay"      description:"Also send block number and log reads to the next RPC URL when the first has not answered within this time. 0 disables"`
+	NodesContractAddress    string        `long:"nodes-address"        description:"Node contract address"`
+	MessagesContractAddress string        `long:"messages-address"     description:"Message contract address"`
+	RefreshInterval         time.Duration `long:"refresh-interval"     description:"Refresh interval"                                                                                                                             default:"60s"`
+	OperatorPrivateKey      string        `long:"operator-private-key" description:"Private key of the node NFT owner. When set, the node keeps its HTTP address on the Nodes contract up to date"`
+	HttpAddress             string        `long:"http-address"         description:"Public address of this node, as published to the Nodes contract"`
+	AdditionalChains        []string      `long:"additional-chain"     description:"Also read the Nodes contract from another chain, as <nodes address>@<rpc url>. The primary chain wins when node IDs collide. May be repeated"`
+	SnapshotPath            string        `long:"snapshot-path"        description:"File to keep a copy of the node list in, signed with the node's private key. The node starts from it when the Nodes contract can't be read"`
+}
+
+type DebugOptions struct {
+	Port    int    `long:"port"    description:"Port to serve /debug/summary on. 0 disables the debug server"`
+	Address string `long:"address" description:"Address to serve the debug server on. It exposes node internals, so only bind it to a private interface" default:"127.0.0.1"`
 }
 
 type DbOptions struct {
 	ReaderConnectionString string        `long:"reader-connection-string" description:"Reader connection string"`
-	WriterConnectionString string        `long:"writer-connection-string" description:"Writer connection string"                       required:"true"`
-	ReadTimeout            time.Duration `long:"read-timeout"             description:"Timeout for reading from the database"                          default:"10s"`
-	WriteTimeout           time.Duration `long:"write-timeout"            description:"Timeout for writing to the database"                            default:"10s"`
-	MaxOpenConns           int           `long:"max-open-conns"           description:"Maximum number of open connections"                             default:"80"`
+	WriterConnectionString string        `long:"writer-connection-string" description:"Writer connection string"                                        required:"true"`
+	ReadTimeout            time.Duration `long:"read-timeout"             description:"Timeout for reading from the database"                                           default:"10s"`
+	WriteTimeout           time.Duration `long:"write-timeout"            description:"Timeout for writing to the database"                                             default:"10s"`
+	MaxOpenConns           int           `long:"max-open-conns"           description:"Maximum number of open connections"                                              default:"80"`
 	WaitForDB              time.Duration `long:"wait-for"                 description:"wait for DB on start, up to specified duration"`
+	AnalyzeInterval        time.Duration `long:"analyze-interval"         description:"How often to ANALYZE the database. 0 disables"                                   default:"6h"`
+	StatsInterval          time.Duration `long:"stats-interval"           description:"How often to report table and slow query statistics. 0 disables"                 default:"15m"`
+	SlowQueryThreshold     time.Duration `long:"slow-query-threshold"     description:"Mean execution time above which queries are reported as slow"                    default:"100ms"`
+	ExplainSlowQueries     bool          `long:"explain-slow-queries"     description:"Log the query plan of slow queries (requires Postgres 16)"`
+}
+
+type AuditOptions struct {
+	Path                string  `long:"path"                  description:"File to append audit records to as JSON lines, or - for stdout. Empty disables the audit log"`
+	PublishSampleRate   float64 `long:"publish-sample-rate"   description:"Fraction of publishes to record"                                                              default:"1"`
+	QuerySampleRate     float64 `long:"query-sample-rate"     description:"Fraction of queries to record"                                                                default:"1"`
+	SubscribeSampleRate float64 `long:"subscribe-sample-rate" description:"Fraction of subscriptions to record"                                                          default:"1"`
+}
+
+type AlertOptions struct {
+	WebhookURLs         []string      `long:"webhook-url"           description:"URL to post alerts to as JSON. May be repeated"`
+	SlackWebhookURL     string        `long:"slack-webhook-url"     description:"Slack incoming webhook URL to post alerts to"`
+	PagerDutyRoutingKey string        `long:"pagerduty-routing-key" description:"PagerDuty Events API v2 routing key. Only critical alerts are sent to PagerDuty"`
+	Source              string        `long:"source"                description:"Name of this node in alerts"                                                     default:"xmtpd"`
+	DedupWindow         time.Duration `long:"dedup-window"          description:"Minimum time between repeated alerts for the same condition"                     default:"1h"`
+	CheckInterval       time.Duration `long:"check-interval"        description:"How often to run the health checks that alerts are raised from. 0 disables"      default:"1m"`
+}
+
+type HealthOptions struct {
+	Port           int           `long:"port"             description:"Port to serve /healthz and /readyz on. 0 disables the health server"`
+	MaxRegistryAge time.Duration `long:"max-registry-age" description:"Time since the last successful registry refresh after which the node is not ready" default:"5m"`
+}
+
+type MetricsOptions struct {
+	Port int `long:"port" description:"Port to serve Prometheus /metrics on. 0 disables the metrics server"`
+}
+
+type OverloadOptions struct {
+	MaxGoroutines  int           `long:"max-goroutines"  description:"Goroutine count at which queries and subscriptions are rejected. 0 disables"`
+	MaxOpenFiles   int           `long:"max-open-files"  description:"Open file count at which queries and subscriptions are rejected. 0 disables"`
+	MaxCPU         float64       `long:"max-cpu"         description:"Fraction of CPU at which queries and subscriptions are rejected. 0 disables"`
+	SoftLimit      float64       `long:"soft-limit"      description:"Fraction of any limit at which subscriptions start being rejected"           default:"0.8"`
+	SampleInterval time.Duration `long:"sample-interval" description:"How often to sample resource usage"                                          default:"1s"`
+}
+
+type RateLimitOptions struct {
+	PublishRate    float64  `long:"publish-rate"    description:"Publish requests per second allowed per IP. 0 disables"`
+	PublishBurst   int      `long:"publish-burst"   description:"Publish requests allowed per IP in a burst"                                            default:"50"`
+	QueryRate      float64  `long:"query-rate"      description:"Query requests per second allowed per IP. 0 disables"`
+	QueryBurst     int      `long:"query-burst"     description:"Query requests allowed per IP in a burst"                                              default:"100"`
+	SubscribeRate  float64  `long:"subscribe-rate"  description:"Subscribe requests per second allowed per IP. 0 disables"`
+	SubscribeBurst int      `long:"subscribe-burst" description:"Subscribe requests allowed per IP in a burst"                                          default:"10"`
+	Allowlist      []string `long:"allow"           description:"IP address or CIDR range exempt from rate limits, such as peer nodes. May be repeated"`
+}
+
+type SpamOptions struct {
+	Mode                  string        `long:"mode"                     description:"Spam filter mode. off, shadow (label only) or enforce (reject)"              default:"off"`
+	Window                time.Duration `long:"window"                   description:"Window over which rates and duplicates are counted"                          default:"1m"`
+	TopicRateLimit        int           `long:"topic-rate-limit"         description:"Maximum envelopes per topic per window. 0 disables"                          default:"600"`
+	DuplicateTopicLimit   int           `long:"duplicate-topic-limit"    description:"Maximum topics identical content may be published to per window. 0 disables" default:"20"`
+	MinEntropy            float64       `long:"min-entropy"              description:"Minimum payload entropy in bits per byte. 0 disables"                        default:"3"`
+	MinEntropyPayloadSize int           `long:"min-entropy-payload-size" description:"Payloads smaller than this are not checked for entropy"                      default:"256"`
+}
+
+type PayerOptions struct {
+	Mode      string   `long:"mode"  description:"Payer verification mode. none, or signature (require a valid payer signature)"       default:"none"`
+	Allowlist []string `long:"allow" description:"Address of a payer allowed to publish. Requires the signature mode. May be repeated"`
 }
 
 type ServerOptions struct {
@@ -30,8 +134,65 @@ type ServerOptions struct {
 	LogEncoding string `          long:"log-encoding" description:"Log encoding format. Either console or json"                                                                                  default:"console" choice:"console"`
 
 	PrivateKeyString string `long:"private-key" description:"Private key to use for the node"`
+	ReadOnly         bool   `long:"read-only"   description:"Reject publishes and only serve queries and subscriptions. Read-only nodes do not take the writer lock, so they can share a database with a writable node"`
+	ConfigFile       string `long:"config-file" description:"INI file to read options from. Options on the command line take precedence. Re-read on SIGHUP"                                                             no-ini:"true"`
+
+	API       ApiOptions       `group:"API Options"        namespace:"api"`
+	DB        DbOptions        `group:"Database Options"   namespace:"db"`
+	Contracts ContractsOptions `group:"Contracts Options"  namespace:"contracts"`
+	Spam      SpamOptions      `group:"Spam Options"       namespace:"spam"`
+	Payer     PayerOptions     `group:"Payer Options"      namespace:"payer"`
+	Overload  OverloadOptions  `group:"Overload Options"   namespace:"overload"`
+	RateLimit RateLimitOptions `group:"Rate Limit Options" namespace:"ratelimit"`
+	Debug     DebugOptions     `group:"Debug Options"      namespace:"debug"`
+	Health    HealthOptions    `group:"Health Options"     namespace:"health"`
+	Alerts    AlertOptions     `group:"Alert Options"      namespace:"alerts"`
+	Audit     AuditOptions     `group:"Audit Options"      namespace:"audit"`
+	Metrics   MetricsOptions   `group:"Metrics Options"    namespace:"metrics"`
+}
+
+type StoreOptions struct {
+	LogLevel string `long:"log-level" description:"Define the logging level" default:"INFO"`
+
+	DB DbOptions `group:"Database Options" namespace:"db"`
+
+	Export ExportOptions `command:"export" description:"Write stored envelopes to a file"`
+	Import ImportOptions `command:"import" description:"Insert the envelopes in an export into the database. Stop the node first"`
+}
+
+type ExportOptions struct {
+	Output string `long:"output"  description:"File to write the export to, or - for stdout"                default:"-"`
+	FromID int64  `long:"from-id" description:"Only export envelopes with a gateway sequence ID above this"`
+}
+
+type ImportOptions struct {
+	Input string `long:"input" description:"Export file to read, or - for stdin" default:"-"`
+}
+
+type RegisterNodeOptions struct {
+	OwnerAddress  string `long:"owner-address"   description:"Address that will own the node NFT"                      required:"true"`
+	SigningKeyPub string `long:"signing-key-pub" description:"Hex encoded uncompressed public key the node signs with" required:"true"`
+	HttpAddress   string `long:"http-address"    description:"Public HTTP address of the node"                         required:"true"`
+}
+
+type UpdateHttpAddressOptions struct {
+	NodeID      uint16 `long:"node-id"      description:"ID of the node to update"        required:"true"`
+	HttpAddress string `long:"http-address" description:"Public HTTP address of the node" required:"true"`
+}
+
+type SetHealthyOptions struct {
+	NodeID    uint16 `long:"node-id"   description:"ID of the node to update"        required:"true"`
+	Unhealthy bool   `long:"unhealthy" description:"Mark the node unhealthy instead"`
+}
+
+type AdminOptions struct {
+	LogLevel             string `long:"log-level"     description:"Define the logging level"                                                            default:"INFO"`
+	RpcUrl               string `long:"rpc-url"       description:"Blockchain RPC URL"                                                                                 required:"true"`
+	NodesContractAddress string `long:"nodes-address" description:"Node contract address"                                                                              required:"true"`
+	PrivateKey           string `long:"private-key"   description:"Private key of the contract owner, or of the node NFT owner for update-http-address"                required:"true"`
+	DryRun               bool   `long:"dry-run"       description:"Sign transactions and log them without sending"`
 
-	API       ApiOptions       `group:"API Options"       namespace:"api"`
-	DB        DbOptions        `group:"Database Options"  namespace:"db"`
-	Contracts ContractsOptions `group:"Contracts Options" namespace:"contracts"`
+	RegisterNode      RegisterNodeOptions      `command:"register-node"       description:"Mint a node NFT. Requires the contract owner's key"`
+	UpdateHttpAddress UpdateHttpAddressOptions `command:"update-http-address" description:"Update a node's HTTP address. Requires the node NFT owner's key"`
+	SetHealthy        SetHealthyOptions        `command:"set-healthy"         description:"Mark a node healthy or unhealthy. Requires the contract owner's key"`
 }
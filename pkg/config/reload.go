@@ -0,0 +1,38 @@
+package config
+
+import (
+	"reflect"
+)
+
+// The outcome of reloading the node's options
+type ReloadReport struct {
+	// Options whose new values are in effect
+	Applied []string `json:"applied"`
+	// Options that changed, but keep their old values until the node is restarted
+	RequiresRestart []string `json:"requiresRestart"`
+}
+
+// Returns the names of the options that differ between old and new, as they are given on the
+// command line without the leading dashes, such as ratelimit.publish-rate
+func ChangedOptions(old, new ServerOptions) []string {
+	changed := []string{}
+	diffOptions(reflect.ValueOf(old), reflect.ValueOf(new), "", &changed)
+	return changed
+}
+
+func diffOptions(old, new reflect.Value, namespace string, changed *[]string) {
+	for i := 0; i < old.NumField(); i++ {
+		field := old.Type().Field(i)
+		if ns, ok := field.Tag.Lookup("namespace"); ok {
+			diffOptions(old.Field(i), new.Field(i), namespace+ns+".", changed)
+			continue
+		}
+		long, ok := field.Tag.Lookup("long")
+		if !ok {
+			continue
+		}
+		if !reflect.DeepEqual(old.Field(i).Interface(), new.Field(i).Interface()) {
+			*changed = append(*changed, namespace+long)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChangedOptions(t *testing.T) {
+	old := ServerOptions{LogLevel: "INFO"}
+	old.RateLimit.Allowlist = []string{"10.0.0.1"}
+	require.Empty(t, ChangedOptions(old, old))
+
+	new := old
+	new.LogLevel = "DEBUG"
+	new.API.TLS.CertFile = "cert.pem"
+	new.Contracts.RefreshInterval = time.Minute
+	new.RateLimit.Allowlist = []string{"10.0.0.1", "10.0.0.2"}
+	require.Equal(t, []string{
+		"log-level",
+		"api.tls.cert-file",
+		"contracts.refresh-interval",
+		"ratelimit.allow",
+	}, ChangedOptions(old, new))
+}
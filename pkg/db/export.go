@@ -0,0 +1,295 @@
+package db
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/xmtp/xmtpd/pkg/db/queries"
+)
+
+/*
+Exports are a stream of gateway envelopes in gateway order. Each record carries the
+envelope's gateway sequence ID, and imports store envelopes under the same ID, so that the
+cursors clients hold remain valid on the importing node.
+
+	header:  "XMTPDEXP" | version uint32
+	record:  length uint32 | body | crc32c(body) uint32
+	body:    gateway sequence ID uint64 | originator node ID uint32 |
+	         originator sequence ID uint64 | topic length uint32 | topic |
+	         envelope length uint32 | envelope
+	trailer: length 0 | record count uint64
+
+All integers are big endian. The trailer lets imports detect truncated files.
+*/
+const (
+	EXPORT_MAGIC   = "XMTPDEXP"
+	EXPORT_VERSION = 2
+	// Number of envelopes read or written per database round trip
+	exportBatchSize = 1000
+	// Larger records are rejected on import, to bound memory on corrupt input
+	maxExportRecordSize = 64 << 20
+)
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+type ImportStats struct {
+	// Envelopes inserted into the database
+	Imported int64
+	// Envelopes that were already stored, for example from a previous partial import
+	Skipped int64
+}
+
+// Writes every gateway envelope with an ID above fromID to w. Returns the number written
+func ExportEnvelopes(ctx context.Context, db *sql.DB, w io.Writer, fromID int64) (int64, error) {
+	writer := bufio.NewWriter(w)
+	if _, err := writer.WriteString(EXPORT_MAGIC); err != nil {
+		return 0, err
+	}
+	if err := binary.Write(writer, binary.BigEndian, uint32(EXPORT_VERSION)); err != nil {
+		return 0, err
+	}
+
+	q := queries.New(db)
+	count := int64(0)
+	lastID := fromID
+	for {
+		rows, err := q.SelectGatewayEnvelopes(ctx, queries.SelectGatewayEnvelopesParams{
+			GatewaySequenceID: sql.NullInt64{Int64: lastID, Valid: true},
+			RowLimit:          sql.NullInt32{Int32: exportBatchSize, Valid: true},
+		})
+		if err != nil {
+			return count, err
+		}
+		for _, row := range rows {
+			if err := writeRecord(writer, row); err != nil {
+				return count, err
+			}
+			count++
+			lastID = row.ID
+		}
+		if len(rows) < exportBatchSize {
+			break
+		}
+	}
+
+	if err := binary.Write(writer, binary.BigEndian, uint32(0)); err != nil {
+		return count, err
+	}
+	if err := binary.Write(writer, binary.BigEndian, uint64(count)); err != nil {
+		return count, err
+	}
+	return count, writer.Flush()
+}
+
+/*
+*
+Reads an export from r and inserts its envelopes under their gateway sequence IDs. Envelopes
+that are already stored are skipped, so an interrupted import can be resumed by running it
+again. An envelope whose gateway sequence ID is used by a different envelope fails the
+import, since that database holds envelopes the export doesn't.
+
+Batches are written with the writer lock's fencing token, so a node can't write to the
+database while an import is running.
+*/
+func ImportEnvelopes(
+	ctx context.Context,
+	writerLock *WriterLock,
+	r io.Reader,
+) (ImportStats, error) {
+	stats := ImportStats{}
+	reader := bufio.NewReader(r)
+	if err := readHeader(reader); err != nil {
+		return stats, err
+	}
+
+	count := uint64(0)
+	batch := make([]queries.InsertGatewayEnvelopeWithIDParams, 0, exportBatchSize)
+	for {
+		record, done, err := readRecord(reader)
+		if err != nil {
+			return stats, fmt.Errorf("record %d: %w", count, err)
+		}
+		if done {
+			break
+		}
+		batch = append(batch, record)
+		count++
+		if len(batch) == exportBatchSize {
+			if err := importBatch(ctx, writerLock, batch, &stats); err != nil {
+				return stats, err
+			}
+			batch = batch[:0]
+		}
+	}
+	if err := importBatch(ctx, writerLock, batch, &stats); err != nil {
+		return stats, err
+	}
+
+	var expected uint64
+	if err := binary.Read(reader, binary.BigEndian, &expected); err != nil {
+		return stats, fmt.Errorf("reading trailer: %w", err)
+	}
+	if expected != count {
+		return stats, fmt.Errorf("export has %d records, trailer expects %d", count, expected)
+	}
+
+	err := writerLock.RunFenced(ctx, func(q *queries.Queries) error {
+		return q.AdvanceStagedOriginatorEnvelopeSequence(ctx)
+	})
+	return stats, err
+}
+
+func importBatch(
+	ctx context.Context,
+	writerLock *WriterLock,
+	batch []queries.InsertGatewayEnvelopeWithIDParams,
+	stats *ImportStats,
+) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	imported := int64(0)
+	err := writerLock.RunFenced(ctx, func(q *queries.Queries) error {
+		for _, params := range batch {
+			// On unique constraint conflicts, no error is thrown, but numRows is 0
+			inserted, err := q.InsertGatewayEnvelopeWithID(ctx, params)
+			if err != nil {
+				return err
+			}
+			if inserted == 0 {
+				if err := checkImported(ctx, q, params); err != nil {
+					return err
+				}
+			}
+			imported += inserted
+		}
+		// Advanced with every batch, so that envelopes stored after an interrupted import
+		// can't take the IDs of imported ones
+		return q.AdvanceGatewayEnvelopeSequence(ctx)
+	})
+	if err != nil {
+		return err
+	}
+	stats.Imported += imported
+	stats.Skipped += int64(len(batch)) - imported
+	return nil
+}
+
+// Returns an error unless the envelope is already stored under its gateway sequence ID
+func checkImported(
+	ctx context.Context,
+	q *queries.Queries,
+	params queries.InsertGatewayEnvelopeWithIDParams,
+) error {
+	rows, err := q.SelectGatewayEnvelopes(ctx, queries.SelectGatewayEnvelopesParams{
+		GatewaySequenceID: sql.NullInt64{Int64: params.ID - 1, Valid: true},
+		RowLimit:          sql.NullInt32{Int32: 1, Valid: true},
+	})
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 || rows[0].ID != params.ID ||
+		rows[0].OriginatorNodeID != params.OriginatorNodeID ||
+		rows[0].OriginatorSequenceID != params.OriginatorSequenceID {
+		return fmt.Errorf(
+			"envelope %d:%d conflicts with a different envelope stored in the database, "+
+				"imports must go into an empty database or one restored from the same node",
+			params.OriginatorNodeID,
+			params.OriginatorSequenceID,
+		)
+	}
+	return nil
+}
+
+func writeRecord(w io.Writer, row queries.GatewayEnvelope) error {
+	body := make([]byte, 0, 28+len(row.Topic)+len(row.OriginatorEnvelope))
+	body = binary.BigEndian.AppendUint64(body, uint64(row.ID))
+	body = binary.BigEndian.AppendUint32(body, uint32(row.OriginatorNodeID))
+	body = binary.BigEndian.AppendUint64(body, uint64(row.OriginatorSequenceID))
+	body = binary.BigEndian.AppendUint32(body, uint32(len(row.Topic)))
+	body = append(body, row.Topic...)
+	body = binary.BigEndian.AppendUint32(body, uint32(len(row.OriginatorEnvelope)))
+	body = append(body, row.OriginatorEnvelope...)
+
+	if err := binary.Write(w, binary.BigEndian, uint32(len(body))); err != nil {
+		return err
+	}
+	if _, err := w.Write(body); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.BigEndian, crc32.Checksum(body, crc32c))
+}
+
+func readHeader(r io.Reader) error {
+	magic := make([]byte, len(EXPORT_MAGIC))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return fmt.Errorf("reading header: %w", err)
+	}
+	if string(magic) != EXPORT_MAGIC {
+		return errors.New("not an xmtpd export")
+	}
+	var version uint32
+	if err := binary.Read(r, binary.BigEndian, &version); err != nil {
+		return fmt.Errorf("reading header: %w", err)
+	}
+	if version != EXPORT_VERSION {
+		return fmt.Errorf("unsupported export version %d", version)
+	}
+	return nil
+}
+
+// Returns done at the trailer
+func readRecord(r io.Reader) (queries.InsertGatewayEnvelopeWithIDParams, bool, error) {
+	params := queries.InsertGatewayEnvelopeWithIDParams{}
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return params, false, fmt.Errorf("export is truncated: %w", err)
+	}
+	if length == 0 {
+		return params, true, nil
+	}
+	if length > maxExportRecordSize {
+		return params, false, fmt.Errorf("record of %d bytes is too large", length)
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return params, false, fmt.Errorf("export is truncated: %w", err)
+	}
+	var checksum uint32
+	if err := binary.Read(r, binary.BigEndian, &checksum); err != nil {
+		return params, false, fmt.Errorf("export is truncated: %w", err)
+	}
+	if crc32.Checksum(body, crc32c) != checksum {
+		return params, false, errors.New("checksum mismatch")
+	}
+
+	errMalformed := errors.New("malformed record")
+	if len(body) < 24 {
+		return params, false, errMalformed
+	}
+	params.ID = int64(binary.BigEndian.Uint64(body[0:8]))
+	params.OriginatorNodeID = int32(binary.BigEndian.Uint32(body[8:12]))
+	params.OriginatorSequenceID = int64(binary.BigEndian.Uint64(body[12:20]))
+	topicLength := binary.BigEndian.Uint32(body[20:24])
+	body = body[24:]
+	if uint64(len(body)) < uint64(topicLength)+4 {
+		return params, false, errMalformed
+	}
+	params.Topic = body[:topicLength]
+	body = body[topicLength:]
+	envelopeLength := binary.BigEndian.Uint32(body[0:4])
+	body = body[4:]
+	if uint64(len(body)) != uint64(envelopeLength) {
+		return params, false, errMalformed
+	}
+	params.OriginatorEnvelope = body
+
+	return params, false, nil
+}
@@ -0,0 +1,241 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xmtp/xmtpd/pkg/db/queries"
+	test "github.com/xmtp/xmtpd/pkg/testing"
+)
+
+func insertExportRows(t *testing.T, ctx context.Context, lock *WriterLock, count int) {
+	require.NoError(t, lock.RunFenced(ctx, func(q *queries.Queries) error {
+		for i := 1; i <= count; i++ {
+			_, err := q.InsertGatewayEnvelope(ctx, queries.InsertGatewayEnvelopeParams{
+				OriginatorID:         int32(i%2 + 1),
+				OriginatorSequenceID: int64(i),
+				Topic:                []byte("topic"),
+				OriginatorEnvelope:   []byte{byte(i)},
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+}
+
+func TestExportRecordRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	row := queries.GatewayEnvelope{
+		ID:                   9,
+		OriginatorNodeID:     7,
+		OriginatorSequenceID: 42,
+		Topic:                []byte("topicA"),
+		OriginatorEnvelope:   []byte("envelope"),
+	}
+	require.NoError(t, writeRecord(&buf, row))
+
+	params, done, err := readRecord(&buf)
+	require.NoError(t, err)
+	require.False(t, done)
+	require.Equal(t, queries.InsertGatewayEnvelopeWithIDParams{
+		ID:                   9,
+		OriginatorNodeID:     7,
+		OriginatorSequenceID: 42,
+		Topic:                []byte("topicA"),
+		OriginatorEnvelope:   []byte("envelope"),
+	}, params)
+}
+
+func TestExportRecordCorrupt(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, writeRecord(&buf, queries.GatewayEnvelope{
+		Topic:              []byte("topicA"),
+		OriginatorEnvelope: []byte("envelope"),
+	}))
+	data := buf.Bytes()
+	data[10] ^= 0xff
+
+	_, _, err := readRecord(bytes.NewReader(data))
+	require.ErrorContains(t, err, "checksum mismatch")
+
+	_, _, err = readRecord(bytes.NewReader(data[:len(data)-2]))
+	require.ErrorContains(t, err, "truncated")
+}
+
+func TestExportHeader(t *testing.T) {
+	require.ErrorContains(t, readHeader(bytes.NewReader([]byte("NOTANEXPORT!"))), "not an")
+	require.ErrorContains(t, readHeader(bytes.NewReader([]byte("XMTP"))), "reading header")
+}
+
+func TestExportImport(t *testing.T) {
+	ctx := context.Background()
+	source, _, cleanupSource := test.NewDB(t, ctx)
+	defer cleanupSource()
+	sourceLock, err := AcquireWriterLock(ctx, source)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, sourceLock.Release())
+	}()
+	insertExportRows(t, ctx, sourceLock, exportBatchSize+5)
+	// Conflicting inserts use up gateway IDs, leaving gaps that imports must preserve
+	insertExportRows(t, ctx, sourceLock, 2)
+	require.NoError(t, sourceLock.RunFenced(ctx, func(q *queries.Queries) error {
+		_, err := q.InsertGatewayEnvelope(ctx, queries.InsertGatewayEnvelopeParams{
+			OriginatorID:         3,
+			OriginatorSequenceID: 1,
+			Topic:                []byte("topic"),
+			OriginatorEnvelope:   []byte("after gap"),
+		})
+		return err
+	}))
+
+	var buf bytes.Buffer
+	count, err := ExportEnvelopes(ctx, source, &buf, 0)
+	require.NoError(t, err)
+	require.EqualValues(t, exportBatchSize+6, count)
+
+	target, _, cleanupTarget := test.NewDB(t, ctx)
+	defer cleanupTarget()
+	targetLock, err := AcquireWriterLock(ctx, target)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, targetLock.Release())
+	}()
+
+	stats, err := ImportEnvelopes(ctx, targetLock, bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, ImportStats{Imported: exportBatchSize + 6}, stats)
+
+	expected, err := queries.New(source).
+		SelectGatewayEnvelopes(ctx, queries.SelectGatewayEnvelopesParams{})
+	require.NoError(t, err)
+	actual, err := queries.New(target).
+		SelectGatewayEnvelopes(ctx, queries.SelectGatewayEnvelopesParams{})
+	require.NoError(t, err)
+	require.Equal(t, expected, actual)
+
+	// Importing again is a no-op, which is how interrupted imports are resumed
+	stats, err = ImportEnvelopes(ctx, targetLock, bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, ImportStats{Skipped: exportBatchSize + 6}, stats)
+
+	// Newly staged envelopes must not reuse imported sequence IDs
+	staged, err := queries.New(target).InsertStagedOriginatorEnvelope(
+		ctx,
+		queries.InsertStagedOriginatorEnvelopeParams{
+			Topic:         []byte("topic"),
+			PayerEnvelope: []byte("new"),
+		},
+	)
+	require.NoError(t, err)
+	require.Greater(t, staged.ID, int64(exportBatchSize+5))
+
+	// Newly stored envelopes must not reuse imported gateway IDs
+	require.NoError(t, targetLock.RunFenced(ctx, func(q *queries.Queries) error {
+		_, err := q.InsertGatewayEnvelope(ctx, queries.InsertGatewayEnvelopeParams{
+			OriginatorID:         3,
+			OriginatorSequenceID: 2,
+			Topic:                []byte("topic"),
+			OriginatorEnvelope:   []byte("new"),
+		})
+		return err
+	}))
+	latest, err := queries.New(target).SelectLatestGatewayEnvelopeID(ctx)
+	require.NoError(t, err)
+	require.Equal(t, expected[len(expected)-1].ID+1, latest)
+}
+
+func TestImportPartialExport(t *testing.T) {
+	ctx := context.Background()
+	source, _, cleanupSource := test.NewDB(t, ctx)
+	defer cleanupSource()
+	sourceLock, err := AcquireWriterLock(ctx, source)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, sourceLock.Release())
+	}()
+	insertExportRows(t, ctx, sourceLock, 5)
+
+	var buf bytes.Buffer
+	count, err := ExportEnvelopes(ctx, source, &buf, 3)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, count)
+
+	target, _, cleanupTarget := test.NewDB(t, ctx)
+	defer cleanupTarget()
+	targetLock, err := AcquireWriterLock(ctx, target)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, targetLock.Release())
+	}()
+	_, err = ImportEnvelopes(ctx, targetLock, bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+
+	// Envelopes keep their gateway IDs, even when the export starts past the first one
+	rows, err := queries.New(target).
+		SelectGatewayEnvelopes(ctx, queries.SelectGatewayEnvelopesParams{})
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+	require.EqualValues(t, 4, rows[0].ID)
+	require.EqualValues(t, 5, rows[1].ID)
+}
+
+func TestImportConflictingGatewayID(t *testing.T) {
+	ctx := context.Background()
+	source, _, cleanupSource := test.NewDB(t, ctx)
+	defer cleanupSource()
+	lock, err := AcquireWriterLock(ctx, source)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, lock.Release())
+	}()
+	insertExportRows(t, ctx, lock, 3)
+
+	var buf bytes.Buffer
+	_, err = ExportEnvelopes(ctx, source, &buf, 0)
+	require.NoError(t, err)
+
+	// A database holding other envelopes under the same gateway IDs can't take the import
+	target, _, cleanupTarget := test.NewDB(t, ctx)
+	defer cleanupTarget()
+	targetLock, err := AcquireWriterLock(ctx, target)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, targetLock.Release())
+	}()
+	require.NoError(t, targetLock.RunFenced(ctx, func(q *queries.Queries) error {
+		_, err := q.InsertGatewayEnvelope(ctx, queries.InsertGatewayEnvelopeParams{
+			OriginatorID:         9,
+			OriginatorSequenceID: 1,
+			Topic:                []byte("other"),
+			OriginatorEnvelope:   []byte("other"),
+		})
+		return err
+	}))
+	_, err = ImportEnvelopes(ctx, targetLock, bytes.NewReader(buf.Bytes()))
+	require.ErrorContains(t, err, "conflicts with a different envelope")
+}
+
+func TestImportTruncated(t *testing.T) {
+	ctx := context.Background()
+	source, _, cleanupSource := test.NewDB(t, ctx)
+	defer cleanupSource()
+	lock, err := AcquireWriterLock(ctx, source)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, lock.Release())
+	}()
+	insertExportRows(t, ctx, lock, 3)
+
+	var buf bytes.Buffer
+	_, err = ExportEnvelopes(ctx, source, &buf, 0)
+	require.NoError(t, err)
+
+	data := buf.Bytes()
+	_, err = ImportEnvelopes(ctx, lock, bytes.NewReader(data[:len(data)-4]))
+	require.ErrorContains(t, err, "trailer")
+}
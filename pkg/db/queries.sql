@@ -16,6 +16,13 @@ WHERE
 SELECT
 	insert_gateway_envelope(@originator_id, @originator_sequence_id, @topic, @originator_envelope);
 
+-- name: InsertGatewayEnvelopeWithID :execrows
+-- Stores an imported envelope under the gateway sequence ID it had on the exporting node
+INSERT INTO gateway_envelopes(id, originator_node_id, originator_sequence_id, topic, originator_envelope)
+	VALUES (@id, @originator_node_id, @originator_sequence_id, @topic, @originator_envelope)
+ON CONFLICT
+	DO NOTHING;
+
 -- name: SelectGatewayEnvelopes :many
 SELECT
 	*
@@ -23,14 +30,48 @@ FROM
 	gateway_envelopes
 WHERE (sqlc.narg('topic')::BYTEA IS NULL
 	OR topic = @topic)
+AND (sqlc.narg('topic_from')::BYTEA IS NULL
+	OR topic >= @topic_from)
+AND (sqlc.narg('topic_to')::BYTEA IS NULL
+	OR topic < @topic_to)
 AND (sqlc.narg('originator_node_id')::INT IS NULL
 	OR originator_node_id = @originator_node_id)
 AND (sqlc.narg('originator_sequence_id')::BIGINT IS NULL
 	OR originator_sequence_id > @originator_sequence_id)
 AND (sqlc.narg('gateway_sequence_id')::BIGINT IS NULL
 	OR id > @gateway_sequence_id)
+ORDER BY
+	id ASC
 LIMIT sqlc.narg('row_limit')::INT;
 
+-- name: SelectLatestGatewayEnvelopeID :one
+SELECT
+	COALESCE(MAX(id), 0)::BIGINT AS latest_id
+FROM
+	gateway_envelopes;
+
+-- name: AdvanceGatewayEnvelopeSequence :exec
+-- Ensures envelopes stored after an import are not assigned gateway IDs that are already used
+SELECT
+	setval('gateway_envelopes_id_seq', GREATEST((
+			SELECT
+				COALESCE(MAX(id), 0)
+			FROM gateway_envelopes), (
+			SELECT
+				last_value
+			FROM gateway_envelopes_id_seq)));
+
+-- name: AdvanceStagedOriginatorEnvelopeSequence :exec
+-- Ensures envelopes staged after an import are not assigned sequence IDs that are already stored
+SELECT
+	setval('staged_originator_envelopes_id_seq', GREATEST((
+			SELECT
+				COALESCE(MAX(originator_sequence_id), 0)
+			FROM gateway_envelopes), (
+			SELECT
+				last_value
+			FROM staged_originator_envelopes_id_seq)));
+
 -- name: InsertStagedOriginatorEnvelope :one
 SELECT
 	*
@@ -52,3 +93,47 @@ LIMIT @num_rows;
 DELETE FROM staged_originator_envelopes
 WHERE id = @id;
 
+
+-- name: TryAcquireWriterLock :one
+SELECT
+	pg_try_advisory_lock(hashtext('writer_lock'));
+
+-- name: ReleaseWriterLock :one
+SELECT
+	pg_advisory_unlock(hashtext('writer_lock'));
+
+-- name: IncrementFencingToken :one
+UPDATE
+	writer_fencing
+SET
+	fencing_token = fencing_token + 1
+WHERE
+	singleton_id = 1
+RETURNING
+	fencing_token;
+
+-- name: SelectFencingTokenForShare :one
+SELECT
+	fencing_token
+FROM
+	writer_fencing
+WHERE
+	singleton_id = 1
+FOR SHARE;
+
+-- name: GetLatestBlock :one
+SELECT
+	block_number
+FROM
+	latest_block
+WHERE
+	contract_address = @contract_address;
+
+-- name: SetLatestBlock :exec
+INSERT INTO latest_block(contract_address, block_number)
+	VALUES (@contract_address, @block_number)
+ON CONFLICT (contract_address)
+	DO UPDATE SET
+		block_number = @block_number
+	WHERE
+		@block_number > latest_block.block_number;
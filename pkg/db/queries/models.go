@@ -24,6 +24,11 @@ type GatewayEnvelope struct {
 	OriginatorEnvelope   []byte
 }
 
+type LatestBlock struct {
+	ContractAddress string
+	BlockNumber     int64
+}
+
 type NodeInfo struct {
 	NodeID      int32
 	PublicKey   []byte
@@ -36,3 +41,8 @@ type StagedOriginatorEnvelope struct {
 	Topic          []byte
 	PayerEnvelope  []byte
 }
+
+type WriterFencing struct {
+	FencingToken int64
+	SingletonID  int16
+}
@@ -10,6 +10,40 @@ import (
 	"database/sql"
 )
 
+const advanceGatewayEnvelopeSequence = `-- name: AdvanceGatewayEnvelopeSequence :exec
+SELECT
+	setval('gateway_envelopes_id_seq', GREATEST((
+			SELECT
+				COALESCE(MAX(id), 0)
+			FROM gateway_envelopes), (
+			SELECT
+				last_value
+			FROM gateway_envelopes_id_seq)))
+`
+
+// Ensures envelopes stored after an import are not assigned gateway IDs that are already used
+func (q *Queries) AdvanceGatewayEnvelopeSequence(ctx context.Context) error {
+	_, err := q.db.ExecContext(ctx, advanceGatewayEnvelopeSequence)
+	return err
+}
+
+const advanceStagedOriginatorEnvelopeSequence = `-- name: AdvanceStagedOriginatorEnvelopeSequence :exec
+SELECT
+	setval('staged_originator_envelopes_id_seq', GREATEST((
+			SELECT
+				COALESCE(MAX(originator_sequence_id), 0)
+			FROM gateway_envelopes), (
+			SELECT
+				last_value
+			FROM staged_originator_envelopes_id_seq)))
+`
+
+// Ensures envelopes staged after an import are not assigned sequence IDs that are already stored
+func (q *Queries) AdvanceStagedOriginatorEnvelopeSequence(ctx context.Context) error {
+	_, err := q.db.ExecContext(ctx, advanceStagedOriginatorEnvelopeSequence)
+	return err
+}
+
 const deleteStagedOriginatorEnvelope = `-- name: DeleteStagedOriginatorEnvelope :execrows
 DELETE FROM staged_originator_envelopes
 WHERE id = $1
@@ -23,6 +57,40 @@ func (q *Queries) DeleteStagedOriginatorEnvelope(ctx context.Context, id int64)
 	return result.RowsAffected()
 }
 
+const getLatestBlock = `-- name: GetLatestBlock :one
+SELECT
+	block_number
+FROM
+	latest_block
+WHERE
+	contract_address = $1
+`
+
+func (q *Queries) GetLatestBlock(ctx context.Context, contractAddress string) (int64, error) {
+	row := q.db.QueryRowContext(ctx, getLatestBlock, contractAddress)
+	var block_number int64
+	err := row.Scan(&block_number)
+	return block_number, err
+}
+
+const incrementFencingToken = `-- name: IncrementFencingToken :one
+UPDATE
+	writer_fencing
+SET
+	fencing_token = fencing_token + 1
+WHERE
+	singleton_id = 1
+RETURNING
+	fencing_token
+`
+
+func (q *Queries) IncrementFencingToken(ctx context.Context) (int64, error) {
+	row := q.db.QueryRowContext(ctx, incrementFencingToken)
+	var fencing_token int64
+	err := row.Scan(&fencing_token)
+	return fencing_token, err
+}
+
 const insertGatewayEnvelope = `-- name: InsertGatewayEnvelope :execrows
 SELECT
 	insert_gateway_envelope($1, $2, $3, $4)
@@ -48,6 +116,36 @@ func (q *Queries) InsertGatewayEnvelope(ctx context.Context, arg InsertGatewayEn
 	return result.RowsAffected()
 }
 
+const insertGatewayEnvelopeWithID = `-- name: InsertGatewayEnvelopeWithID :execrows
+INSERT INTO gateway_envelopes(id, originator_node_id, originator_sequence_id, topic, originator_envelope)
+	VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT
+	DO NOTHING
+`
+
+type InsertGatewayEnvelopeWithIDParams struct {
+	ID                   int64
+	OriginatorNodeID     int32
+	OriginatorSequenceID int64
+	Topic                []byte
+	OriginatorEnvelope   []byte
+}
+
+// Stores an imported envelope under the gateway sequence ID it had on the exporting node
+func (q *Queries) InsertGatewayEnvelopeWithID(ctx context.Context, arg InsertGatewayEnvelopeWithIDParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, insertGatewayEnvelopeWithID,
+		arg.ID,
+		arg.OriginatorNodeID,
+		arg.OriginatorSequenceID,
+		arg.Topic,
+		arg.OriginatorEnvelope,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const insertNodeInfo = `-- name: InsertNodeInfo :execrows
 INSERT INTO node_info(node_id, public_key)
 	VALUES ($1, $2)
@@ -92,6 +190,35 @@ func (q *Queries) InsertStagedOriginatorEnvelope(ctx context.Context, arg Insert
 	return i, err
 }
 
+const releaseWriterLock = `-- name: ReleaseWriterLock :one
+SELECT
+	pg_advisory_unlock(hashtext('writer_lock'))
+`
+
+func (q *Queries) ReleaseWriterLock(ctx context.Context) (bool, error) {
+	row := q.db.QueryRowContext(ctx, releaseWriterLock)
+	var pg_advisory_unlock bool
+	err := row.Scan(&pg_advisory_unlock)
+	return pg_advisory_unlock, err
+}
+
+const selectFencingTokenForShare = `-- name: SelectFencingTokenForShare :one
+SELECT
+	fencing_token
+FROM
+	writer_fencing
+WHERE
+	singleton_id = 1
+FOR SHARE
+`
+
+func (q *Queries) SelectFencingTokenForShare(ctx context.Context) (int64, error) {
+	row := q.db.QueryRowContext(ctx, selectFencingTokenForShare)
+	var fencing_token int64
+	err := row.Scan(&fencing_token)
+	return fencing_token, err
+}
+
 const selectGatewayEnvelopes = `-- name: SelectGatewayEnvelopes :many
 SELECT
 	id, originator_node_id, originator_sequence_id, topic, originator_envelope
@@ -99,17 +226,25 @@ FROM
 	gateway_envelopes
 WHERE ($1::BYTEA IS NULL
 	OR topic = $1)
-AND ($2::INT IS NULL
-	OR originator_node_id = $2)
-AND ($3::BIGINT IS NULL
-	OR originator_sequence_id > $3)
-AND ($4::BIGINT IS NULL
-	OR id > $4)
-LIMIT $5::INT
+AND ($2::BYTEA IS NULL
+	OR topic >= $2)
+AND ($3::BYTEA IS NULL
+	OR topic < $3)
+AND ($4::INT IS NULL
+	OR originator_node_id = $4)
+AND ($5::BIGINT IS NULL
+	OR originator_sequence_id > $5)
+AND ($6::BIGINT IS NULL
+	OR id > $6)
+ORDER BY
+	id ASC
+LIMIT $7::INT
 `
 
 type SelectGatewayEnvelopesParams struct {
 	Topic                []byte
+	TopicFrom            []byte
+	TopicTo              []byte
 	OriginatorNodeID     sql.NullInt32
 	OriginatorSequenceID sql.NullInt64
 	GatewaySequenceID    sql.NullInt64
@@ -119,6 +254,8 @@ type SelectGatewayEnvelopesParams struct {
 func (q *Queries) SelectGatewayEnvelopes(ctx context.Context, arg SelectGatewayEnvelopesParams) ([]GatewayEnvelope, error) {
 	rows, err := q.db.QueryContext(ctx, selectGatewayEnvelopes,
 		arg.Topic,
+		arg.TopicFrom,
+		arg.TopicTo,
 		arg.OriginatorNodeID,
 		arg.OriginatorSequenceID,
 		arg.GatewaySequenceID,
@@ -151,6 +288,20 @@ func (q *Queries) SelectGatewayEnvelopes(ctx context.Context, arg SelectGatewayE
 	return items, nil
 }
 
+const selectLatestGatewayEnvelopeID = `-- name: SelectLatestGatewayEnvelopeID :one
+SELECT
+	COALESCE(MAX(id), 0)::BIGINT AS latest_id
+FROM
+	gateway_envelopes
+`
+
+func (q *Queries) SelectLatestGatewayEnvelopeID(ctx context.Context) (int64, error) {
+	row := q.db.QueryRowContext(ctx, selectLatestGatewayEnvelopeID)
+	var latest_id int64
+	err := row.Scan(&latest_id)
+	return latest_id, err
+}
+
 const selectNodeInfo = `-- name: SelectNodeInfo :one
 SELECT
 	node_id, public_key, singleton_id
@@ -211,3 +362,35 @@ func (q *Queries) SelectStagedOriginatorEnvelopes(ctx context.Context, arg Selec
 	}
 	return items, nil
 }
+
+const setLatestBlock = `-- name: SetLatestBlock :exec
+INSERT INTO latest_block(contract_address, block_number)
+	VALUES ($1, $2)
+ON CONFLICT (contract_address)
+	DO UPDATE SET
+		block_number = $2
+	WHERE
+		$2 > latest_block.block_number
+`
+
+type SetLatestBlockParams struct {
+	ContractAddress string
+	BlockNumber     int64
+}
+
+func (q *Queries) SetLatestBlock(ctx context.Context, arg SetLatestBlockParams) error {
+	_, err := q.db.ExecContext(ctx, setLatestBlock, arg.ContractAddress, arg.BlockNumber)
+	return err
+}
+
+const tryAcquireWriterLock = `-- name: TryAcquireWriterLock :one
+SELECT
+	pg_try_advisory_lock(hashtext('writer_lock'))
+`
+
+func (q *Queries) TryAcquireWriterLock(ctx context.Context) (bool, error) {
+	row := q.db.QueryRowContext(ctx, tryAcquireWriterLock)
+	var pg_try_advisory_lock bool
+	err := row.Scan(&pg_try_advisory_lock)
+	return pg_try_advisory_lock, err
+}
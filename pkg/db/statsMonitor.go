@@ -0,0 +1,334 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/xmtp/xmtpd/pkg/metrics"
+	"go.uber.org/zap"
+)
+
+const (
+	// Maximum number of slow statements reported per interval
+	slowQueryReportLimit = 10
+	// SQLSTATE returned when pg_stat_statements is installed but not preloaded
+	pgObjectNotInPrerequisiteState = "55000"
+)
+
+type StatsOptions struct {
+	// How often to ANALYZE the database. Zero disables
+	AnalyzeInterval time.Duration
+	// How often to log table and query statistics. Zero disables
+	ReportInterval time.Duration
+	// Statements with a mean execution time above this are reported
+	SlowQueryThreshold time.Duration
+	// Whether to log the generic plan of each slow statement
+	ExplainSlowQueries bool
+}
+
+type TableStats struct {
+	Table        string    `json:"table"`
+	LiveRows     int64     `json:"liveRows"`
+	DeadRows     int64     `json:"deadRows"`
+	TotalBytes   int64     `json:"totalBytes"`
+	LastAnalyzed time.Time `json:"lastAnalyzed"`
+}
+
+// Returns row counts and on-disk sizes of every user table, as estimated by Postgres
+func GetTableStats(ctx context.Context, db *sql.DB) ([]TableStats, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT
+			relname,
+			n_live_tup,
+			n_dead_tup,
+			pg_total_relation_size(relid),
+			greatest(last_analyze, last_autoanalyze)
+		FROM
+			pg_stat_user_tables
+		ORDER BY
+			relname`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []TableStats
+	for rows.Next() {
+		var (
+			stat         TableStats
+			lastAnalyzed sql.NullTime
+		)
+		err := rows.Scan(
+			&stat.Table,
+			&stat.LiveRows,
+			&stat.DeadRows,
+			&stat.TotalBytes,
+			&lastAnalyzed,
+		)
+		if err != nil {
+			return nil, err
+		}
+		stat.LastAnalyzed = lastAnalyzed.Time
+		stats = append(stats, stat)
+	}
+	return stats, rows.Err()
+}
+
+/*
+StatsMonitor keeps planner statistics fresh and reports table and query statistics in the
+node logs, so that operators can tune the database without direct access to it.
+
+Slow query reporting relies on the pg_stat_statements extension, which the node does not
+install itself, since creating it requires elevated privileges. Postgres must be started with
+shared_preload_libraries=pg_stat_statements, and an administrator must run CREATE EXTENSION
+pg_stat_statements in the node's database. Until then, slow queries are skipped with a
+warning. Only statements run by the node's own database user are reported and explained.
+*/
+type StatsMonitor struct {
+	ctx     context.Context
+	db      *sql.DB
+	log     *zap.Logger
+	options StatsOptions
+	// Whether the reason slow queries can't be reported has been logged
+	warnedSlowQueriesUnavailable bool
+}
+
+func StartStatsMonitor(
+	ctx context.Context,
+	log *zap.Logger,
+	db *sql.DB,
+	options StatsOptions,
+) *StatsMonitor {
+	m := &StatsMonitor{
+		ctx:     ctx,
+		db:      db,
+		log:     log.Named("statsMonitor"),
+		options: options,
+	}
+	if options.AnalyzeInterval > 0 {
+		go m.loop(options.AnalyzeInterval, m.analyze)
+	}
+	if options.ReportInterval > 0 {
+		go m.loop(options.ReportInterval, m.report)
+	}
+	return m
+}
+
+func (m *StatsMonitor) loop(interval time.Duration, task func() error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := task(); err != nil {
+				m.log.Error("Error running database statistics task", zap.Error(err))
+			}
+		}
+	}
+}
+
+func (m *StatsMonitor) analyze() error {
+	start := time.Now()
+	if _, err := m.db.ExecContext(m.ctx, "ANALYZE"); err != nil {
+		return err
+	}
+	m.log.Info("Analyzed database", zap.Duration("duration", time.Since(start)))
+	return nil
+}
+
+func (m *StatsMonitor) report() error {
+	if err := m.reportTables(); err != nil {
+		return err
+	}
+	return m.reportSlowQueries()
+}
+
+func (m *StatsMonitor) reportTables() error {
+	stats, err := GetTableStats(m.ctx, m.db)
+	if err != nil {
+		return err
+	}
+	for _, stat := range stats {
+		metrics.EmitTableStats(stat.Table, stat.LiveRows, stat.TotalBytes)
+		m.log.Info(
+			"Table statistics",
+			zap.String("table", stat.Table),
+			zap.Int64("liveRows", stat.LiveRows),
+			zap.Int64("deadRows", stat.DeadRows),
+			zap.Int64("totalBytes", stat.TotalBytes),
+			zap.Time("lastAnalyzed", stat.LastAnalyzed),
+		)
+	}
+	return nil
+}
+
+func (m *StatsMonitor) reportSlowQueries() error {
+	var installed bool
+	err := m.db.QueryRowContext(
+		m.ctx,
+		"SELECT EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'pg_stat_statements')",
+	).Scan(&installed)
+	if err != nil {
+		return err
+	}
+	if !installed {
+		m.warnSlowQueriesUnavailable("pg_stat_statements is not installed in the database")
+		return nil
+	}
+
+	// Only the node's own statements are reported, as other roles may share the server
+	rows, err := m.db.QueryContext(m.ctx, `
+		SELECT
+			query,
+			calls,
+			mean_exec_time,
+			count(*) OVER ()
+		FROM
+			pg_stat_statements
+		WHERE
+			dbid = (SELECT oid FROM pg_database WHERE datname = current_database())
+			AND userid = current_user::regrole::oid
+			AND mean_exec_time > $1
+		ORDER BY
+			mean_exec_time DESC
+		LIMIT $2`,
+		float64(m.options.SlowQueryThreshold.Microseconds())/1000,
+		slowQueryReportLimit,
+	)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgObjectNotInPrerequisiteState {
+		// The extension is installed, but not loaded through shared_preload_libraries
+		m.warnSlowQueriesUnavailable(pgErr.Message)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	type slowQuery struct {
+		query      string
+		calls      int64
+		meanExecMs float64
+	}
+	var (
+		slowQueries []slowQuery
+		total       int64
+	)
+	for rows.Next() {
+		var q slowQuery
+		if err := rows.Scan(&q.query, &q.calls, &q.meanExecMs, &total); err != nil {
+			rows.Close()
+			return err
+		}
+		slowQueries = append(slowQueries, q)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	slowestMeanExec := time.Duration(0)
+	if len(slowQueries) > 0 {
+		slowestMeanExec = time.Duration(slowQueries[0].meanExecMs * float64(time.Millisecond))
+	}
+	metrics.EmitSlowQueries(int(total), slowestMeanExec)
+
+	for _, q := range slowQueries {
+		fields := []zap.Field{
+			zap.String("query", q.query),
+			zap.Int64("calls", q.calls),
+			zap.Float64("meanExecMs", q.meanExecMs),
+		}
+		if m.options.ExplainSlowQueries && isExplainable(q.query) {
+			fields = append(fields, zap.String("plan", m.explain(q.query)))
+		}
+		m.log.Warn("Slow query", fields...)
+	}
+	return nil
+}
+
+// Logs why slow queries can't be reported, once rather than on every report
+func (m *StatsMonitor) warnSlowQueriesUnavailable(reason string) {
+	if m.warnedSlowQueriesUnavailable {
+		return
+	}
+	m.warnedSlowQueriesUnavailable = true
+	m.log.Warn(
+		"Slow queries are not reported. Start Postgres with "+
+			"shared_preload_libraries=pg_stat_statements and run "+
+			"CREATE EXTENSION pg_stat_statements to enable them",
+		zap.String("reason", reason),
+	)
+}
+
+/*
+*
+Whether a statement is a single SELECT, INSERT, UPDATE or DELETE. Only these are explained,
+so that DDL and utility statements recorded by pg_stat_statements are never re-run, even as
+part of an EXPLAIN. Leading comments are skipped, since sqlc prefixes every query it sends
+with a "-- name:" comment.
+*/
+func isExplainable(query string) bool {
+	query = strings.TrimSuffix(strings.TrimSpace(stripLeadingComments(query)), ";")
+	if strings.Contains(query, ";") {
+		return false
+	}
+	words := strings.Fields(query)
+	if len(words) == 0 {
+		return false
+	}
+	switch strings.ToUpper(words[0]) {
+	case "SELECT", "INSERT", "UPDATE", "DELETE":
+		return true
+	}
+	return false
+}
+
+// Removes the line and block comments before the first keyword of a statement
+func stripLeadingComments(query string) string {
+	for {
+		query = strings.TrimSpace(query)
+		switch {
+		case strings.HasPrefix(query, "--"):
+			_, rest, found := strings.Cut(query, "\n")
+			if !found {
+				return ""
+			}
+			query = rest
+		case strings.HasPrefix(query, "/*"):
+			_, rest, found := strings.Cut(query, "*/")
+			if !found {
+				return ""
+			}
+			query = rest
+		default:
+			return query
+		}
+	}
+}
+
+// Returns the generic plan of a normalized statement, which may contain $n placeholders.
+// Requires Postgres 16 or later.
+func (m *StatsMonitor) explain(query string) string {
+	rows, err := m.db.QueryContext(m.ctx, "EXPLAIN (GENERIC_PLAN) "+query)
+	if err != nil {
+		m.log.Debug("Unable to explain query", zap.String("query", query), zap.Error(err))
+		return ""
+	}
+	defer rows.Close()
+
+	var lines []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return ""
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,79 @@
+package db
+
+import (
+	"context"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	test "github.com/xmtp/xmtpd/pkg/testing"
+)
+
+func TestStatsMonitorTasks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db, _, cleanup := test.NewDB(t, ctx)
+	defer cleanup()
+
+	monitor := StartStatsMonitor(ctx, test.NewLog(t), db, StatsOptions{
+		SlowQueryThreshold: time.Millisecond,
+		ExplainSlowQueries: true,
+	})
+
+	require.NoError(t, monitor.analyze())
+	require.NoError(t, monitor.report())
+}
+
+func TestGetTableStats(t *testing.T) {
+	ctx := context.Background()
+	db, _, cleanup := test.NewDB(t, ctx)
+	defer cleanup()
+
+	stats, err := GetTableStats(ctx, db)
+	require.NoError(t, err)
+
+	tables := make([]string, 0, len(stats))
+	for _, stat := range stats {
+		tables = append(tables, stat.Table)
+	}
+	require.Contains(t, tables, "gateway_envelopes")
+}
+
+func TestIsExplainable(t *testing.T) {
+	require.True(t, isExplainable("SELECT * FROM gateway_envelopes WHERE topic = $1"))
+	require.True(t, isExplainable("  delete from staged_originator_envelopes where id = $1;"))
+	require.True(t, isExplainable("INSERT INTO node_info (node_id) VALUES ($1)"))
+	require.False(t, isExplainable("DROP TABLE gateway_envelopes"))
+	require.False(t, isExplainable("ANALYZE"))
+	require.False(t, isExplainable("SELECT 1; DROP TABLE gateway_envelopes"))
+	require.True(t, isExplainable("/* comment */\n-- name: X :one\nSELECT\n\tid\nFROM t"))
+	require.False(t, isExplainable("-- SELECT\nDROP TABLE gateway_envelopes"))
+	require.False(t, isExplainable("-- comment only"))
+}
+
+// The node's queries are sent as sqlc generates them, with a leading comment and newlines
+func TestGeneratedQueriesAreExplainable(t *testing.T) {
+	file, err := parser.ParseFile(token.NewFileSet(), "queries/queries.sql.go", nil, 0)
+	require.NoError(t, err)
+
+	count := 0
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.CONST {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			for _, value := range spec.(*ast.ValueSpec).Values {
+				query, err := strconv.Unquote(value.(*ast.BasicLit).Value)
+				require.NoError(t, err)
+				require.True(t, isExplainable(query), query)
+				count++
+			}
+		}
+	}
+	require.Positive(t, count)
+}
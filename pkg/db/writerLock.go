@@ -0,0 +1,109 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/xmtp/xmtpd/pkg/db/queries"
+)
+
+var ErrStaleFencingToken = errors.New(
+	"fencing token is stale; another process has acquired the writer lock",
+)
+
+/*
+WriterLock guarantees that only a single node process writes to a database at a time,
+guarding against accidental double-deploys that share one database.
+
+The lock is a session-level advisory lock held on a dedicated connection for the lifetime
+of the process. On acquisition the fencing token is incremented, and fenced writes verify
+the token in the same transaction, so a zombie process that lost its lock (for example when
+its connection dropped) cannot keep writing after another process has taken over.
+*/
+type WriterLock struct {
+	conn         *sql.Conn
+	db           *sql.DB
+	fencingToken int64
+	lost         atomic.Bool
+}
+
+func AcquireWriterLock(ctx context.Context, db *sql.DB) (*WriterLock, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	q := queries.New(conn)
+
+	acquired, err := q.TryAcquireWriterLock(ctx)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("unable to acquire writer lock: %v", err)
+	}
+	if !acquired {
+		conn.Close()
+		return nil, fmt.Errorf("writer lock is held by another node process")
+	}
+
+	fencingToken, err := q.IncrementFencingToken(ctx)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("unable to increment fencing token: %v", err)
+	}
+
+	return &WriterLock{
+		conn:         conn,
+		db:           db,
+		fencingToken: fencingToken,
+	}, nil
+}
+
+func (l *WriterLock) FencingToken() int64 {
+	return l.fencingToken
+}
+
+// Whether a fenced write found that another process has taken over the lock.
+// The lock cannot be regained, so the process should be restarted
+func (l *WriterLock) Lost() bool {
+	return l.lost.Load()
+}
+
+// Runs fn in a transaction that only commits if this process still holds the latest
+// fencing token. Returns ErrStaleFencingToken otherwise.
+func (l *WriterLock) RunFenced(
+	ctx context.Context,
+	fn func(q *queries.Queries) error,
+) error {
+	tx, err := l.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	q := queries.New(l.db).WithTx(tx)
+	// Holds a share lock on the token until the end of the transaction, so a new writer
+	// cannot take over while this write is in flight
+	current, err := q.SelectFencingTokenForShare(ctx)
+	if err != nil {
+		return err
+	}
+	if current != l.fencingToken {
+		l.lost.Store(true)
+		return ErrStaleFencingToken
+	}
+
+	if err = fn(q); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (l *WriterLock) Release() error {
+	defer l.conn.Close()
+	_, err := queries.New(l.conn).ReleaseWriterLock(context.Background())
+	return err
+}
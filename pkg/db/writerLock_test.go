@@ -0,0 +1,64 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xmtp/xmtpd/pkg/db/queries"
+	test "github.com/xmtp/xmtpd/pkg/testing"
+)
+
+func TestWriterLockExclusive(t *testing.T) {
+	ctx := context.Background()
+	db, _, cleanup := test.NewDB(t, ctx)
+	defer cleanup()
+
+	lock, err := AcquireWriterLock(ctx, db)
+	require.NoError(t, err)
+
+	_, err = AcquireWriterLock(ctx, db)
+	require.ErrorContains(t, err, "held by another")
+
+	require.NoError(t, lock.Release())
+	nextLock, err := AcquireWriterLock(ctx, db)
+	require.NoError(t, err)
+	require.Greater(t, nextLock.FencingToken(), lock.FencingToken())
+	require.NoError(t, nextLock.Release())
+}
+
+func TestWriterLockStaleFencingToken(t *testing.T) {
+	ctx := context.Background()
+	db, _, cleanup := test.NewDB(t, ctx)
+	defer cleanup()
+
+	// Simulate a zombie process whose connection (and therefore lock) was dropped
+	zombieLock, err := AcquireWriterLock(ctx, db)
+	require.NoError(t, err)
+	require.NoError(t, zombieLock.Release())
+
+	lock, err := AcquireWriterLock(ctx, db)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, lock.Release())
+	}()
+
+	insert := func(q *queries.Queries) error {
+		_, err := q.InsertGatewayEnvelope(ctx, queries.InsertGatewayEnvelopeParams{
+			OriginatorID:         1,
+			OriginatorSequenceID: 1,
+			Topic:                []byte("topicA"),
+			OriginatorEnvelope:   []byte("envelope1"),
+		})
+		return err
+	}
+	require.ErrorIs(t, zombieLock.RunFenced(ctx, insert), ErrStaleFencingToken)
+	require.True(t, zombieLock.Lost())
+	require.NoError(t, lock.RunFenced(ctx, insert))
+	require.False(t, lock.Lost())
+
+	envs, err := queries.New(db).
+		SelectGatewayEnvelopes(ctx, queries.SelectGatewayEnvelopesParams{})
+	require.NoError(t, err)
+	require.Len(t, envs, 1)
+}
@@ -0,0 +1,103 @@
+package debug
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// Number of recent errors kept for the summary
+const recentErrorsSize = 50
+
+// An error logged by the node
+type RecentError struct {
+	Time    time.Time `json:"time"`
+	Logger  string    `json:"logger"`
+	Message string    `json:"message"`
+	Error   string    `json:"error,omitempty"`
+}
+
+/*
+*
+ErrorRecorder is a zapcore.Core that keeps the most recent error level log entries, so that
+the summary can show them without access to the node's logs. Add it to a logger with
+zap.WrapCore and zapcore.NewTee.
+*/
+type ErrorRecorder struct {
+	// Fields added to the logger with With
+	fields []zapcore.Field
+	errors *recentErrors
+}
+
+type recentErrors struct {
+	mutex   sync.Mutex
+	entries []RecentError
+	// Index of the oldest entry, once entries is full
+	next int
+}
+
+func NewErrorRecorder() *ErrorRecorder {
+	return &ErrorRecorder{errors: &recentErrors{}}
+}
+
+// Returns the recorded errors, oldest first
+func (r *ErrorRecorder) RecentErrors() []RecentError {
+	r.errors.mutex.Lock()
+	defer r.errors.mutex.Unlock()
+
+	out := make([]RecentError, 0, len(r.errors.entries))
+	out = append(out, r.errors.entries[r.errors.next:]...)
+	return append(out, r.errors.entries[:r.errors.next]...)
+}
+
+func (r *ErrorRecorder) Enabled(level zapcore.Level) bool {
+	return level >= zapcore.ErrorLevel
+}
+
+func (r *ErrorRecorder) With(fields []zapcore.Field) zapcore.Core {
+	return &ErrorRecorder{
+		fields: append(r.fields[:len(r.fields):len(r.fields)], fields...),
+		errors: r.errors,
+	}
+}
+
+func (r *ErrorRecorder) Check(
+	entry zapcore.Entry,
+	checked *zapcore.CheckedEntry,
+) *zapcore.CheckedEntry {
+	if r.Enabled(entry.Level) {
+		return checked.AddCore(entry, r)
+	}
+	return checked
+}
+
+func (r *ErrorRecorder) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	recentError := RecentError{
+		Time:    entry.Time,
+		Logger:  entry.LoggerName,
+		Message: entry.Message,
+	}
+	encoder := zapcore.NewMapObjectEncoder()
+	for _, field := range append(r.fields[:len(r.fields):len(r.fields)], fields...) {
+		field.AddTo(encoder)
+	}
+	if err, ok := encoder.Fields["error"]; ok {
+		recentError.Error = fmt.Sprint(err)
+	}
+
+	r.errors.mutex.Lock()
+	defer r.errors.mutex.Unlock()
+	if len(r.errors.entries) < recentErrorsSize {
+		r.errors.entries = append(r.errors.entries, recentError)
+		return nil
+	}
+	r.errors.entries[r.errors.next] = recentError
+	r.errors.next = (r.errors.next + 1) % recentErrorsSize
+	return nil
+}
+
+func (r *ErrorRecorder) Sync() error {
+	return nil
+}
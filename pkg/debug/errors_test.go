@@ -0,0 +1,34 @@
+package debug
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestErrorRecorder(t *testing.T) {
+	recorder := NewErrorRecorder()
+	log := zap.New(recorder).Named("api")
+
+	log.Info("serving grpc")
+	log.Warn("slow query")
+	log.With(zap.Int("attempt", 1)).Error("publish failed", zap.Error(errors.New("db down")))
+	require.Equal(t, []RecentError{{
+		Time:    recorder.RecentErrors()[0].Time,
+		Logger:  "api",
+		Message: "publish failed",
+		Error:   "db down",
+	}}, recorder.RecentErrors())
+
+	// Only the most recent errors are kept, oldest first
+	for i := 0; i < recentErrorsSize+5; i++ {
+		log.Error(fmt.Sprintf("error %d", i))
+	}
+	recent := recorder.RecentErrors()
+	require.Len(t, recent, recentErrorsSize)
+	require.Equal(t, "error 5", recent[0].Message)
+	require.Equal(t, fmt.Sprintf("error %d", recentErrorsSize+4), recent[len(recent)-1].Message)
+}
@@ -0,0 +1,111 @@
+// Package debug serves operator-facing diagnostics and controls over HTTP. It is disabled
+// unless a debug port is configured, and only listens on loopback unless another address
+// is configured. It should not be exposed publicly.
+package debug
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/xmtp/xmtpd/pkg/config"
+	"go.uber.org/zap"
+)
+
+type Server struct {
+	log        *zap.Logger
+	listener   net.Listener
+	httpServer *http.Server
+}
+
+func NewServer(
+	ctx context.Context,
+	log *zap.Logger,
+	options config.DebugOptions,
+	sources Sources,
+	reload func() (config.ReloadReport, error),
+) (*Server, error) {
+	listener, err := net.Listen(
+		"tcp",
+		net.JoinHostPort(options.Address, strconv.Itoa(options.Port)),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		log:      log.Named("debug"),
+		listener: listener,
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/debug/summary", summaryHandler(s.log, sources, time.Now()))
+	if reload != nil {
+		mux.Handle("/admin/reload", reloadHandler(s.log, reload))
+	}
+	s.httpServer = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext: func(net.Listener) context.Context {
+			return ctx
+		},
+	}
+
+	go func() {
+		s.log.Info("serving debug http", zap.String("address", listener.Addr().String()))
+		err := s.httpServer.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.log.Error("serving debug http", zap.Error(err))
+		}
+	}()
+
+	return s, nil
+}
+
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+func (s *Server) Close() {
+	if err := s.httpServer.Close(); err != nil {
+		s.log.Error("closing debug http server", zap.Error(err))
+	}
+}
+
+func summaryHandler(log *zap.Logger, sources Sources, startedAt time.Time) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		summary := collectSummary(r.Context(), sources, startedAt)
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(summary); err != nil {
+			log.Error("writing debug summary", zap.Error(err))
+		}
+	})
+}
+
+// Re-reads the node's options on POST, and responds with the options that changed
+func reloadHandler(log *zap.Logger, reload func() (config.ReloadReport, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		report, err := reload()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			log.Error("writing reload report", zap.Error(err))
+		}
+	})
+}
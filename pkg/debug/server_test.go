@@ -0,0 +1,108 @@
+package debug
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xmtp/xmtpd/pkg/config"
+	"github.com/xmtp/xmtpd/pkg/ratelimit"
+	"github.com/xmtp/xmtpd/pkg/registry"
+	"github.com/xmtp/xmtpd/pkg/spam"
+	test "github.com/xmtp/xmtpd/pkg/testing"
+	"go.uber.org/zap"
+)
+
+func TestSummaryHandler(t *testing.T) {
+	ctx := context.Background()
+	log := test.NewLog(t)
+	db, _, cleanup := test.NewDB(t, ctx)
+	defer cleanup()
+	spamFilter, err := spam.NewFilter(log, config.SpamOptions{})
+	require.NoError(t, err)
+	rateLimiter, err := ratelimit.NewLimiter(config.RateLimitOptions{})
+	require.NoError(t, err)
+	errorRecorder := NewErrorRecorder()
+	zap.New(errorRecorder).Error("refresh failed")
+
+	handler := summaryHandler(log, Sources{
+		NodeID: 100,
+		Registry: registry.NewFixedNodeRegistry([]registry.Node{
+			{NodeID: 100, HttpAddress: "http://localhost:5050", IsHealthy: true},
+		}),
+		DB:            db,
+		SpamFilter:    spamFilter,
+		RateLimiter:   rateLimiter,
+		ErrorRecorder: errorRecorder,
+	}, time.Now().Add(-time.Minute))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/debug/summary", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	var summary Summary
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &summary))
+	require.Equal(t, uint16(100), summary.NodeID)
+	require.Equal(t, "1m0s", summary.Uptime)
+	require.Len(t, summary.Nodes, 1)
+	require.Equal(t, "http://localhost:5050", summary.Nodes[0].HttpAddress)
+	require.NotEmpty(t, summary.Tables)
+	require.Len(t, summary.RecentErrors, 1)
+	require.Equal(t, "refresh failed", summary.RecentErrors[0].Message)
+	require.Empty(t, summary.Errors)
+}
+
+func TestServerListensOnLoopback(t *testing.T) {
+	server, err := NewServer(
+		context.Background(),
+		test.NewLog(t),
+		config.DebugOptions{Address: "127.0.0.1"},
+		Sources{},
+		nil,
+	)
+	require.NoError(t, err)
+	defer server.Close()
+	require.True(t, server.Addr().(*net.TCPAddr).IP.IsLoopback())
+}
+
+func TestSummaryHandlerRejectsPost(t *testing.T) {
+	handler := summaryHandler(test.NewLog(t), Sources{}, time.Now())
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/debug/summary", nil))
+	require.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+}
+
+func TestReloadHandler(t *testing.T) {
+	var err error
+	handler := reloadHandler(test.NewLog(t), func() (config.ReloadReport, error) {
+		return config.ReloadReport{
+			Applied:         []string{"log-level"},
+			RequiresRestart: []string{"api.port"},
+		}, err
+	})
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/admin/reload", nil))
+	require.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/admin/reload", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+	var report config.ReloadReport
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &report))
+	require.Equal(t, []string{"log-level"}, report.Applied)
+	require.Equal(t, []string{"api.port"}, report.RequiresRestart)
+
+	err = fmt.Errorf("invalid log level")
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/admin/reload", nil))
+	require.Equal(t, http.StatusBadRequest, recorder.Code)
+	require.Contains(t, recorder.Body.String(), "invalid log level")
+}
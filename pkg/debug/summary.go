@@ -0,0 +1,89 @@
+package debug
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/xmtp/xmtpd/pkg/db"
+	"github.com/xmtp/xmtpd/pkg/ratelimit"
+	"github.com/xmtp/xmtpd/pkg/registry"
+	"github.com/xmtp/xmtpd/pkg/spam"
+)
+
+type NodeSummary struct {
+	NodeID        uint16 `json:"nodeId"`
+	HttpAddress   string `json:"httpAddress"`
+	IsHealthy     bool   `json:"isHealthy"`
+	IsValidConfig bool   `json:"isValidConfig"`
+}
+
+// A consolidated view of the node's state, for dashboards and operator scripts
+type Summary struct {
+	NodeID    uint16    `json:"nodeId"`
+	ReadOnly  bool      `json:"readOnly"`
+	StartedAt time.Time `json:"startedAt"`
+	Uptime    string    `json:"uptime"`
+	// The nodes in the registry, as seen by this node
+	Nodes []NodeSummary `json:"nodes"`
+	// Sizes of the tables in the node's database
+	Tables []db.TableStats `json:"tables"`
+	// Number of envelopes that triggered each spam heuristic since startup
+	SpamHits map[string]uint64 `json:"spamHits"`
+	// Number of rate limited requests of each kind since startup
+	Throttled map[string]uint64 `json:"throttled"`
+	// The most recent errors the node logged, oldest first
+	RecentErrors []RecentError `json:"recentErrors"`
+	// Sections of the summary that could not be collected
+	Errors []string `json:"errors,omitempty"`
+}
+
+// The components the summary is collected from
+type Sources struct {
+	NodeID      uint16
+	ReadOnly    bool
+	Registry    registry.NodeRegistry
+	DB          *sql.DB
+	SpamFilter  *spam.Filter
+	RateLimiter *ratelimit.Limiter
+	// Optional. Without it, the summary has no recent errors
+	ErrorRecorder *ErrorRecorder
+}
+
+func collectSummary(ctx context.Context, sources Sources, startedAt time.Time) Summary {
+	summary := Summary{
+		NodeID:       sources.NodeID,
+		ReadOnly:     sources.ReadOnly,
+		StartedAt:    startedAt,
+		Uptime:       time.Since(startedAt).Round(time.Second).String(),
+		Nodes:        []NodeSummary{},
+		Tables:       []db.TableStats{},
+		SpamHits:     sources.SpamFilter.Hits(),
+		Throttled:    sources.RateLimiter.Throttled(),
+		RecentErrors: []RecentError{},
+	}
+	if sources.ErrorRecorder != nil {
+		summary.RecentErrors = sources.ErrorRecorder.RecentErrors()
+	}
+
+	nodes, err := sources.Registry.GetNodes()
+	if err != nil {
+		summary.Errors = append(summary.Errors, "nodes: "+err.Error())
+	}
+	for _, node := range nodes {
+		summary.Nodes = append(summary.Nodes, NodeSummary{
+			NodeID:        node.NodeID,
+			HttpAddress:   node.HttpAddress,
+			IsHealthy:     node.IsHealthy,
+			IsValidConfig: node.IsValidConfig,
+		})
+	}
+
+	tables, err := db.GetTableStats(ctx, sources.DB)
+	if err != nil {
+		summary.Errors = append(summary.Errors, "tables: "+err.Error())
+	}
+	summary.Tables = append(summary.Tables, tables...)
+
+	return summary
+}
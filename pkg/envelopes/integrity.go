@@ -0,0 +1,48 @@
+package envelopes
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/xmtp/xmtpd/pkg/proto/xmtpv4/message_api"
+	"github.com/xmtp/xmtpd/pkg/utils"
+	"google.golang.org/protobuf/proto"
+)
+
+// Verifies that an originator envelope was signed by signingKey, and that it carries the
+// originator SID it is stored under
+func VerifyOriginatorEnvelope(
+	env *message_api.OriginatorEnvelope,
+	originatorSid uint64,
+	signingKey *ecdsa.PublicKey,
+) error {
+	sig := env.GetOriginatorSignature().GetBytes()
+	if sig == nil {
+		return fmt.Errorf("missing originator signature")
+	}
+	signer, err := crypto.SigToPub(crypto.Keccak256(env.GetUnsignedOriginatorEnvelope()), sig)
+	if err != nil {
+		return fmt.Errorf("invalid originator signature: %v", err)
+	}
+	if !signer.Equal(signingKey) {
+		return fmt.Errorf(
+			"originator signature does not match node %d",
+			utils.NodeID(originatorSid),
+		)
+	}
+
+	unsignedEnv := &message_api.UnsignedOriginatorEnvelope{}
+	if err := proto.Unmarshal(env.GetUnsignedOriginatorEnvelope(), unsignedEnv); err != nil {
+		return fmt.Errorf("could not unmarshal unsigned originator envelope: %v", err)
+	}
+	if unsignedEnv.GetOriginatorSid() != originatorSid {
+		return fmt.Errorf(
+			"envelope has originator sid %d, stored as %d",
+			unsignedEnv.GetOriginatorSid(),
+			originatorSid,
+		)
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package envelopes
+
+import (
+	"crypto/ecdsa"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/require"
+	"github.com/xmtp/xmtpd/pkg/proto/identity/associations"
+	"github.com/xmtp/xmtpd/pkg/proto/xmtpv4/message_api"
+	"github.com/xmtp/xmtpd/pkg/utils"
+	"google.golang.org/protobuf/proto"
+)
+
+func signOriginatorEnvelope(
+	t *testing.T,
+	key *ecdsa.PrivateKey,
+	originatorSid uint64,
+) *message_api.OriginatorEnvelope {
+	unsignedBytes, err := proto.Marshal(&message_api.UnsignedOriginatorEnvelope{
+		OriginatorSid: originatorSid,
+	})
+	require.NoError(t, err)
+	sig, err := crypto.Sign(crypto.Keccak256(unsignedBytes), key)
+	require.NoError(t, err)
+
+	return &message_api.OriginatorEnvelope{
+		UnsignedOriginatorEnvelope: unsignedBytes,
+		Proof: &message_api.OriginatorEnvelope_OriginatorSignature{
+			OriginatorSignature: &associations.RecoverableEcdsaSignature{Bytes: sig},
+		},
+	}
+}
+
+func TestVerifyOriginatorEnvelope(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	sid := utils.SID(1, 10)
+
+	require.NoError(
+		t,
+		VerifyOriginatorEnvelope(signOriginatorEnvelope(t, key, sid), sid, &key.PublicKey),
+	)
+}
+
+func TestVerifyOriginatorEnvelopeWrongKey(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	otherKey, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	sid := utils.SID(1, 10)
+
+	err = VerifyOriginatorEnvelope(signOriginatorEnvelope(t, key, sid), sid, &otherKey.PublicKey)
+	require.ErrorContains(t, err, "does not match")
+}
+
+func TestVerifyOriginatorEnvelopeWrongSid(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+
+	err = VerifyOriginatorEnvelope(
+		signOriginatorEnvelope(t, key, utils.SID(1, 10)),
+		utils.SID(1, 11),
+		&key.PublicKey,
+	)
+	require.ErrorContains(t, err, "stored as")
+}
+
+func TestVerifyOriginatorEnvelopeUnsigned(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+
+	err = VerifyOriginatorEnvelope(&message_api.OriginatorEnvelope{}, 1, &key.PublicKey)
+	require.ErrorContains(t, err, "missing originator signature")
+}
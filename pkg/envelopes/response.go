@@ -0,0 +1,69 @@
+package envelopes
+
+import (
+	"crypto/ecdsa"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/xmtp/xmtpd/pkg/proto/xmtpv4/message_api"
+	"google.golang.org/protobuf/proto"
+)
+
+// Prefixed to the signed data, so that response signatures can't be passed off as any other
+// kind of signature by the same key
+const queryResponseDomain = "xmtpd/query-response/v1"
+
+/*
+*
+Returns the hash a node signs to vouch for the response it served to a query. The request is
+included, so that a response can't be replayed as the answer to a different query.
+
+Both messages are serialized deterministically, so clients using the Go protobuf library can
+recompute the hash from the messages they sent and received.
+*/
+func QueryResponseDigest(
+	req *message_api.QueryEnvelopesRequest,
+	resp *message_api.QueryEnvelopesResponse,
+) ([]byte, error) {
+	marshal := proto.MarshalOptions{Deterministic: true}
+	reqBytes, err := marshal.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	respBytes, err := marshal.Marshal(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, 0, len(queryResponseDomain)+4+len(reqBytes)+len(respBytes))
+	data = append(data, queryResponseDomain...)
+	data = binary.BigEndian.AppendUint32(data, uint32(len(reqBytes)))
+	data = append(data, reqBytes...)
+	data = append(data, respBytes...)
+	return crypto.Keccak256(data), nil
+}
+
+// Verifies that the response to req was signed by signingKey
+func VerifyQueryResponse(
+	req *message_api.QueryEnvelopesRequest,
+	resp *message_api.QueryEnvelopesResponse,
+	sig []byte,
+	signingKey *ecdsa.PublicKey,
+) error {
+	if signingKey == nil {
+		return fmt.Errorf("missing signing key")
+	}
+	digest, err := QueryResponseDigest(req, resp)
+	if err != nil {
+		return err
+	}
+	signer, err := crypto.SigToPub(digest, sig)
+	if err != nil {
+		return fmt.Errorf("invalid response signature: %v", err)
+	}
+	if !signer.Equal(signingKey) {
+		return fmt.Errorf("response signature does not match the node's signing key")
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package envelopes
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/require"
+	"github.com/xmtp/xmtpd/pkg/proto/xmtpv4/message_api"
+)
+
+func TestVerifyQueryResponse(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	otherKey, err := crypto.GenerateKey()
+	require.NoError(t, err)
+
+	req := &message_api.QueryEnvelopesRequest{
+		Query: &message_api.EnvelopesQuery{
+			Filter: &message_api.EnvelopesQuery_Topic{Topic: []byte("topic")},
+		},
+	}
+	resp := &message_api.QueryEnvelopesResponse{
+		Envelopes: []*message_api.GatewayEnvelope{
+			{GatewaySid: 1, OriginatorEnvelope: signOriginatorEnvelope(t, key, 1)},
+		},
+	}
+	digest, err := QueryResponseDigest(req, resp)
+	require.NoError(t, err)
+	sig, err := crypto.Sign(digest, key)
+	require.NoError(t, err)
+
+	require.NoError(t, VerifyQueryResponse(req, resp, sig, &key.PublicKey))
+	require.ErrorContains(
+		t,
+		VerifyQueryResponse(req, resp, sig, &otherKey.PublicKey),
+		"does not match",
+	)
+	require.ErrorContains(t, VerifyQueryResponse(req, resp, []byte{1}, &key.PublicKey), "invalid")
+	require.ErrorContains(t, VerifyQueryResponse(req, resp, sig, nil), "missing signing key")
+
+	// The signature covers the request, so it can't be replayed for another query
+	otherReq := &message_api.QueryEnvelopesRequest{
+		Query: &message_api.EnvelopesQuery{
+			Filter: &message_api.EnvelopesQuery_Topic{Topic: []byte("other")},
+		},
+	}
+	require.Error(t, VerifyQueryResponse(otherReq, resp, sig, &key.PublicKey))
+}
@@ -0,0 +1,84 @@
+// Package envelopes contains the rules the node applies to published envelopes.
+//
+// The rules are exported so that clients can reject invalid envelopes before sending them.
+// Errors are gRPC status errors identical to the ones returned by the node. Size limits are
+// configured per node, and are passed in by the caller.
+package envelopes
+
+import (
+	"github.com/xmtp/xmtpd/pkg/proto/xmtpv4/message_api"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// Limits on published envelopes, configured per node
+type Limits struct {
+	// Maximum size of a serialized client envelope in bytes
+	MaxClientEnvelopeSize int
+	// Maximum length of a topic in bytes
+	MaxTopicLength int
+}
+
+// Validates a payer envelope and returns the client envelope it contains. The serialized
+// client envelope may be at most maxSize bytes
+func ValidatePayerEnvelope(
+	payerEnv *message_api.PayerEnvelope,
+	maxSize int,
+) (*message_api.ClientEnvelope, error) {
+	clientBytes := payerEnv.GetUnsignedClientEnvelope()
+	sig := payerEnv.GetPayerSignature()
+	if (clientBytes == nil) || (sig == nil) {
+		return nil, status.Errorf(codes.InvalidArgument, "missing envelope or signature")
+	}
+	if len(clientBytes) > maxSize {
+		return nil, status.Errorf(
+			codes.InvalidArgument,
+			"client envelope of %d bytes exceeds maximum size of %d bytes",
+			len(clientBytes),
+			maxSize,
+		)
+	}
+
+	clientEnv := &message_api.ClientEnvelope{}
+	err := proto.Unmarshal(clientBytes, clientEnv)
+	if err != nil {
+		return nil, status.Errorf(
+			codes.InvalidArgument,
+			"could not unmarshal client envelope: %v",
+			err,
+		)
+	}
+
+	return clientEnv, nil
+}
+
+// Validates that a client envelope can be published to the node with ID targetOriginator,
+// whose topics may be at most maxTopicLength bytes
+func ValidateClientEnvelope(
+	clientEnv *message_api.ClientEnvelope,
+	targetOriginator uint32,
+	maxTopicLength int,
+) error {
+	if clientEnv.GetAad().GetTargetOriginator() != targetOriginator {
+		return status.Errorf(codes.InvalidArgument, "invalid target originator")
+	}
+
+	return ValidateTopic(clientEnv.GetAad().GetTargetTopic(), maxTopicLength)
+}
+
+func ValidateTopic(topic []byte, maxLength int) error {
+	if len(topic) == 0 {
+		return status.Errorf(codes.InvalidArgument, "missing target topic")
+	}
+	if len(topic) > maxLength {
+		return status.Errorf(
+			codes.InvalidArgument,
+			"target topic of %d bytes exceeds maximum length of %d bytes",
+			len(topic),
+			maxLength,
+		)
+	}
+
+	return nil
+}
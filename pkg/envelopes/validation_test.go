@@ -0,0 +1,93 @@
+package envelopes
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xmtp/xmtpd/pkg/proto/identity/associations"
+	"github.com/xmtp/xmtpd/pkg/proto/xmtpv4/message_api"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	testMaxClientEnvelopeSize = 1024
+	testMaxTopicLength        = 16
+)
+
+func createClientEnvelope() *message_api.ClientEnvelope {
+	return &message_api.ClientEnvelope{
+		Aad: &message_api.AuthenticatedData{
+			TargetOriginator: 1,
+			TargetTopic:      []byte{0x5},
+		},
+	}
+}
+
+func createPayerEnvelope(
+	t *testing.T,
+	clientEnv *message_api.ClientEnvelope,
+) *message_api.PayerEnvelope {
+	clientBytes, err := proto.Marshal(clientEnv)
+	require.NoError(t, err)
+	return &message_api.PayerEnvelope{
+		UnsignedClientEnvelope: clientBytes,
+		PayerSignature:         &associations.RecoverableEcdsaSignature{},
+	}
+}
+
+func TestValidatePayerEnvelope(t *testing.T) {
+	clientEnv, err := ValidatePayerEnvelope(
+		createPayerEnvelope(t, createClientEnvelope()),
+		testMaxClientEnvelopeSize,
+	)
+	require.NoError(t, err)
+	require.True(t, proto.Equal(createClientEnvelope(), clientEnv))
+}
+
+func TestValidatePayerEnvelopeMissingSignature(t *testing.T) {
+	payerEnv := createPayerEnvelope(t, createClientEnvelope())
+	payerEnv.PayerSignature = nil
+	_, err := ValidatePayerEnvelope(payerEnv, testMaxClientEnvelopeSize)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	require.ErrorContains(t, err, "missing envelope or signature")
+}
+
+func TestValidatePayerEnvelopeTooLarge(t *testing.T) {
+	payerEnv := createPayerEnvelope(t, createClientEnvelope())
+	payerEnv.UnsignedClientEnvelope = bytes.Repeat([]byte{1}, testMaxClientEnvelopeSize+1)
+	_, err := ValidatePayerEnvelope(payerEnv, testMaxClientEnvelopeSize)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	require.ErrorContains(t, err, "exceeds maximum size of 1024 bytes")
+}
+
+func TestValidatePayerEnvelopeUnmarshalError(t *testing.T) {
+	payerEnv := createPayerEnvelope(t, createClientEnvelope())
+	payerEnv.UnsignedClientEnvelope = []byte("invalidbytes")
+	_, err := ValidatePayerEnvelope(payerEnv, testMaxClientEnvelopeSize)
+	require.ErrorContains(t, err, "unmarshal")
+}
+
+func TestValidateClientEnvelope(t *testing.T) {
+	require.NoError(t, ValidateClientEnvelope(createClientEnvelope(), 1, testMaxTopicLength))
+
+	err := ValidateClientEnvelope(createClientEnvelope(), 2, testMaxTopicLength)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	require.ErrorContains(t, err, "originator")
+}
+
+func TestValidateTopic(t *testing.T) {
+	require.NoError(t, ValidateTopic([]byte{0x5}, testMaxTopicLength))
+	require.NoError(
+		t,
+		ValidateTopic(bytes.Repeat([]byte{1}, testMaxTopicLength), testMaxTopicLength),
+	)
+	require.ErrorContains(t, ValidateTopic(nil, testMaxTopicLength), "missing target topic")
+	require.ErrorContains(
+		t,
+		ValidateTopic(bytes.Repeat([]byte{1}, testMaxTopicLength+1), testMaxTopicLength),
+		"exceeds maximum length of 16 bytes",
+	)
+}
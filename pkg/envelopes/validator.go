@@ -0,0 +1,47 @@
+package envelopes
+
+import (
+	"github.com/xmtp/xmtpd/pkg/proto/xmtpv4/message_api"
+)
+
+// An EnvelopeValidator checks a client envelope before it is staged for publishing.
+// Rejections should be gRPC status errors, which are returned to the client as is.
+type EnvelopeValidator interface {
+	Validate(clientEnv *message_api.ClientEnvelope) error
+}
+
+// Adapts a function to an EnvelopeValidator
+type ValidatorFunc func(clientEnv *message_api.ClientEnvelope) error
+
+func (f ValidatorFunc) Validate(clientEnv *message_api.ClientEnvelope) error {
+	return f(clientEnv)
+}
+
+/*
+A Pipeline runs validators in order and stops at the first rejection. The node runs it
+after ValidatePayerEnvelope and ValidateClientEnvelope, which already enforce the size and
+topic length limits, so validators only need to implement additional policy, such as
+payload-specific checks. Programs embedding the node pass their validators to
+server.NewReplicationServer.
+*/
+type Pipeline struct {
+	validators []EnvelopeValidator
+}
+
+func NewPipeline(validators ...EnvelopeValidator) *Pipeline {
+	return &Pipeline{validators: validators}
+}
+
+// Appends a validator to the end of the pipeline. Not safe to call while validating.
+func (p *Pipeline) Add(validator EnvelopeValidator) {
+	p.validators = append(p.validators, validator)
+}
+
+func (p *Pipeline) Validate(clientEnv *message_api.ClientEnvelope) error {
+	for _, validator := range p.validators {
+		if err := validator.Validate(clientEnv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
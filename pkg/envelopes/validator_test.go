@@ -0,0 +1,30 @@
+package envelopes
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xmtp/xmtpd/pkg/proto/xmtpv4/message_api"
+)
+
+func TestPipelineRunsValidatorsInOrder(t *testing.T) {
+	var calls []string
+	validator := func(name string, err error) EnvelopeValidator {
+		return ValidatorFunc(func(*message_api.ClientEnvelope) error {
+			calls = append(calls, name)
+			return err
+		})
+	}
+	rejected := errors.New("rejected")
+
+	pipeline := NewPipeline(validator("first", nil), validator("second", rejected))
+	pipeline.Add(validator("third", nil))
+
+	require.ErrorIs(t, pipeline.Validate(createClientEnvelope()), rejected)
+	require.Equal(t, []string{"first", "second"}, calls)
+}
+
+func TestEmptyPipeline(t *testing.T) {
+	require.NoError(t, NewPipeline().Validate(createClientEnvelope()))
+}
@@ -0,0 +1,85 @@
+package health
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+)
+
+const (
+	// Maximum time a single check may take before it is reported as failing
+	CHECK_TIMEOUT = 5 * time.Second
+)
+
+// A Check verifies that one of the node's dependencies is usable
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+// Implemented by registries that refresh their view of the nodes in the background
+type Refresher interface {
+	LastRefresh() time.Time
+}
+
+// Implemented by locks that can be taken over by another process
+type Lock interface {
+	Lost() bool
+}
+
+// Verifies that the database accepts connections
+func DBCheck(db *sql.DB) Check {
+	return Check{
+		Name: "db",
+		Run: func(ctx context.Context) error {
+			return db.PingContext(ctx)
+		},
+	}
+}
+
+// Verifies that this process still holds the writer lock
+func WriterLockCheck(lock Lock) Check {
+	return Check{
+		Name: "writer-lock",
+		Run: func(ctx context.Context) error {
+			if lock.Lost() {
+				return fmt.Errorf("writer lock was taken over by another process")
+			}
+			return nil
+		},
+	}
+}
+
+// Verifies that the registry has successfully refreshed within maxAge
+func RegistryCheck(registry Refresher, maxAge time.Duration) Check {
+	return Check{
+		Name: "registry",
+		Run: func(ctx context.Context) error {
+			lastRefresh := registry.LastRefresh()
+			if lastRefresh.IsZero() {
+				return fmt.Errorf("registry has never been refreshed")
+			}
+			if age := time.Since(lastRefresh); age > maxAge {
+				return fmt.Errorf(
+					"registry was last refreshed %s ago",
+					age.Round(time.Second),
+				)
+			}
+			return nil
+		},
+	}
+}
+
+// Verifies that the blockchain RPC endpoint responds
+func ChainCheck(client ethereum.BlockNumberReader) Check {
+	return Check{
+		Name: "chain",
+		Run: func(ctx context.Context) error {
+			_, err := client.BlockNumber(ctx)
+			return err
+		},
+	}
+}
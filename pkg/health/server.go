@@ -0,0 +1,140 @@
+// Package health serves liveness and readiness probes over HTTP, for orchestrators such as
+// Kubernetes to decide whether to restart the node or route traffic to it.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	StatusOK      = "ok"
+	StatusFailing = "failing"
+)
+
+type CheckResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+type Server struct {
+	log        *zap.Logger
+	listener   net.Listener
+	httpServer *http.Server
+}
+
+/*
+*
+Serves /healthz, which runs the liveness checks, and /readyz, which runs the readiness
+checks. Both respond 200 when every check passes and 503 otherwise, with a Report as the
+body.
+*/
+func NewServer(
+	ctx context.Context,
+	log *zap.Logger,
+	port int,
+	liveness []Check,
+	readiness []Check,
+) (*Server, error) {
+	listener, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", port))
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		log:      log.Named("health"),
+		listener: listener,
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", checkHandler(s.log, liveness))
+	mux.Handle("/readyz", checkHandler(s.log, readiness))
+	s.httpServer = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext: func(net.Listener) context.Context {
+			return ctx
+		},
+	}
+
+	go func() {
+		s.log.Info("serving health checks", zap.String("address", listener.Addr().String()))
+		err := s.httpServer.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.log.Error("serving health checks", zap.Error(err))
+		}
+	}()
+
+	return s, nil
+}
+
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+func (s *Server) Close() {
+	if err := s.httpServer.Close(); err != nil {
+		s.log.Error("closing health server", zap.Error(err))
+	}
+}
+
+func checkHandler(log *zap.Logger, checks []Check) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		report := runChecks(r.Context(), checks)
+
+		w.Header().Set("Content-Type", "application/json")
+		if report.Status != StatusOK {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			log.Error("writing health report", zap.Error(err))
+		}
+	})
+}
+
+// Runs the checks concurrently, so that one slow dependency doesn't delay the others
+func runChecks(ctx context.Context, checks []Check) Report {
+	report := Report{Status: StatusOK, Checks: make(map[string]CheckResult, len(checks))}
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	for _, check := range checks {
+		wg.Add(1)
+		go func(check Check) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, CHECK_TIMEOUT)
+			defer cancel()
+
+			result := CheckResult{Status: StatusOK}
+			if err := check.Run(ctx); err != nil {
+				result = CheckResult{Status: StatusFailing, Error: err.Error()}
+			}
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			report.Checks[check.Name] = result
+			if result.Status != StatusOK {
+				report.Status = StatusFailing
+			}
+		}(check)
+	}
+	wg.Wait()
+
+	return report
+}
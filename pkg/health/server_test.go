@@ -0,0 +1,112 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	test "github.com/xmtp/xmtpd/pkg/testing"
+)
+
+type fakeRefresher struct {
+	lastRefresh time.Time
+}
+
+func (f fakeRefresher) LastRefresh() time.Time {
+	return f.lastRefresh
+}
+
+type fakeLock struct {
+	lost bool
+}
+
+func (f fakeLock) Lost() bool {
+	return f.lost
+}
+
+func passingCheck(name string) Check {
+	return Check{Name: name, Run: func(ctx context.Context) error { return nil }}
+}
+
+func failingCheck(name string) Check {
+	return Check{Name: name, Run: func(ctx context.Context) error { return errors.New("down") }}
+}
+
+func getReport(t *testing.T, checks []Check) (int, Report) {
+	recorder := httptest.NewRecorder()
+	checkHandler(test.NewLog(t), checks).
+		ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	var report Report
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &report))
+	return recorder.Code, report
+}
+
+func TestAllChecksPass(t *testing.T) {
+	code, report := getReport(t, []Check{passingCheck("db"), passingCheck("chain")})
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, StatusOK, report.Status)
+	require.Equal(t, map[string]CheckResult{
+		"db":    {Status: StatusOK},
+		"chain": {Status: StatusOK},
+	}, report.Checks)
+}
+
+func TestFailingCheck(t *testing.T) {
+	code, report := getReport(t, []Check{passingCheck("db"), failingCheck("chain")})
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, StatusFailing, report.Status)
+	require.Equal(t, StatusOK, report.Checks["db"].Status)
+	require.Equal(t, CheckResult{Status: StatusFailing, Error: "down"}, report.Checks["chain"])
+}
+
+func TestNoChecks(t *testing.T) {
+	code, report := getReport(t, nil)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, StatusOK, report.Status)
+}
+
+func TestRejectsPost(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	checkHandler(test.NewLog(t), nil).
+		ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/healthz", nil))
+	require.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+}
+
+func TestRegistryCheck(t *testing.T) {
+	ctx := context.Background()
+
+	require.ErrorContains(
+		t,
+		RegistryCheck(fakeRefresher{}, time.Minute).Run(ctx),
+		"never been refreshed",
+	)
+	require.NoError(
+		t,
+		RegistryCheck(fakeRefresher{lastRefresh: time.Now()}, time.Minute).Run(ctx),
+	)
+	require.ErrorContains(
+		t,
+		RegistryCheck(
+			fakeRefresher{lastRefresh: time.Now().Add(-time.Hour)},
+			time.Minute,
+		).Run(ctx),
+		"last refreshed",
+	)
+}
+
+func TestWriterLockCheck(t *testing.T) {
+	ctx := context.Background()
+
+	require.NoError(t, WriterLockCheck(fakeLock{}).Run(ctx))
+	require.ErrorContains(
+		t,
+		WriterLockCheck(fakeLock{lost: true}).Run(ctx),
+		"taken over",
+	)
+}
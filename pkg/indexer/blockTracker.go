@@ -0,0 +1,71 @@
+package indexer
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+
+	"github.com/xmtp/xmtpd/pkg/db/queries"
+)
+
+type IBlockTracker interface {
+	GetLatestBlock() uint64
+	UpdateLatestBlock(ctx context.Context, block uint64) error
+}
+
+/*
+BlockTracker persists the highest block the indexer has processed for a contract.
+
+Indexing resumes from the latest block itself rather than the block after it, since the
+node may have stopped part way through the block's events. Log storers must therefore be
+idempotent.
+*/
+type BlockTracker struct {
+	contractAddress string
+	queries         *queries.Queries
+	latestBlock     uint64
+	mutex           sync.Mutex
+}
+
+// Loads the latest block for the contract, which is 0 if it has never been indexed
+func NewBlockTracker(
+	ctx context.Context,
+	contractAddress string,
+	queries *queries.Queries,
+) (*BlockTracker, error) {
+	latestBlock, err := queries.GetLatestBlock(ctx, contractAddress)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	return &BlockTracker{
+		contractAddress: contractAddress,
+		queries:         queries,
+		latestBlock:     uint64(latestBlock),
+	}, nil
+}
+
+func (t *BlockTracker) GetLatestBlock() uint64 {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.latestBlock
+}
+
+// Records that all events up to and including block have been processed. Blocks older
+// than the latest block are ignored.
+func (t *BlockTracker) UpdateLatestBlock(ctx context.Context, block uint64) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if block <= t.latestBlock {
+		return nil
+	}
+	err := t.queries.SetLatestBlock(ctx, queries.SetLatestBlockParams{
+		ContractAddress: t.contractAddress,
+		BlockNumber:     int64(block),
+	})
+	if err != nil {
+		return err
+	}
+	t.latestBlock = block
+	return nil
+}
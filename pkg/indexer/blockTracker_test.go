@@ -0,0 +1,36 @@
+package indexer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xmtp/xmtpd/pkg/db/queries"
+	testutils "github.com/xmtp/xmtpd/pkg/testing"
+)
+
+func TestBlockTracker(t *testing.T) {
+	ctx := context.Background()
+	db, _, cleanup := testutils.NewDB(t, ctx)
+	defer cleanup()
+	q := queries.New(db)
+	address := testutils.RandomAddress().Hex()
+
+	tracker, err := NewBlockTracker(ctx, address, q)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), tracker.GetLatestBlock())
+
+	require.NoError(t, tracker.UpdateLatestBlock(ctx, 10))
+	require.NoError(t, tracker.UpdateLatestBlock(ctx, 5))
+	require.Equal(t, uint64(10), tracker.GetLatestBlock())
+
+	// A new tracker resumes from the persisted block
+	tracker, err = NewBlockTracker(ctx, address, q)
+	require.NoError(t, err)
+	require.Equal(t, uint64(10), tracker.GetLatestBlock())
+
+	// Other contracts are tracked separately
+	tracker, err = NewBlockTracker(ctx, testutils.RandomAddress().Hex(), q)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), tracker.GetLatestBlock())
+}
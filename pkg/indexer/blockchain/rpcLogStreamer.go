@@ -8,7 +8,6 @@ import (
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/ethclient"
 	"go.uber.org/zap"
 )
 
@@ -18,7 +17,10 @@ const (
 	// Setting to 0 since we are talking about L2s with low reorg risk
 	LAG_FROM_HIGHEST_BLOCK = 0
 	ERROR_SLEEP_TIME       = 100 * time.Millisecond
-	NO_LOGS_SLEEP_TIME     = 1 * time.Second
+	// Consecutive errors back off exponentially up to this limit, to go easy on
+	// rate-limited RPC providers
+	MAX_ERROR_SLEEP_TIME = 10 * time.Second
+	NO_LOGS_SLEEP_TIME   = 1 * time.Second
 )
 
 // The builder that allows you to configure contract events to listen for
@@ -26,11 +28,11 @@ type RpcLogStreamBuilder struct {
 	// All the listeners
 	contractConfigs []contractConfig
 	logger          *zap.Logger
-	rpcUrl          string
+	client          ChainClient
 }
 
-func NewRpcLogStreamBuilder(rpcUrl string, logger *zap.Logger) *RpcLogStreamBuilder {
-	return &RpcLogStreamBuilder{rpcUrl: rpcUrl, logger: logger}
+func NewRpcLogStreamBuilder(client ChainClient, logger *zap.Logger) *RpcLogStreamBuilder {
+	return &RpcLogStreamBuilder{client: client, logger: logger}
 }
 
 func (c *RpcLogStreamBuilder) ListenForContractEvent(
@@ -47,11 +49,7 @@ func (c *RpcLogStreamBuilder) ListenForContractEvent(
 }
 
 func (c *RpcLogStreamBuilder) Build() (*RpcLogStreamer, error) {
-	client, err := ethclient.Dial(c.rpcUrl)
-	if err != nil {
-		return nil, err
-	}
-	return NewRpcLogStreamer(client, c.logger, c.contractConfigs), nil
+	return NewRpcLogStreamer(c.client, c.logger, c.contractConfigs), nil
 }
 
 // Struct defining all the information required to filter events from logs
@@ -101,6 +99,7 @@ func (r *RpcLogStreamer) watchContract(watcher contractConfig) {
 	fromBlock := int(watcher.fromBlock)
 	logger := r.logger.With(zap.String("contractAddress", watcher.contractAddress.Hex()))
 	defer close(watcher.channel)
+	errorSleepTime := ERROR_SLEEP_TIME
 	for {
 		select {
 		case <-r.ctx.Done():
@@ -114,9 +113,11 @@ func (r *RpcLogStreamer) watchContract(watcher contractConfig) {
 					zap.Int("fromBlock", fromBlock),
 					zap.Error(err),
 				)
-				time.Sleep(ERROR_SLEEP_TIME)
+				time.Sleep(errorSleepTime)
+				errorSleepTime = min(errorSleepTime*2, MAX_ERROR_SLEEP_TIME)
 				continue
 			}
+			errorSleepTime = ERROR_SLEEP_TIME
 
 			logger.Info("Got logs", zap.Int("numLogs", len(logs)), zap.Int("fromBlock", fromBlock))
 			if len(logs) == 0 {
@@ -15,10 +15,6 @@ import (
 	"go.uber.org/zap"
 )
 
-// Using a free RPC url so that the dial function works.
-// May be unwise or flaky and we may need to reconsider
-const RPC_URL = "https://nodes.mewapi.io/rpc/eth"
-
 func buildStreamer(
 	t *testing.T,
 	client ChainClient,
@@ -39,7 +35,7 @@ func buildStreamer(
 }
 
 func TestBuilder(t *testing.T) {
-	builder := NewRpcLogStreamBuilder(RPC_URL, testutils.NewLog(t))
+	builder := NewRpcLogStreamBuilder(mocks.NewMockChainClient(t), testutils.NewLog(t))
 
 	listenerChannel := builder.ListenForContractEvent(
 		1,
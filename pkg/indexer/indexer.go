@@ -7,6 +7,7 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/xmtp/xmtpd/pkg/abis"
+	"github.com/xmtp/xmtpd/pkg/chainrpc"
 	"github.com/xmtp/xmtpd/pkg/config"
 	"github.com/xmtp/xmtpd/pkg/db/queries"
 	"github.com/xmtp/xmtpd/pkg/indexer/blockchain"
@@ -22,24 +23,35 @@ func StartIndexer(
 	queries *queries.Queries,
 	cfg config.ContractsOptions,
 ) error {
-	builder := blockchain.NewRpcLogStreamBuilder(cfg.RpcUrl, logger)
+	client, err := chainrpc.DialMultiClient(ctx, logger, cfg.RpcUrls, cfg.RpcHedgeDelay)
+	if err != nil {
+		return err
+	}
+	client.Start(ctx, cfg.RpcProbeInterval)
+	builder := blockchain.NewRpcLogStreamBuilder(client, logger)
 
 	messagesTopic, err := buildMessagesTopic()
 	if err != nil {
 		return err
 	}
 
+	messagesTracker, err := NewBlockTracker(ctx, cfg.MessagesContractAddress, queries)
+	if err != nil {
+		return err
+	}
+
 	messagesChannel := builder.ListenForContractEvent(
-		0,
+		int(messagesTracker.GetLatestBlock()),
 		common.HexToAddress(cfg.MessagesContractAddress),
 		[]common.Hash{messagesTopic},
 	)
 
-	indexLogs(
+	go indexLogs(
 		ctx,
 		messagesChannel,
 		logger.Named("indexLogs").With(zap.String("contractAddress", cfg.MessagesContractAddress)),
 		storer.NewGroupMessageStorer(queries, logger),
+		messagesTracker,
 	)
 
 	streamer, err := builder.Build()
@@ -62,6 +74,7 @@ func indexLogs(
 	eventChannel <-chan types.Log,
 	logger *zap.Logger,
 	logStorer storer.LogStorer,
+	blockTracker IBlockTracker,
 ) {
 	var err storer.LogStorageError
 	// We don't need to listen for the ctx.Done() here, since the eventChannel will be closed when the parent context is canceled
@@ -81,6 +94,10 @@ func indexLogs(
 			break Retry
 
 		}
+		// Non-retriable failures are skipped as well, so the block is complete either way
+		if err := blockTracker.UpdateLatestBlock(ctx, event.BlockNumber); err != nil {
+			logger.Error("error updating latest block", zap.Error(err))
+		}
 	}
 	logger.Info("finished")
 }
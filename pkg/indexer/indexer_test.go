@@ -21,12 +21,15 @@ func TestIndexLogsSuccess(t *testing.T) {
 	logStorer := mocks.NewMockLogStorer(t)
 
 	event := types.Log{
-		Address: common.HexToAddress("0x123"),
+		Address:     common.HexToAddress("0x123"),
+		BlockNumber: 5,
 	}
 	logStorer.EXPECT().StoreLog(mock.Anything, event).Times(1).Return(nil)
+	blockTracker := mocks.NewMockIBlockTracker(t)
+	blockTracker.EXPECT().UpdateLatestBlock(mock.Anything, uint64(5)).Times(1).Return(nil)
 	channel <- event
 
-	go indexLogs(context.Background(), channel, testutils.NewLog(t), logStorer)
+	go indexLogs(context.Background(), channel, testutils.NewLog(t), logStorer, blockTracker)
 	time.Sleep(100 * time.Millisecond)
 }
 
@@ -37,7 +40,8 @@ func TestIndexLogsRetryableError(t *testing.T) {
 	logStorer := mocks.NewMockLogStorer(t)
 
 	event := types.Log{
-		Address: common.HexToAddress("0x123"),
+		Address:     common.HexToAddress("0x123"),
+		BlockNumber: 5,
 	}
 
 	// Will fail for the first call with a retryable error and a non-retryable error on the second call
@@ -49,9 +53,11 @@ func TestIndexLogsRetryableError(t *testing.T) {
 			attemptNumber++
 			return storer.NewLogStorageError(errors.New("retryable error"), attemptNumber < 2)
 		})
+	blockTracker := mocks.NewMockIBlockTracker(t)
+	blockTracker.EXPECT().UpdateLatestBlock(mock.Anything, uint64(5)).Times(1).Return(nil)
 	channel <- event
 
-	go indexLogs(context.Background(), channel, testutils.NewLog(t), logStorer)
+	go indexLogs(context.Background(), channel, testutils.NewLog(t), logStorer, blockTracker)
 	time.Sleep(200 * time.Millisecond)
 
 	logStorer.AssertNumberOfCalls(t, "StoreLog", 2)
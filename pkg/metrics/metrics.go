@@ -0,0 +1,224 @@
+// Package metrics exposes node metrics in the Prometheus format. Collectors are registered
+// with the default Prometheus registry, alongside the Go runtime and process collectors.
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var apiRequestDuration = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "xmtp_api_request_duration_seconds",
+		Help:    "Time spent serving API requests. For streams, the lifetime of the stream",
+		Buckets: prometheus.ExponentialBuckets(0.001, 4, 10),
+	},
+	[]string{"method", "code"},
+)
+
+var apiSubscribers = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "xmtp_api_subscribers",
+		Help: "Number of open envelope subscriptions",
+	},
+)
+
+var registryRefreshErrors = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "xmtp_registry_refresh_errors_total",
+		Help: "Number of failed attempts to refresh nodes from the registry contract",
+	},
+)
+
+var registryPeers = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "xmtp_registry_peers",
+		Help: "Number of nodes in the registry, including this node",
+	},
+)
+
+var storeTableRows = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "xmtp_store_table_rows",
+		Help: "Estimated number of live rows in each database table",
+	},
+	[]string{"table"},
+)
+
+var storeTableBytes = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "xmtp_store_table_bytes",
+		Help: "Size of each database table including indexes, in bytes",
+	},
+	[]string{"table"},
+)
+
+var storeSlowQueries = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "xmtp_store_slow_queries",
+		Help: "Number of the node's statements with a mean execution time above the threshold",
+	},
+)
+
+var storeSlowestQuerySeconds = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "xmtp_store_slowest_query_mean_seconds",
+		Help: "Mean execution time of the node's slowest statement, if it is above the threshold",
+	},
+)
+
+var overloadLevel = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "xmtp_overload_level",
+		Help: "Load shedding level. 0 is normal, 1 sheds subscriptions, 2 also sheds queries",
+	},
+)
+
+var storeIntegrityMismatches = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "xmtp_store_integrity_mismatches_total",
+		Help: "Number of sampled stored envelopes that failed signature verification",
+	},
+)
+
+var publishDedupHits = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "xmtp_publish_dedup_hits_total",
+		Help: "Number of publishes answered with a previously originated envelope",
+	},
+)
+
+var slowSubscribers = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "xmtp_api_slow_subscribers_total",
+		Help: "Number of times a subscriber's buffer filled up, by the policy applied",
+	},
+	[]string{"policy"},
+)
+
+var subscriberDroppedEnvelopes = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "xmtp_api_subscriber_dropped_envelopes_total",
+		Help: "Number of envelopes dropped from the buffers of slow subscribers",
+	},
+)
+
+var spamHits = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "xmtp_spam_hits_total",
+		Help: "Number of published envelopes that triggered each spam heuristic",
+	},
+	[]string{"heuristic"},
+)
+
+var apiThrottled = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "xmtp_api_throttled_total",
+		Help: "Number of requests rejected by the rate limiter, by request kind",
+	},
+	[]string{"kind"},
+)
+
+var chainRpcErrors = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "xmtp_chain_rpc_errors_total",
+		Help: "Number of failed blockchain RPC calls, by endpoint and method",
+	},
+	[]string{"endpoint", "method"},
+)
+
+var chainRpcHedges = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "xmtp_chain_rpc_hedged_requests_total",
+		Help: "Number of blockchain RPC reads also sent to a second endpoint",
+	},
+)
+
+func init() {
+	prometheus.MustRegister(
+		apiRequestDuration,
+		apiSubscribers,
+		registryRefreshErrors,
+		registryPeers,
+		storeTableRows,
+		storeTableBytes,
+		storeSlowQueries,
+		storeSlowestQuerySeconds,
+		overloadLevel,
+		storeIntegrityMismatches,
+		publishDedupHits,
+		slowSubscribers,
+		subscriberDroppedEnvelopes,
+		spamHits,
+		apiThrottled,
+		chainRpcErrors,
+		chainRpcHedges,
+	)
+}
+
+func EmitAPIRequest(method string, code string, duration time.Duration) {
+	apiRequestDuration.WithLabelValues(method, code).Observe(duration.Seconds())
+}
+
+func EmitSubscriberAdded() {
+	apiSubscribers.Inc()
+}
+
+func EmitSubscriberRemoved() {
+	apiSubscribers.Dec()
+}
+
+func EmitRegistryRefreshError() {
+	registryRefreshErrors.Inc()
+}
+
+func EmitRegistryPeers(count int) {
+	registryPeers.Set(float64(count))
+}
+
+func EmitTableStats(table string, liveRows int64, totalBytes int64) {
+	storeTableRows.WithLabelValues(table).Set(float64(liveRows))
+	storeTableBytes.WithLabelValues(table).Set(float64(totalBytes))
+}
+
+func EmitSlowQueries(count int, slowestMeanExec time.Duration) {
+	storeSlowQueries.Set(float64(count))
+	storeSlowestQuerySeconds.Set(slowestMeanExec.Seconds())
+}
+
+func EmitOverloadLevel(level int) {
+	overloadLevel.Set(float64(level))
+}
+
+func EmitIntegrityMismatch() {
+	storeIntegrityMismatches.Inc()
+}
+
+func EmitPublishDedupHit() {
+	publishDedupHits.Inc()
+}
+
+func EmitSlowSubscriber(policy string) {
+	slowSubscribers.WithLabelValues(policy).Inc()
+}
+
+func EmitSubscriberDroppedEnvelopes(count int) {
+	subscriberDroppedEnvelopes.Add(float64(count))
+}
+
+func EmitSpamHit(heuristic string) {
+	spamHits.WithLabelValues(heuristic).Inc()
+}
+
+func EmitThrottled(kind string) {
+	apiThrottled.WithLabelValues(kind).Inc()
+}
+
+func EmitChainRpcError(endpoint string, method string) {
+	chainRpcErrors.WithLabelValues(endpoint, method).Inc()
+}
+
+func EmitChainRpcHedge() {
+	chainRpcHedges.Inc()
+}
@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+)
+
+// Serves /metrics for Prometheus to scrape
+type Server struct {
+	log        *zap.Logger
+	listener   net.Listener
+	httpServer *http.Server
+}
+
+func NewServer(ctx context.Context, log *zap.Logger, port int) (*Server, error) {
+	listener, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", port))
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		log:      log.Named("metrics"),
+		listener: listener,
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	s.httpServer = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext: func(net.Listener) context.Context {
+			return ctx
+		},
+	}
+
+	go func() {
+		s.log.Info("serving metrics", zap.String("address", listener.Addr().String()))
+		err := s.httpServer.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.log.Error("serving metrics", zap.Error(err))
+		}
+	}()
+
+	return s, nil
+}
+
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+func (s *Server) Close() {
+	if err := s.httpServer.Close(); err != nil {
+		s.log.Error("closing metrics server", zap.Error(err))
+	}
+}
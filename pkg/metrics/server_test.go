@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	test "github.com/xmtp/xmtpd/pkg/testing"
+)
+
+func TestMetricsServer(t *testing.T) {
+	server, err := NewServer(context.Background(), test.NewLog(t), 0)
+	require.NoError(t, err)
+	defer server.Close()
+
+	EmitAPIRequest("/xmtp.xmtpv4.ReplicationApi/QueryEnvelopes", "OK", time.Millisecond)
+	EmitTableStats("gateway_envelopes", 10, 8192)
+	EmitSlowQueries(3, 250*time.Millisecond)
+	EmitRegistryPeers(4)
+	EmitSpamHit("topic_rate")
+	EmitThrottled("publish")
+
+	resp, err := http.Get(fmt.Sprintf("http://%s/metrics", server.Addr().String()))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Contains(t, string(body), "xmtp_api_request_duration_seconds_count")
+	require.Contains(t, string(body), `xmtp_store_table_bytes{table="gateway_envelopes"} 8192`)
+	require.Contains(t, string(body), "xmtp_store_slow_queries 3")
+	require.Contains(t, string(body), "xmtp_store_slowest_query_mean_seconds 0.25")
+	require.Contains(t, string(body), "xmtp_registry_peers 4")
+	require.Contains(t, string(body), `xmtp_spam_hits_total{heuristic="topic_rate"} 1`)
+	require.Contains(t, string(body), `xmtp_api_throttled_total{kind="publish"} 1`)
+	require.Contains(t, string(body), "go_goroutines")
+}
+
+func TestSubscriberGauge(t *testing.T) {
+	before := testutil.ToFloat64(apiSubscribers)
+	EmitSubscriberAdded()
+	EmitSubscriberAdded()
+	EmitSubscriberRemoved()
+	require.Equal(t, before+1, testutil.ToFloat64(apiSubscribers))
+}
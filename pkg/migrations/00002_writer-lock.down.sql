@@ -0,0 +1 @@
+DROP TABLE writer_fencing;
@@ -0,0 +1,11 @@
+-- Only one node process may write to a database at a time. The process holding the
+-- writer advisory lock increments the fencing token when it acquires the lock, and
+-- writes made with an older token are rejected. This stops a zombie process that lost
+-- its lock from continuing to write.
+CREATE TABLE writer_fencing(
+	fencing_token BIGINT NOT NULL DEFAULT 0,
+	singleton_id SMALLINT PRIMARY KEY DEFAULT 1,
+	CONSTRAINT is_singleton CHECK (singleton_id = 1)
+);
+
+INSERT INTO writer_fencing DEFAULT VALUES;
@@ -0,0 +1,6 @@
+-- The highest block the indexer has processed for each contract, so that a restarted
+-- node resumes indexing where it left off
+CREATE TABLE latest_block(
+	contract_address TEXT NOT NULL PRIMARY KEY,
+	block_number BIGINT NOT NULL
+);
@@ -0,0 +1,128 @@
+// Code generated by mockery v2.43.2. DO NOT EDIT.
+
+package mocks
+
+import (
+	context "context"
+
+	mock "github.com/stretchr/testify/mock"
+)
+
+// MockIBlockTracker is an autogenerated mock type for the IBlockTracker type
+type MockIBlockTracker struct {
+	mock.Mock
+}
+
+type MockIBlockTracker_Expecter struct {
+	mock *mock.Mock
+}
+
+func (_m *MockIBlockTracker) EXPECT() *MockIBlockTracker_Expecter {
+	return &MockIBlockTracker_Expecter{mock: &_m.Mock}
+}
+
+// GetLatestBlock provides a mock function with given fields:
+func (_m *MockIBlockTracker) GetLatestBlock() uint64 {
+	ret := _m.Called()
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetLatestBlock")
+	}
+
+	var r0 uint64
+	if rf, ok := ret.Get(0).(func() uint64); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(uint64)
+	}
+
+	return r0
+}
+
+// MockIBlockTracker_GetLatestBlock_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'GetLatestBlock'
+type MockIBlockTracker_GetLatestBlock_Call struct {
+	*mock.Call
+}
+
+// GetLatestBlock is a helper method to define mock.On call
+func (_e *MockIBlockTracker_Expecter) GetLatestBlock() *MockIBlockTracker_GetLatestBlock_Call {
+	return &MockIBlockTracker_GetLatestBlock_Call{Call: _e.mock.On("GetLatestBlock")}
+}
+
+func (_c *MockIBlockTracker_GetLatestBlock_Call) Run(run func()) *MockIBlockTracker_GetLatestBlock_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run()
+	})
+	return _c
+}
+
+func (_c *MockIBlockTracker_GetLatestBlock_Call) Return(_a0 uint64) *MockIBlockTracker_GetLatestBlock_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *MockIBlockTracker_GetLatestBlock_Call) RunAndReturn(run func() uint64) *MockIBlockTracker_GetLatestBlock_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// UpdateLatestBlock provides a mock function with given fields: ctx, block
+func (_m *MockIBlockTracker) UpdateLatestBlock(ctx context.Context, block uint64) error {
+	ret := _m.Called(ctx, block)
+
+	if len(ret) == 0 {
+		panic("no return value specified for UpdateLatestBlock")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, uint64) error); ok {
+		r0 = rf(ctx, block)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// MockIBlockTracker_UpdateLatestBlock_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'UpdateLatestBlock'
+type MockIBlockTracker_UpdateLatestBlock_Call struct {
+	*mock.Call
+}
+
+// UpdateLatestBlock is a helper method to define mock.On call
+//   - ctx context.Context
+//   - block uint64
+func (_e *MockIBlockTracker_Expecter) UpdateLatestBlock(ctx interface{}, block interface{}) *MockIBlockTracker_UpdateLatestBlock_Call {
+	return &MockIBlockTracker_UpdateLatestBlock_Call{Call: _e.mock.On("UpdateLatestBlock", ctx, block)}
+}
+
+func (_c *MockIBlockTracker_UpdateLatestBlock_Call) Run(run func(ctx context.Context, block uint64)) *MockIBlockTracker_UpdateLatestBlock_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(uint64))
+	})
+	return _c
+}
+
+func (_c *MockIBlockTracker_UpdateLatestBlock_Call) Return(_a0 error) *MockIBlockTracker_UpdateLatestBlock_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *MockIBlockTracker_UpdateLatestBlock_Call) RunAndReturn(run func(context.Context, uint64) error) *MockIBlockTracker_UpdateLatestBlock_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// NewMockIBlockTracker creates a new instance of MockIBlockTracker. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+// The first argument is typically a *testing.T value.
+func NewMockIBlockTracker(t interface {
+	mock.TestingT
+	Cleanup(func())
+}) *MockIBlockTracker {
+	mock := &MockIBlockTracker{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
@@ -196,6 +196,65 @@ func (_c *MockNodeRegistry_OnNewNodes_Call) RunAndReturn(run func() (<-chan []re
 	return _c
 }
 
+// OnRemovedNodes provides a mock function with given fields:
+func (_m *MockNodeRegistry) OnRemovedNodes() (<-chan []registry.Node, registry.CancelSubscription) {
+	ret := _m.Called()
+
+	if len(ret) == 0 {
+		panic("no return value specified for OnRemovedNodes")
+	}
+
+	var r0 <-chan []registry.Node
+	var r1 registry.CancelSubscription
+	if rf, ok := ret.Get(0).(func() (<-chan []registry.Node, registry.CancelSubscription)); ok {
+		return rf()
+	}
+	if rf, ok := ret.Get(0).(func() <-chan []registry.Node); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(<-chan []registry.Node)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func() registry.CancelSubscription); ok {
+		r1 = rf()
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).(registry.CancelSubscription)
+		}
+	}
+
+	return r0, r1
+}
+
+// MockNodeRegistry_OnRemovedNodes_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'OnRemovedNodes'
+type MockNodeRegistry_OnRemovedNodes_Call struct {
+	*mock.Call
+}
+
+// OnRemovedNodes is a helper method to define mock.On call
+func (_e *MockNodeRegistry_Expecter) OnRemovedNodes() *MockNodeRegistry_OnRemovedNodes_Call {
+	return &MockNodeRegistry_OnRemovedNodes_Call{Call: _e.mock.On("OnRemovedNodes")}
+}
+
+func (_c *MockNodeRegistry_OnRemovedNodes_Call) Run(run func()) *MockNodeRegistry_OnRemovedNodes_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run()
+	})
+	return _c
+}
+
+func (_c *MockNodeRegistry_OnRemovedNodes_Call) Return(_a0 <-chan []registry.Node, _a1 registry.CancelSubscription) *MockNodeRegistry_OnRemovedNodes_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *MockNodeRegistry_OnRemovedNodes_Call) RunAndReturn(run func() (<-chan []registry.Node, registry.CancelSubscription)) *MockNodeRegistry_OnRemovedNodes_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
 // NewMockNodeRegistry creates a new instance of MockNodeRegistry. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
 // The first argument is typically a *testing.T value.
 func NewMockNodeRegistry(t interface {
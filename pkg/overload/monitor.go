@@ -0,0 +1,155 @@
+// Package overload sheds load as the node approaches its resource limits, so that it keeps
+// publishing instead of being killed by the kernel or the Go runtime.
+package overload
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/xmtp/xmtpd/pkg/config"
+	"github.com/xmtp/xmtpd/pkg/metrics"
+	"github.com/xmtp/xmtpd/pkg/ratelimit"
+	"github.com/xmtp/xmtpd/pkg/utils"
+	"go.uber.org/zap"
+)
+
+type Level int32
+
+const (
+	LevelNormal Level = iota
+	// Usage is above the soft limit. New subscriptions are rejected
+	LevelShedSubscribes
+	// Usage is above a hard limit. New subscriptions and queries are rejected
+	LevelShedQueries
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelShedSubscribes:
+		return "shed-subscribes"
+	case LevelShedQueries:
+		return "shed-queries"
+	default:
+		return "normal"
+	}
+}
+
+// A point in time measurement of the node's resource usage
+type Usage struct {
+	Goroutines int
+	OpenFiles  int
+	// Fraction of the available CPU time used since the previous sample, from 0 to 1
+	CPU float64
+}
+
+/*
+*
+The Monitor samples the node's resource usage on an interval, and compares it against the
+configured limits to decide which requests to shed. Subscriptions are shed first, as they
+hold resources for the longest, then queries. Publishes are never shed.
+*/
+type Monitor struct {
+	log     *zap.Logger
+	clock   utils.Clock
+	options config.OverloadOptions
+	sample  func() (Usage, error)
+	level   atomic.Int32
+}
+
+func NewMonitor(log *zap.Logger, options config.OverloadOptions) *Monitor {
+	return newMonitor(log, utils.RealClock{}, options, newProcSampler(utils.RealClock{}))
+}
+
+func newMonitor(
+	log *zap.Logger,
+	clock utils.Clock,
+	options config.OverloadOptions,
+	sample func() (Usage, error),
+) *Monitor {
+	return &Monitor{
+		log:     log.Named("overload"),
+		clock:   clock,
+		options: options,
+		sample:  sample,
+	}
+}
+
+// Samples usage until the context is cancelled. Does nothing if no limits are configured
+func (m *Monitor) Start(ctx context.Context) {
+	if m.options.MaxGoroutines <= 0 && m.options.MaxOpenFiles <= 0 && m.options.MaxCPU <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := m.clock.NewTicker(m.options.SampleInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C():
+				usage, err := m.sample()
+				if err != nil {
+					m.log.Error("Failed to sample resource usage", zap.Error(err))
+					continue
+				}
+				m.update(usage)
+			}
+		}
+	}()
+}
+
+func (m *Monitor) Level() Level {
+	return Level(m.level.Load())
+}
+
+// Returns whether a new request of the given ratelimit kind should be served
+func (m *Monitor) Allow(kind string) bool {
+	switch kind {
+	case ratelimit.Subscribe:
+		return m.Level() < LevelShedSubscribes
+	case ratelimit.Query:
+		return m.Level() < LevelShedQueries
+	default:
+		return true
+	}
+}
+
+func (m *Monitor) update(usage Usage) {
+	// The highest usage relative to its limit, across all configured limits
+	ratio := 0.0
+	if m.options.MaxGoroutines > 0 {
+		ratio = max(ratio, float64(usage.Goroutines)/float64(m.options.MaxGoroutines))
+	}
+	if m.options.MaxOpenFiles > 0 {
+		ratio = max(ratio, float64(usage.OpenFiles)/float64(m.options.MaxOpenFiles))
+	}
+	if m.options.MaxCPU > 0 {
+		ratio = max(ratio, usage.CPU/m.options.MaxCPU)
+	}
+
+	level := LevelNormal
+	if ratio >= 1 {
+		level = LevelShedQueries
+	} else if ratio >= m.options.SoftLimit {
+		level = LevelShedSubscribes
+	}
+
+	previous := Level(m.level.Swap(int32(level)))
+	metrics.EmitOverloadLevel(int(level))
+	if level == previous {
+		return
+	}
+	fields := []zap.Field{
+		zap.Stringer("level", level),
+		zap.Stringer("previousLevel", previous),
+		zap.Int("goroutines", usage.Goroutines),
+		zap.Int("openFiles", usage.OpenFiles),
+		zap.Float64("cpu", usage.CPU),
+	}
+	if level > previous {
+		m.log.Warn("Resource usage is approaching its limits, shedding load", fields...)
+	} else {
+		m.log.Info("Resource usage decreased", fields...)
+	}
+}
@@ -0,0 +1,91 @@
+package overload
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xmtp/xmtpd/pkg/config"
+	"github.com/xmtp/xmtpd/pkg/ratelimit"
+	test "github.com/xmtp/xmtpd/pkg/testing"
+)
+
+func newTestMonitor(t *testing.T) *Monitor {
+	return newMonitor(test.NewLog(t), test.NewFakeClock(), config.OverloadOptions{
+		MaxGoroutines:  1000,
+		MaxOpenFiles:   100,
+		SoftLimit:      0.8,
+		SampleInterval: time.Second,
+	}, func() (Usage, error) {
+		return Usage{}, nil
+	})
+}
+
+func TestMonitorLevels(t *testing.T) {
+	monitor := newTestMonitor(t)
+	require.Equal(t, LevelNormal, monitor.Level())
+
+	monitor.update(Usage{Goroutines: 100, OpenFiles: 10})
+	require.Equal(t, LevelNormal, monitor.Level())
+	require.True(t, monitor.Allow(ratelimit.Subscribe))
+	require.True(t, monitor.Allow(ratelimit.Query))
+
+	// Any limit past the soft limit sheds subscriptions
+	monitor.update(Usage{Goroutines: 100, OpenFiles: 85})
+	require.Equal(t, LevelShedSubscribes, monitor.Level())
+	require.False(t, monitor.Allow(ratelimit.Subscribe))
+	require.True(t, monitor.Allow(ratelimit.Query))
+	require.True(t, monitor.Allow(ratelimit.Publish))
+
+	monitor.update(Usage{Goroutines: 1000, OpenFiles: 10})
+	require.Equal(t, LevelShedQueries, monitor.Level())
+	require.False(t, monitor.Allow(ratelimit.Subscribe))
+	require.False(t, monitor.Allow(ratelimit.Query))
+	require.True(t, monitor.Allow(ratelimit.Publish))
+
+	monitor.update(Usage{Goroutines: 100, OpenFiles: 10})
+	require.Equal(t, LevelNormal, monitor.Level())
+}
+
+func TestMonitorDisabledLimits(t *testing.T) {
+	monitor := newMonitor(
+		test.NewLog(t),
+		test.NewFakeClock(),
+		config.OverloadOptions{SoftLimit: 0.8},
+		nil,
+	)
+
+	monitor.update(Usage{Goroutines: 1_000_000, OpenFiles: 1_000_000, CPU: 1})
+	require.Equal(t, LevelNormal, monitor.Level())
+}
+
+func TestMonitorSamples(t *testing.T) {
+	clock := test.NewFakeClock()
+	usage := make(chan Usage, 1)
+	monitor := newMonitor(test.NewLog(t), clock, config.OverloadOptions{
+		MaxCPU:         0.5,
+		SoftLimit:      0.8,
+		SampleInterval: time.Second,
+	}, func() (Usage, error) {
+		return <-usage, nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	monitor.Start(ctx)
+	require.Eventually(t, func() bool {
+		return clock.TickerCount() == 1
+	}, time.Second, time.Millisecond)
+
+	usage <- Usage{CPU: 0.6}
+	clock.Advance(time.Second)
+	require.Eventually(t, func() bool {
+		return monitor.Level() == LevelShedQueries
+	}, time.Second, time.Millisecond)
+}
+
+func TestProcSampler(t *testing.T) {
+	usage, err := newProcSampler(test.NewFakeClock())()
+	require.NoError(t, err)
+	require.Positive(t, usage.Goroutines)
+}
@@ -0,0 +1,51 @@
+package overload
+
+import (
+	"runtime"
+	"time"
+
+	"github.com/prometheus/procfs"
+	"github.com/xmtp/xmtpd/pkg/utils"
+)
+
+// Samples usage from /proc. Where /proc is unavailable, only goroutines are counted
+type procSampler struct {
+	clock      utils.Clock
+	lastCPU    float64
+	lastSample time.Time
+}
+
+func newProcSampler(clock utils.Clock) func() (Usage, error) {
+	s := &procSampler{clock: clock}
+	return s.sample
+}
+
+func (s *procSampler) sample() (Usage, error) {
+	usage := Usage{Goroutines: runtime.NumGoroutine()}
+
+	proc, err := procfs.Self()
+	if err != nil {
+		return usage, nil
+	}
+	usage.OpenFiles, err = proc.FileDescriptorsLen()
+	if err != nil {
+		return usage, err
+	}
+	stat, err := proc.Stat()
+	if err != nil {
+		return usage, err
+	}
+
+	now := s.clock.Now()
+	cpu := stat.CPUTime()
+	if !s.lastSample.IsZero() {
+		elapsed := now.Sub(s.lastSample).Seconds() * float64(runtime.NumCPU())
+		if elapsed > 0 {
+			usage.CPU = (cpu - s.lastCPU) / elapsed
+		}
+	}
+	s.lastCPU = cpu
+	s.lastSample = now
+
+	return usage, nil
+}
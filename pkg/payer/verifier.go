@@ -0,0 +1,111 @@
+// Package payer checks who pays for published envelopes. Every envelope is wrapped in a
+// PayerEnvelope, signed by the payer over the serialized client envelope.
+package payer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/xmtp/xmtpd/pkg/config"
+	"github.com/xmtp/xmtpd/pkg/proto/xmtpv4/message_api"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	ModeNone      = "none"
+	ModeSignature = "signature"
+)
+
+/*
+*
+A Verifier decides whether the payer of a published envelope is accepted, before the node
+stores it.
+
+Errors are gRPC status errors that are returned to the client unchanged:
+  - InvalidArgument when the payer signature is malformed
+  - Unauthenticated when the payer signature can't be verified
+  - PermissionDenied when the payer is not allowed to publish
+*/
+type Verifier interface {
+	// Returns the address of the payer, or the zero address when it isn't checked
+	Verify(ctx context.Context, payerEnv *message_api.PayerEnvelope) (common.Address, error)
+}
+
+func NewVerifier(options config.PayerOptions) (Verifier, error) {
+	switch options.Mode {
+	case "", ModeNone:
+		if len(options.Allowlist) > 0 {
+			return nil, fmt.Errorf("a payer allowlist requires the %s mode", ModeSignature)
+		}
+		return NoopVerifier{}, nil
+	case ModeSignature:
+		return NewSignatureVerifier(options.Allowlist)
+	default:
+		return nil, fmt.Errorf("invalid payer verification mode %q", options.Mode)
+	}
+}
+
+// Accepts every payer
+type NoopVerifier struct{}
+
+func (NoopVerifier) Verify(context.Context, *message_api.PayerEnvelope) (common.Address, error) {
+	return common.Address{}, nil
+}
+
+/*
+*
+Requires a recoverable ECDSA payer signature over the keccak256 hash of the unsigned client
+envelope. When the allowlist is not empty, only the payers on it may publish.
+*/
+type SignatureVerifier struct {
+	allowlist map[common.Address]bool
+}
+
+func NewSignatureVerifier(allowlist []string) (*SignatureVerifier, error) {
+	v := &SignatureVerifier{}
+	if len(allowlist) > 0 {
+		v.allowlist = make(map[common.Address]bool, len(allowlist))
+	}
+	for _, address := range allowlist {
+		if !common.IsHexAddress(address) {
+			return nil, fmt.Errorf("invalid payer address %q", address)
+		}
+		v.allowlist[common.HexToAddress(address)] = true
+	}
+	return v, nil
+}
+
+func (v *SignatureVerifier) Verify(
+	_ context.Context,
+	payerEnv *message_api.PayerEnvelope,
+) (common.Address, error) {
+	sig := payerEnv.GetPayerSignature().GetBytes()
+	if len(sig) != crypto.SignatureLength {
+		return common.Address{}, status.Errorf(
+			codes.InvalidArgument,
+			"payer signature must be %d bytes",
+			crypto.SignatureLength,
+		)
+	}
+	pub, err := crypto.SigToPub(crypto.Keccak256(payerEnv.GetUnsignedClientEnvelope()), sig)
+	if err != nil {
+		return common.Address{}, status.Errorf(
+			codes.Unauthenticated,
+			"could not verify payer signature: %v",
+			err,
+		)
+	}
+
+	address := crypto.PubkeyToAddress(*pub)
+	if v.allowlist != nil && !v.allowlist[address] {
+		return common.Address{}, status.Errorf(
+			codes.PermissionDenied,
+			"payer %s is not allowed to publish to this node",
+			address.Hex(),
+		)
+	}
+	return address, nil
+}
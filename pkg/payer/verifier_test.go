@@ -0,0 +1,83 @@
+package payer
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/require"
+	"github.com/xmtp/xmtpd/pkg/config"
+	"github.com/xmtp/xmtpd/pkg/proto/identity/associations"
+	"github.com/xmtp/xmtpd/pkg/proto/xmtpv4/message_api"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func signPayerEnvelope(t *testing.T, key *ecdsa.PrivateKey) *message_api.PayerEnvelope {
+	clientBytes := []byte("client envelope")
+	sig, err := crypto.Sign(crypto.Keccak256(clientBytes), key)
+	require.NoError(t, err)
+	return &message_api.PayerEnvelope{
+		UnsignedClientEnvelope: clientBytes,
+		PayerSignature:         &associations.RecoverableEcdsaSignature{Bytes: sig},
+	}
+}
+
+func TestNewVerifier(t *testing.T) {
+	verifier, err := NewVerifier(config.PayerOptions{})
+	require.NoError(t, err)
+	require.IsType(t, NoopVerifier{}, verifier)
+
+	verifier, err = NewVerifier(config.PayerOptions{Mode: ModeSignature})
+	require.NoError(t, err)
+	require.IsType(t, &SignatureVerifier{}, verifier)
+
+	_, err = NewVerifier(config.PayerOptions{Mode: "fee"})
+	require.ErrorContains(t, err, "invalid payer verification mode")
+
+	_, err = NewVerifier(config.PayerOptions{Allowlist: []string{"0x1"}})
+	require.ErrorContains(t, err, "requires")
+
+	_, err = NewVerifier(config.PayerOptions{Mode: ModeSignature, Allowlist: []string{"0x1"}})
+	require.ErrorContains(t, err, "invalid payer address")
+}
+
+func TestSignatureVerifier(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	verifier, err := NewSignatureVerifier(nil)
+	require.NoError(t, err)
+
+	address, err := verifier.Verify(context.Background(), signPayerEnvelope(t, key))
+	require.NoError(t, err)
+	require.Equal(t, crypto.PubkeyToAddress(key.PublicKey), address)
+
+	_, err = verifier.Verify(context.Background(), &message_api.PayerEnvelope{
+		UnsignedClientEnvelope: []byte("client envelope"),
+		PayerSignature:         &associations.RecoverableEcdsaSignature{},
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	invalid := signPayerEnvelope(t, key)
+	invalid.PayerSignature.Bytes[crypto.SignatureLength-1] = 5
+	_, err = verifier.Verify(context.Background(), invalid)
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestSignatureVerifierAllowlist(t *testing.T) {
+	allowed, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	other, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	verifier, err := NewSignatureVerifier(
+		[]string{crypto.PubkeyToAddress(allowed.PublicKey).Hex()},
+	)
+	require.NoError(t, err)
+
+	_, err = verifier.Verify(context.Background(), signPayerEnvelope(t, allowed))
+	require.NoError(t, err)
+
+	_, err = verifier.Verify(context.Background(), signPayerEnvelope(t, other))
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
@@ -0,0 +1,211 @@
+// Package ratelimit throttles API requests per client IP using token buckets, with a
+// separate limit for each kind of request.
+package ratelimit
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xmtp/xmtpd/pkg/config"
+	"github.com/xmtp/xmtpd/pkg/metrics"
+	"github.com/xmtp/xmtpd/pkg/utils"
+	"golang.org/x/time/rate"
+)
+
+const (
+	Publish   = "publish"
+	Query     = "query"
+	Subscribe = "subscribe"
+
+	// Buckets that have not been used for this long are full again, and are dropped
+	idleBucketTTL = 10 * time.Minute
+)
+
+type bucketKey struct {
+	kind string
+	ip   string
+}
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// The configured limits. Replaced as a whole when the limiter is reconfigured
+type policy struct {
+	limits    map[string]rate.Limit
+	bursts    map[string]int
+	allowlist []*net.IPNet
+}
+
+type Limiter struct {
+	clock  utils.Clock
+	policy atomic.Pointer[policy]
+
+	mutex     sync.Mutex
+	buckets   map[bucketKey]*bucket
+	lastSweep time.Time
+	// Number of throttled requests, by kind
+	throttled map[string]*atomic.Uint64
+}
+
+func NewLimiter(options config.RateLimitOptions) (*Limiter, error) {
+	return newLimiter(utils.RealClock{}, options)
+}
+
+func newLimiter(clock utils.Clock, options config.RateLimitOptions) (*Limiter, error) {
+	p, err := newPolicy(options)
+	if err != nil {
+		return nil, err
+	}
+
+	throttled := make(map[string]*atomic.Uint64)
+	for _, kind := range []string{Publish, Query, Subscribe} {
+		throttled[kind] = &atomic.Uint64{}
+	}
+
+	l := &Limiter{
+		clock:     clock,
+		buckets:   make(map[bucketKey]*bucket),
+		lastSweep: clock.Now(),
+		throttled: throttled,
+	}
+	l.policy.Store(p)
+	return l, nil
+}
+
+func newPolicy(options config.RateLimitOptions) (*policy, error) {
+	allowlist := make([]*net.IPNet, 0, len(options.Allowlist))
+	for _, entry := range options.Allowlist {
+		ipNet, err := parseAllowlistEntry(entry)
+		if err != nil {
+			return nil, err
+		}
+		allowlist = append(allowlist, ipNet)
+	}
+
+	return &policy{
+		limits: map[string]rate.Limit{
+			Publish:   rate.Limit(options.PublishRate),
+			Query:     rate.Limit(options.QueryRate),
+			Subscribe: rate.Limit(options.SubscribeRate),
+		},
+		bursts: map[string]int{
+			Publish:   options.PublishBurst,
+			Query:     options.QueryBurst,
+			Subscribe: options.SubscribeBurst,
+		},
+		allowlist: allowlist,
+	}, nil
+}
+
+/*
+*
+Replaces the limits of a running limiter. Every client starts again with a full bucket
+under the new limits. On error the current limits are kept.
+*/
+func (l *Limiter) Configure(options config.RateLimitOptions) error {
+	p, err := newPolicy(options)
+	if err != nil {
+		return err
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.policy.Store(p)
+	clear(l.buckets)
+	return nil
+}
+
+// Accepts an IP address or a CIDR range
+func parseAllowlistEntry(entry string) (*net.IPNet, error) {
+	if !strings.Contains(entry, "/") {
+		ip := net.ParseIP(entry)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid rate limit allowlist entry %q", entry)
+		}
+		bits := 8 * len(ip.To16())
+		if ip.To4() != nil {
+			ip = ip.To4()
+			bits = 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, ipNet, err := net.ParseCIDR(entry)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rate limit allowlist entry %q: %v", entry, err)
+	}
+	return ipNet, nil
+}
+
+// Reports whether a request of the given kind from ip may proceed, consuming a token if so.
+// Kinds without a configured rate, and allowlisted IPs, are never throttled.
+func (l *Limiter) Allow(kind string, ip net.IP) bool {
+	limit, ok := l.policy.Load().limits[kind]
+	if !ok || limit <= 0 || l.isAllowlisted(ip) {
+		return true
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	// Read again under the lock, so buckets are never created from a replaced policy
+	p := l.policy.Load()
+	limit = p.limits[kind]
+	if limit <= 0 {
+		return true
+	}
+
+	now := l.clock.Now()
+	l.sweep(now)
+
+	key := bucketKey{kind: kind, ip: ip.String()}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{limiter: rate.NewLimiter(limit, max(p.bursts[kind], 1))}
+		l.buckets[key] = b
+	}
+	b.lastSeen = now
+
+	if !b.limiter.AllowN(now, 1) {
+		l.throttled[kind].Add(1)
+		metrics.EmitThrottled(kind)
+		return false
+	}
+	return true
+}
+
+func (l *Limiter) isAllowlisted(ip net.IP) bool {
+	for _, ipNet := range l.policy.Load().allowlist {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Drops idle buckets, so memory is bounded by the number of recently active clients
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < idleBucketTTL {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if now.Sub(b.lastSeen) >= idleBucketTTL {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Returns the number of throttled requests of each kind
+func (l *Limiter) Throttled() map[string]uint64 {
+	throttled := make(map[string]uint64, len(l.throttled))
+	for kind, counter := range l.throttled {
+		throttled[kind] = counter.Load()
+	}
+	return throttled
+}
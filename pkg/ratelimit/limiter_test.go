@@ -0,0 +1,103 @@
+package ratelimit
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xmtp/xmtpd/pkg/config"
+	test "github.com/xmtp/xmtpd/pkg/testing"
+)
+
+func TestLimiterPerIPAndKind(t *testing.T) {
+	clock := test.NewFakeClock()
+	limiter, err := newLimiter(clock, config.RateLimitOptions{
+		PublishRate:  1,
+		PublishBurst: 2,
+	})
+	require.NoError(t, err)
+	ip := net.ParseIP("10.0.0.1")
+
+	require.True(t, limiter.Allow(Publish, ip))
+	require.True(t, limiter.Allow(Publish, ip))
+	require.False(t, limiter.Allow(Publish, ip))
+
+	// Other clients and unlimited kinds are unaffected
+	require.True(t, limiter.Allow(Publish, net.ParseIP("10.0.0.2")))
+	require.True(t, limiter.Allow(Query, ip))
+
+	// Tokens are refilled over time
+	clock.Advance(time.Second)
+	require.True(t, limiter.Allow(Publish, ip))
+	require.False(t, limiter.Allow(Publish, ip))
+
+	require.Equal(t, uint64(2), limiter.Throttled()[Publish])
+	require.Equal(t, uint64(0), limiter.Throttled()[Query])
+}
+
+func TestLimiterAllowlist(t *testing.T) {
+	limiter, err := newLimiter(test.NewFakeClock(), config.RateLimitOptions{
+		QueryRate:  1,
+		QueryBurst: 1,
+		Allowlist:  []string{"10.0.0.1", "192.168.0.0/16", "::1"},
+	})
+	require.NoError(t, err)
+
+	for _, ip := range []string{"10.0.0.1", "192.168.4.20", "::1"} {
+		for i := 0; i < 5; i++ {
+			require.True(t, limiter.Allow(Query, net.ParseIP(ip)), ip)
+		}
+	}
+	require.True(t, limiter.Allow(Query, net.ParseIP("10.0.0.2")))
+	require.False(t, limiter.Allow(Query, net.ParseIP("10.0.0.2")))
+}
+
+func TestLimiterInvalidAllowlist(t *testing.T) {
+	_, err := NewLimiter(config.RateLimitOptions{Allowlist: []string{"not-an-ip"}})
+	require.ErrorContains(t, err, "invalid rate limit allowlist entry")
+
+	_, err = NewLimiter(config.RateLimitOptions{Allowlist: []string{"10.0.0.0/99"}})
+	require.ErrorContains(t, err, "invalid rate limit allowlist entry")
+}
+
+func TestLimiterSweepsIdleBuckets(t *testing.T) {
+	clock := test.NewFakeClock()
+	limiter, err := newLimiter(clock, config.RateLimitOptions{
+		SubscribeRate:  1,
+		SubscribeBurst: 1,
+	})
+	require.NoError(t, err)
+
+	require.True(t, limiter.Allow(Subscribe, net.ParseIP("10.0.0.1")))
+	clock.Advance(idleBucketTTL)
+	require.True(t, limiter.Allow(Subscribe, net.ParseIP("10.0.0.2")))
+	require.Len(t, limiter.buckets, 1)
+}
+
+func TestLimiterConfigure(t *testing.T) {
+	limiter, err := newLimiter(test.NewFakeClock(), config.RateLimitOptions{
+		PublishRate:  1,
+		PublishBurst: 1,
+	})
+	require.NoError(t, err)
+	ip := net.ParseIP("10.0.0.1")
+	require.True(t, limiter.Allow(Publish, ip))
+	require.False(t, limiter.Allow(Publish, ip))
+
+	// Invalid options keep the current limits
+	err = limiter.Configure(config.RateLimitOptions{Allowlist: []string{"not-an-ip"}})
+	require.ErrorContains(t, err, "invalid rate limit allowlist entry")
+	require.False(t, limiter.Allow(Publish, ip))
+
+	require.NoError(t, limiter.Configure(config.RateLimitOptions{
+		PublishRate:  1,
+		PublishBurst: 2,
+	}))
+	require.True(t, limiter.Allow(Publish, ip))
+	require.True(t, limiter.Allow(Publish, ip))
+	require.False(t, limiter.Allow(Publish, ip))
+
+	require.NoError(t, limiter.Configure(config.RateLimitOptions{}))
+	require.True(t, limiter.Allow(Publish, ip))
+}
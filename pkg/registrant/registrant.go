@@ -10,6 +10,7 @@ import (
 
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/xmtp/xmtpd/pkg/db/queries"
+	"github.com/xmtp/xmtpd/pkg/envelopes"
 	"github.com/xmtp/xmtpd/pkg/proto/identity/associations"
 	"github.com/xmtp/xmtpd/pkg/proto/xmtpv4/message_api"
 	"github.com/xmtp/xmtpd/pkg/registry"
@@ -102,6 +103,35 @@ func (r *Registrant) SignStagedEnvelope(
 	return &signedEnv, nil
 }
 
+// Signs a client envelope as its payer, for envelopes the node publishes itself
+func (r *Registrant) SignPayerEnvelope(
+	unsignedClientEnvelope []byte,
+) (*message_api.PayerEnvelope, error) {
+	sig, err := r.signKeccak256(unsignedClientEnvelope)
+	if err != nil {
+		return nil, err
+	}
+
+	return &message_api.PayerEnvelope{
+		UnsignedClientEnvelope: unsignedClientEnvelope,
+		PayerSignature: &associations.RecoverableEcdsaSignature{
+			Bytes: sig,
+		},
+	}, nil
+}
+
+// Signs the response served to a query, so that clients can check it came from this node
+func (r *Registrant) SignQueryResponse(
+	req *message_api.QueryEnvelopesRequest,
+	resp *message_api.QueryEnvelopesResponse,
+) ([]byte, error) {
+	digest, err := envelopes.QueryResponseDigest(req, resp)
+	if err != nil {
+		return nil, err
+	}
+	return crypto.Sign(digest, r.privateKey)
+}
+
 func getRegistryRecord(
 	nodeRegistry registry.NodeRegistry,
 	privateKey *ecdsa.PrivateKey,
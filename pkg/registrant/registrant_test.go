@@ -248,3 +248,20 @@ func TestSignStagedEnvelopeSuccess(t *testing.T) {
 	require.Equal(t, unsignedEnv.GetOriginatorSid(), uint64(1<<48|50))
 	require.Equal(t, unsignedEnv.GetPayerEnvelope().GetUnsignedClientEnvelope()[0], uint8(3))
 }
+
+func TestSignPayerEnvelope(t *testing.T) {
+	deps, r, cleanup := setupWithRegistrant(t)
+	defer cleanup()
+
+	clientBytes := []byte("client envelope")
+	payerEnv, err := r.SignPayerEnvelope(clientBytes)
+	require.NoError(t, err)
+	require.Equal(t, clientBytes, payerEnv.GetUnsignedClientEnvelope())
+
+	pubKey, err := crypto.SigToPub(
+		crypto.Keccak256(clientBytes),
+		payerEnv.GetPayerSignature().GetBytes(),
+	)
+	require.NoError(t, err)
+	require.True(t, pubKey.Equal(&deps.privKey1.PublicKey))
+}
@@ -0,0 +1,191 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/xmtp/xmtpd/pkg/abis"
+	"go.uber.org/zap"
+)
+
+/*
+*
+A dumbed down interface of abis.NodesTransactor, covering every update to the node list
+*/
+type NodesContractAdmin interface {
+	NodesContractTransactor
+	AddNode(
+		opts *bind.TransactOpts,
+		to common.Address,
+		signingKeyPub []byte,
+		httpAddress string,
+	) (*types.Transaction, error)
+	UpdateHealth(
+		opts *bind.TransactOpts,
+		tokenId *big.Int,
+		isHealthy bool,
+	) (*types.Transaction, error)
+}
+
+/*
+*
+The NodeAdmin sends transactions to the Nodes contract on behalf of its operators. Gas
+limits and nonces are estimated from the chain when each transaction is built.
+
+In dry run mode transactions are signed and logged, but never sent.
+*/
+type NodeAdmin struct {
+	ctx          context.Context
+	logger       *zap.Logger
+	contract     NodesContractAdmin
+	filterer     *abis.NodesFilterer
+	transactOpts *bind.TransactOpts
+	dryRun       bool
+	waitMined    func(ctx context.Context, tx *types.Transaction) (*types.Receipt, error)
+}
+
+func NewNodeAdmin(
+	ctx context.Context,
+	backend ContractBackend,
+	logger *zap.Logger,
+	nodesContractAddress string,
+	privateKeyString string,
+	dryRun bool,
+) (*NodeAdmin, error) {
+	transactOpts, err := newTransactOpts(ctx, backend, privateKeyString)
+	if err != nil {
+		return nil, err
+	}
+
+	contract, err := abis.NewNodesTransactor(common.HexToAddress(nodesContractAddress), backend)
+	if err != nil {
+		return nil, err
+	}
+
+	filterer, err := abis.NewNodesFilterer(common.HexToAddress(nodesContractAddress), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &NodeAdmin{
+		ctx:          ctx,
+		logger:       logger.Named("nodeAdmin"),
+		contract:     contract,
+		filterer:     filterer,
+		transactOpts: transactOpts,
+		dryRun:       dryRun,
+		waitMined: func(ctx context.Context, tx *types.Transaction) (*types.Receipt, error) {
+			return bind.WaitMined(ctx, backend, tx)
+		},
+	}, nil
+}
+
+// Mints a node NFT to owner. Requires the contract owner's key
+func (a *NodeAdmin) AddNode(
+	owner common.Address,
+	signingKeyPub []byte,
+	httpAddress string,
+) error {
+	if _, err := crypto.UnmarshalPubkey(signingKeyPub); err != nil {
+		return fmt.Errorf("invalid signing key: %v", err)
+	}
+	if err := validateHttpAddress(httpAddress); err != nil {
+		return err
+	}
+
+	add := func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return a.contract.AddNode(opts, owner, signingKeyPub, httpAddress)
+	}
+	receipt, err := a.send("addNode", add)
+	if err != nil || receipt == nil {
+		return err
+	}
+	for _, log := range receipt.Logs {
+		event, err := a.filterer.ParseNodeUpdated(*log)
+		if err == nil {
+			a.logger.Info("Added node", zap.Uint64("nodeID", event.NodeId.Uint64()))
+		}
+	}
+
+	return nil
+}
+
+// Updates a node's HTTP address. Requires the key of the node NFT owner
+func (a *NodeAdmin) UpdateHttpAddress(nodeID uint16, httpAddress string) error {
+	if err := validateHttpAddress(httpAddress); err != nil {
+		return err
+	}
+
+	update := func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return a.contract.UpdateHttpAddress(opts, big.NewInt(int64(nodeID)), httpAddress)
+	}
+	_, err := a.send("updateHttpAddress", update)
+	return err
+}
+
+// Marks a node as healthy or unhealthy. Requires the contract owner's key
+func (a *NodeAdmin) UpdateHealth(nodeID uint16, isHealthy bool) error {
+	update := func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return a.contract.UpdateHealth(opts, big.NewInt(int64(nodeID)), isHealthy)
+	}
+	_, err := a.send("updateHealth", update)
+	return err
+}
+
+// Builds, signs and sends a transaction, then waits for it to be mined. Returns a nil
+// receipt in dry run mode
+func (a *NodeAdmin) send(
+	method string,
+	transact func(opts *bind.TransactOpts) (*types.Transaction, error),
+) (*types.Receipt, error) {
+	ctx, cancel := context.WithTimeout(a.ctx, CONTRACT_CALL_TIMEOUT)
+	defer cancel()
+	tx, err := transact(&bind.TransactOpts{
+		From:    a.transactOpts.From,
+		Signer:  a.transactOpts.Signer,
+		Context: ctx,
+		NoSend:  a.dryRun,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to build %s transaction: %v", method, err)
+	}
+
+	logger := a.logger.With(
+		zap.String("method", method),
+		zap.String("hash", tx.Hash().Hex()),
+		zap.String("from", a.transactOpts.From.Hex()),
+		zap.Uint64("nonce", tx.Nonce()),
+		zap.Uint64("gas", tx.Gas()),
+		zap.String("gasFeeCap", tx.GasFeeCap().String()),
+		zap.String("gasTipCap", tx.GasTipCap().String()),
+	)
+	if a.dryRun {
+		logger.Info("Dry run, not sending transaction")
+		return nil, nil
+	}
+
+	logger.Info("Sent transaction, waiting for it to be mined")
+	receipt, err := a.waitMined(a.ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		return nil, fmt.Errorf("%s transaction %s failed", method, tx.Hash().Hex())
+	}
+	logger.Info("Transaction mined", zap.Uint64("block", receipt.BlockNumber.Uint64()))
+
+	return receipt, nil
+}
+
+// Matches the validation the registry applies to the addresses it reads from the contract
+func validateHttpAddress(httpAddress string) error {
+	if !isValidHttpAddress(httpAddress) {
+		return fmt.Errorf("HTTP address %q must start with http:// or https://", httpAddress)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package registry
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/require"
+	"github.com/xmtp/xmtpd/pkg/abis"
+	testUtils "github.com/xmtp/xmtpd/pkg/testing"
+)
+
+type fakeNodesAdmin struct {
+	fakeNodesTransactor
+	added  []string
+	health map[int64]bool
+}
+
+func (f *fakeNodesAdmin) AddNode(
+	opts *bind.TransactOpts,
+	to common.Address,
+	signingKeyPub []byte,
+	httpAddress string,
+) (*types.Transaction, error) {
+	f.added = append(f.added, httpAddress)
+	return types.NewTx(&types.LegacyTx{}), nil
+}
+
+func (f *fakeNodesAdmin) UpdateHealth(
+	opts *bind.TransactOpts,
+	tokenId *big.Int,
+	isHealthy bool,
+) (*types.Transaction, error) {
+	f.health[tokenId.Int64()] = isHealthy
+	return types.NewTx(&types.LegacyTx{}), nil
+}
+
+func newTestNodeAdmin(t *testing.T, status uint64) (*NodeAdmin, *fakeNodesAdmin) {
+	contract := &fakeNodesAdmin{
+		fakeNodesTransactor: fakeNodesTransactor{updates: make(map[int64]string)},
+		health:              make(map[int64]bool),
+	}
+	filterer, err := abis.NewNodesFilterer(common.Address{}, nil)
+	require.NoError(t, err)
+	return &NodeAdmin{
+		ctx:          context.Background(),
+		logger:       testUtils.NewLog(t),
+		contract:     contract,
+		filterer:     filterer,
+		transactOpts: &bind.TransactOpts{},
+		waitMined: func(ctx context.Context, tx *types.Transaction) (*types.Receipt, error) {
+			return &types.Receipt{Status: status, BlockNumber: big.NewInt(1)}, nil
+		},
+	}, contract
+}
+
+func TestNodeAdminAddNode(t *testing.T) {
+	admin, contract := newTestNodeAdmin(t, types.ReceiptStatusSuccessful)
+	privateKey, err := crypto.GenerateKey()
+	require.NoError(t, err)
+
+	require.NoError(t, admin.AddNode(
+		common.Address{},
+		crypto.FromECDSAPub(&privateKey.PublicKey),
+		"https://foo.com",
+	))
+	require.Equal(t, []string{"https://foo.com"}, contract.added)
+}
+
+func TestNodeAdminAddNodeInvalid(t *testing.T) {
+	admin, contract := newTestNodeAdmin(t, types.ReceiptStatusSuccessful)
+	privateKey, err := crypto.GenerateKey()
+	require.NoError(t, err)
+
+	require.ErrorContains(
+		t,
+		admin.AddNode(common.Address{}, []byte{1, 2, 3}, "https://foo.com"),
+		"invalid signing key",
+	)
+	require.ErrorContains(
+		t,
+		admin.AddNode(common.Address{}, crypto.FromECDSAPub(&privateKey.PublicKey), "foo.com"),
+		"must start with",
+	)
+	require.Empty(t, contract.added)
+}
+
+func TestNodeAdminUpdates(t *testing.T) {
+	admin, contract := newTestNodeAdmin(t, types.ReceiptStatusSuccessful)
+
+	require.NoError(t, admin.UpdateHttpAddress(100, "https://bar.com"))
+	require.NoError(t, admin.UpdateHealth(200, false))
+	require.Equal(t, map[int64]string{100: "https://bar.com"}, contract.updates)
+	require.Equal(t, map[int64]bool{200: false}, contract.health)
+}
+
+func TestNodeAdminFailedTransaction(t *testing.T) {
+	admin, _ := newTestNodeAdmin(t, types.ReceiptStatusFailed)
+
+	require.ErrorContains(t, admin.UpdateHealth(100, true), "failed")
+}
+
+func TestNodeAdminDryRun(t *testing.T) {
+	admin, contract := newTestNodeAdmin(t, types.ReceiptStatusFailed)
+	admin.dryRun = true
+	admin.waitMined = func(ctx context.Context, tx *types.Transaction) (*types.Receipt, error) {
+		t.Fatal("dry run should not wait for transactions")
+		return nil, nil
+	}
+
+	require.NoError(t, admin.UpdateHealth(100, true))
+	require.Equal(t, map[int64]bool{100: true}, contract.health)
+}
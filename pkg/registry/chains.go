@@ -0,0 +1,80 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/xmtp/xmtpd/pkg/abis"
+	"github.com/xmtp/xmtpd/pkg/chainrpc"
+	"github.com/xmtp/xmtpd/pkg/config"
+	"go.uber.org/zap"
+)
+
+// Parses a chain given as <nodes contract address>@<rpc url>
+func ParseChain(value string) (string, string, error) {
+	nodesContractAddress, rpcUrl, ok := strings.Cut(value, "@")
+	if !ok {
+		return "", "", fmt.Errorf(
+			"invalid chain %q, expected <nodes contract address>@<rpc url>",
+			value,
+		)
+	}
+	if !common.IsHexAddress(nodesContractAddress) {
+		return "", "", fmt.Errorf("invalid nodes contract address %q", nodesContractAddress)
+	}
+	if rpcUrl == "" {
+		return "", "", fmt.Errorf("chain %q is missing an RPC URL", value)
+	}
+	return nodesContractAddress, rpcUrl, nil
+}
+
+/*
+*
+Builds a SmartContractRegistry that reads the Nodes contract from the primary chain in
+options, and from every additional chain. The registry still needs to be started.
+
+The primary chain is read through all of its RPC URLs, failing over between them until ctx
+is cancelled.
+*/
+func DialSmartContractRegistry(
+	ctx context.Context,
+	logger *zap.Logger,
+	options config.ContractsOptions,
+) (*SmartContractRegistry, error) {
+	client, err := chainrpc.DialMultiClient(
+		ctx,
+		logger,
+		options.RpcUrls,
+		options.RpcHedgeDelay,
+	)
+	if err != nil {
+		return nil, err
+	}
+	client.Start(ctx, options.RpcProbeInterval)
+	registry, err := NewSmartContractRegistry(client, logger, options)
+	if err != nil {
+		return nil, err
+	}
+
+	for idx, value := range options.AdditionalChains {
+		nodesContractAddress, rpcUrl, err := ParseChain(value)
+		if err != nil {
+			return nil, err
+		}
+		client, err := ethclient.DialContext(ctx, rpcUrl)
+		if err != nil {
+			return nil, err
+		}
+		contract, err := abis.NewNodesCaller(common.HexToAddress(nodesContractAddress), client)
+		if err != nil {
+			return nil, err
+		}
+		// RPC URLs often embed API keys, so chains are named by position instead
+		registry.AddChain(fmt.Sprintf("additional-%d", idx+1), contract)
+	}
+
+	return registry, nil
+}
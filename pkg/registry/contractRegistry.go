@@ -2,8 +2,12 @@ package registry
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
@@ -11,6 +15,8 @@ import (
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/xmtp/xmtpd/pkg/abis"
 	"github.com/xmtp/xmtpd/pkg/config"
+	"github.com/xmtp/xmtpd/pkg/metrics"
+	"github.com/xmtp/xmtpd/pkg/utils"
 	"go.uber.org/zap"
 )
 
@@ -28,16 +34,26 @@ This allows it to operate statelessly and not require a database, with a trade-o
 Given how infrequently this list changes, that trade-off seems acceptable.
 */
 type SmartContractRegistry struct {
-	ctx      context.Context
-	contract NodesContract
-	logger   *zap.Logger
+	ctx context.Context
+	// The chains to read the Nodes contract from. The first is the primary chain
+	chains []*chain
+	logger *zap.Logger
+	clock  utils.Clock
 	// How frequently to poll the smart contract
-	refreshInterval time.Duration
+	refreshInterval atomic.Int64
+	// Signals the refresh loop that refreshInterval changed
+	refreshIntervalChanged chan struct{}
+	// Time of the last successful refresh, in Unix nanoseconds
+	lastRefresh atomic.Int64
+	// Where to keep a signed copy of the nodes, if anywhere. See EnableSnapshot
+	snapshotPath       string
+	snapshotPrivateKey *ecdsa.PrivateKey
 	// Mapping of nodes from ID -> Node
 	nodes      map[uint16]Node
 	nodesMutex sync.RWMutex
-	// Notifiers for new nodes and changed nodes
+	// Notifiers for new, removed and changed nodes
 	newNodesNotifier          *notifier[[]Node]
+	removedNodesNotifier      *notifier[[]Node]
 	changedNodeNotifiers      map[uint16]*notifier[Node]
 	changedNodeNotifiersMutex sync.RWMutex
 }
@@ -56,20 +72,62 @@ func NewSmartContractRegistry(
 		return nil, err
 	}
 
-	return &SmartContractRegistry{
-		contract:             contract,
-		refreshInterval:      options.RefreshInterval,
-		logger:               logger.Named("smartContractRegistry"),
-		newNodesNotifier:     newNotifier[[]Node](),
-		nodes:                make(map[uint16]Node),
-		changedNodeNotifiers: make(map[uint16]*notifier[Node]),
-	}, nil
+	s := &SmartContractRegistry{
+		chains:                 []*chain{{name: "primary", contract: contract}},
+		refreshIntervalChanged: make(chan struct{}, 1),
+		logger:                 logger.Named("smartContractRegistry"),
+		clock:                  utils.RealClock{},
+		newNodesNotifier:       newNotifier[[]Node](),
+		removedNodesNotifier:   newNotifier[[]Node](),
+		nodes:                  make(map[uint16]Node),
+		changedNodeNotifiers:   make(map[uint16]*notifier[Node]),
+	}
+	s.refreshInterval.Store(int64(options.RefreshInterval))
+	return s, nil
+}
+
+// A Nodes contract on one chain, with the nodes it returned on its last successful load
+type chain struct {
+	name     string
+	contract NodesContract
+	nodes    []Node
+}
+
+/*
+*
+Also reads nodes from the Nodes contract on another chain, for deployments that span more
+than one chain, such as during a migration. Must be called before Start.
+
+Node IDs are shared across chains. When two chains list the same ID, the node from the chain
+added first is used.
+*/
+func (s *SmartContractRegistry) AddChain(name string, contract NodesContract) {
+	s.chains = append(s.chains, &chain{name: name, contract: contract})
+}
+
+/*
+*
+Keeps a copy of the nodes at path, signed with the node's private key, and starts from it
+when the contract can't be read. Must be called before Start.
+*/
+func (s *SmartContractRegistry) EnableSnapshot(path string, privateKeyString string) error {
+	privateKey, err := parsePrivateKey(privateKeyString)
+	if err != nil {
+		return err
+	}
+	s.snapshotPath = path
+	s.snapshotPrivateKey = privateKey
+	return nil
 }
 
 /*
 *
 Loads the initial state from the contract and starts a background refresh loop.
 
+If the contract can't be read and a snapshot is enabled, the nodes are loaded from the
+snapshot instead. LastRefresh then reports when the snapshot was taken, and the refresh loop
+reconciles the nodes with the contract once it can be read again.
+
 To stop refreshing callers should cancel the context
 *
 */
@@ -77,7 +135,18 @@ func (s *SmartContractRegistry) Start(ctx context.Context) error {
 	s.ctx = ctx
 	// If we can't load the data at least once, fail to start the service
 	if err := s.refreshData(); err != nil {
-		return err
+		if s.snapshotPath == "" {
+			return err
+		}
+		metrics.EmitRegistryRefreshError()
+		if snapshotErr := s.loadSnapshot(); snapshotErr != nil {
+			return errors.Join(err, fmt.Errorf("loading snapshot: %w", snapshotErr))
+		}
+		s.logger.Warn(
+			"Failed to load nodes from the contract, starting from the snapshot",
+			zap.Error(err),
+			zap.Time("snapshotTime", s.LastRefresh()),
+		)
 	}
 
 	go s.refreshLoop()
@@ -89,6 +158,10 @@ func (s *SmartContractRegistry) OnNewNodes() (<-chan []Node, CancelSubscription)
 	return s.newNodesNotifier.register()
 }
 
+func (s *SmartContractRegistry) OnRemovedNodes() (<-chan []Node, CancelSubscription) {
+	return s.removedNodesNotifier.register()
+}
+
 func (s *SmartContractRegistry) OnChangedNode(
 	nodeId uint16,
 ) (<-chan Node, CancelSubscription) {
@@ -107,22 +180,42 @@ func (s *SmartContractRegistry) GetNodes() ([]Node, error) {
 	s.nodesMutex.RLock()
 	defer s.nodesMutex.RUnlock()
 
-	nodes := make([]Node, len(s.nodes))
-	for idx, node := range s.nodes {
-		nodes[idx] = node
+	nodes := make([]Node, 0, len(s.nodes))
+	for _, node := range s.nodes {
+		nodes = append(nodes, node)
 	}
 	return nodes, nil
 }
 
+// Changes how frequently the contract is polled. The next refresh is one interval from now
+func (s *SmartContractRegistry) SetRefreshInterval(interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("refresh interval must be positive")
+	}
+	s.refreshInterval.Store(int64(interval))
+	select {
+	case s.refreshIntervalChanged <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
 func (s *SmartContractRegistry) refreshLoop() {
-	ticker := time.NewTicker(s.refreshInterval)
+	ticker := s.clock.NewTicker(time.Duration(s.refreshInterval.Load()))
+	defer func() {
+		ticker.Stop()
+	}()
 	for {
 		select {
 		case <-s.ctx.Done():
 			return
-		case <-ticker.C:
+		case <-s.refreshIntervalChanged:
+			ticker.Stop()
+			ticker = s.clock.NewTicker(time.Duration(s.refreshInterval.Load()))
+		case <-ticker.C():
 			if err := s.refreshData(); err != nil {
 				s.logger.Error("Failed to refresh data", zap.Error(err))
+				metrics.EmitRegistryRefreshError()
 			}
 		}
 	}
@@ -135,7 +228,9 @@ func (s *SmartContractRegistry) refreshData() error {
 	}
 
 	newNodes := []Node{}
+	inContract := make(map[uint16]bool, len(fromContract))
 	for _, node := range fromContract {
+		inContract[node.NodeID] = true
 		existingValue, ok := s.nodes[node.NodeID]
 		if !ok {
 			// New node found
@@ -145,13 +240,57 @@ func (s *SmartContractRegistry) refreshData() error {
 		}
 	}
 
+	removedNodes := []Node{}
+	for nodeID, node := range s.nodes {
+		if !inContract[nodeID] {
+			removedNodes = append(removedNodes, node)
+		}
+	}
+
 	if len(newNodes) > 0 {
 		s.processNewNodes(newNodes)
 	}
+	if len(removedNodes) > 0 {
+		s.processRemovedNodes(removedNodes)
+	}
 
+	now := s.clock.Now()
+	s.lastRefresh.Store(now.UnixNano())
+	if s.snapshotPath != "" {
+		err := writeSnapshot(s.snapshotPath, s.snapshotPrivateKey, newSnapshot(fromContract, now))
+		if err != nil {
+			s.logger.Error("Failed to write registry snapshot", zap.Error(err))
+		}
+	}
 	return nil
 }
 
+// Loads the nodes from the snapshot, as if they had been read from the contract
+func (s *SmartContractRegistry) loadSnapshot() error {
+	snapshot, err := readSnapshot(s.snapshotPath, s.snapshotPrivateKey)
+	if err != nil {
+		return err
+	}
+	nodes, err := snapshot.nodes()
+	if err != nil {
+		return err
+	}
+	if len(nodes) > 0 {
+		s.processNewNodes(nodes)
+	}
+	s.lastRefresh.Store(snapshot.Time.UnixNano())
+	return nil
+}
+
+// Returns the time of the last successful refresh, or the zero time if there hasn't been one
+func (s *SmartContractRegistry) LastRefresh() time.Time {
+	lastRefresh := s.lastRefresh.Load()
+	if lastRefresh == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, lastRefresh)
+}
+
 func (s *SmartContractRegistry) processNewNodes(nodes []Node) {
 	s.logger.Info("processing new nodes", zap.Int("count", len(nodes)), zap.Any("nodes", nodes))
 	s.newNodesNotifier.trigger(nodes)
@@ -161,6 +300,19 @@ func (s *SmartContractRegistry) processNewNodes(nodes []Node) {
 	for _, node := range nodes {
 		s.nodes[node.NodeID] = node
 	}
+	metrics.EmitRegistryPeers(len(s.nodes))
+}
+
+func (s *SmartContractRegistry) processRemovedNodes(nodes []Node) {
+	s.logger.Info("processing removed nodes", zap.Int("count", len(nodes)), zap.Any("nodes", nodes))
+	s.removedNodesNotifier.trigger(nodes)
+
+	s.nodesMutex.Lock()
+	defer s.nodesMutex.Unlock()
+	for _, node := range nodes {
+		delete(s.nodes, node.NodeID)
+	}
+	metrics.EmitRegistryPeers(len(s.nodes))
 }
 
 func (s *SmartContractRegistry) processChangedNode(node Node) {
@@ -176,10 +328,53 @@ func (s *SmartContractRegistry) processChangedNode(node Node) {
 	}
 }
 
+/*
+*
+Loads and merges the nodes from every chain. A chain that fails to load contributes the
+nodes from its last successful load, so that one unavailable chain doesn't hide the nodes on
+the others. An error is only returned if every chain fails.
+*/
 func (s *SmartContractRegistry) loadFromContract() ([]Node, error) {
+	errs := []error{}
+	merged := []Node{}
+	sources := make(map[uint16]string)
+	for _, chain := range s.chains {
+		nodes, err := s.loadFromChain(chain)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s chain: %w", chain.name, err))
+			nodes = chain.nodes
+		} else {
+			chain.nodes = nodes
+		}
+		for _, node := range nodes {
+			if source, ok := sources[node.NodeID]; ok {
+				s.logger.Warn(
+					"Node ID is registered on more than one chain",
+					zap.Uint16("nodeID", node.NodeID),
+					zap.String("used", source),
+					zap.String("ignored", chain.name),
+				)
+				continue
+			}
+			sources[node.NodeID] = chain.name
+			merged = append(merged, node)
+		}
+	}
+
+	if len(errs) == len(s.chains) {
+		return nil, errors.Join(errs...)
+	}
+	if len(errs) > 0 {
+		s.logger.Error("Failed to refresh some chains", zap.Error(errors.Join(errs...)))
+		metrics.EmitRegistryRefreshError()
+	}
+	return merged, nil
+}
+
+func (s *SmartContractRegistry) loadFromChain(chain *chain) ([]Node, error) {
 	ctx, cancel := context.WithTimeout(s.ctx, CONTRACT_CALL_TIMEOUT)
 	defer cancel()
-	nodes, err := s.contract.AllNodes(&bind.CallOpts{Context: ctx})
+	nodes, err := chain.contract.AllNodes(&bind.CallOpts{Context: ctx})
 	if err != nil {
 		return nil, err
 	}
@@ -192,7 +387,11 @@ func (s *SmartContractRegistry) loadFromContract() ([]Node, error) {
 }
 
 func (s *SmartContractRegistry) SetContractForTest(contract NodesContract) {
-	s.contract = contract
+	s.chains[0].contract = contract
+}
+
+func (s *SmartContractRegistry) SetClockForTest(clock utils.Clock) {
+	s.clock = clock
 }
 
 func convertNode(rawNode abis.NodesNodeWithId) Node {
@@ -205,7 +404,7 @@ func convertNode(rawNode abis.NodesNodeWithId) Node {
 	httpAddress := rawNode.Node.HttpAddress
 
 	// Ensure the httpAddress is well formed
-	if !strings.HasPrefix(httpAddress, "https://") && !strings.HasPrefix(httpAddress, "http://") {
+	if !isValidHttpAddress(httpAddress) {
 		isValidConfig = false
 	}
 
@@ -217,3 +416,7 @@ func convertNode(rawNode abis.NodesNodeWithId) Node {
 		IsValidConfig: isValidConfig,
 	}
 }
+
+func isValidHttpAddress(httpAddress string) bool {
+	return strings.HasPrefix(httpAddress, "https://") || strings.HasPrefix(httpAddress, "http://")
+}
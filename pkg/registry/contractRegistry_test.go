@@ -2,11 +2,17 @@ package registry_test
 
 import (
 	"context"
+	"encoding/hex"
+	"errors"
 	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 	"github.com/xmtp/xmtpd/pkg/abis"
@@ -54,9 +60,11 @@ func TestContractRegistryChangedNodes(t *testing.T) {
 	registry, err := r.NewSmartContractRegistry(
 		nil,
 		testUtils.NewLog(t),
-		config.ContractsOptions{RefreshInterval: 10 * time.Millisecond},
+		config.ContractsOptions{RefreshInterval: time.Minute},
 	)
 	require.NoError(t, err)
+	clock := testUtils.NewFakeClock()
+	registry.SetClockForTest(clock)
 
 	mockContract := mocks.NewMockNodesContract(t)
 
@@ -84,17 +92,66 @@ func TestContractRegistryChangedNodes(t *testing.T) {
 	counterSub, cancelCounter := registry.OnChangedNode(1)
 	getCurrentCount := r.CountChannel(counterSub)
 	defer cancelCounter()
-	go func() {
-		for node := range sub {
-			require.Equal(t, node.HttpAddress, "http://bar.com")
-		}
-	}()
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+	require.True(t, registry.LastRefresh().IsZero())
 	require.NoError(t, registry.Start(ctx))
-	time.Sleep(100 * time.Millisecond)
-	require.Equal(t, getCurrentCount(), 1)
+	require.True(t, registry.LastRefresh().Equal(clock.Now()))
+	require.Eventually(t, func() bool {
+		return clock.TickerCount() == 1
+	}, time.Second, time.Millisecond)
+	require.Equal(t, 0, getCurrentCount())
+
+	clock.Advance(time.Minute)
+	node := <-sub
+	require.Equal(t, "http://bar.com", node.HttpAddress)
+	require.Eventually(t, func() bool {
+		return getCurrentCount() == 1
+	}, time.Second, time.Millisecond)
+}
+
+func TestContractRegistryRemovedNodes(t *testing.T) {
+	registry, err := r.NewSmartContractRegistry(
+		nil,
+		testUtils.NewLog(t),
+		config.ContractsOptions{RefreshInterval: time.Minute},
+	)
+	require.NoError(t, err)
+	clock := testUtils.NewFakeClock()
+	registry.SetClockForTest(clock)
+
+	mockContract := mocks.NewMockNodesContract(t)
+	mockContract.EXPECT().
+		AllNodes(mock.Anything).
+		Return([]abis.NodesNodeWithId{
+			{NodeId: 1, Node: abis.NodesNode{HttpAddress: "http://foo.com"}},
+			{NodeId: 2, Node: abis.NodesNode{HttpAddress: "http://bar.com"}},
+		}, nil).
+		Once()
+	mockContract.EXPECT().
+		AllNodes(mock.Anything).
+		Return([]abis.NodesNodeWithId{
+			{NodeId: 1, Node: abis.NodesNode{HttpAddress: "http://foo.com"}},
+		}, nil)
+	registry.SetContractForTest(mockContract)
+
+	sub, cancelSub := registry.OnRemovedNodes()
+	defer cancelSub()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, registry.Start(ctx))
+	require.Eventually(t, func() bool {
+		return clock.TickerCount() == 1
+	}, time.Second, time.Millisecond)
+
+	clock.Advance(time.Minute)
+	removedNodes := <-sub
+	require.Equal(t, []r.Node{{NodeID: 2, HttpAddress: "http://bar.com"}}, removedNodes)
+	require.Eventually(t, func() bool {
+		nodes, err := registry.GetNodes()
+		return err == nil && len(nodes) == 1
+	}, time.Second, time.Millisecond)
 }
 
 func TestStopOnContextCancel(t *testing.T) {
@@ -135,3 +192,270 @@ func TestStopOnContextCancel(t *testing.T) {
 	time.Sleep(100 * time.Millisecond)
 	require.Equal(t, currentNodeCount, getCurrentCount())
 }
+
+func TestContractRegistrySetRefreshInterval(t *testing.T) {
+	registry, err := r.NewSmartContractRegistry(
+		nil,
+		testUtils.NewLog(t),
+		config.ContractsOptions{RefreshInterval: time.Hour},
+	)
+	require.NoError(t, err)
+	clock := testUtils.NewFakeClock()
+	registry.SetClockForTest(clock)
+
+	mockContract := mocks.NewMockNodesContract(t)
+	mockContract.EXPECT().
+		AllNodes(mock.Anything).
+		Return([]abis.NodesNodeWithId{
+			{NodeId: 1, Node: abis.NodesNode{HttpAddress: "http://foo.com"}},
+		}, nil)
+	registry.SetContractForTest(mockContract)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, registry.Start(ctx))
+	start := clock.Now()
+
+	require.Error(t, registry.SetRefreshInterval(0))
+	require.NoError(t, registry.SetRefreshInterval(time.Minute))
+	require.Eventually(t, func() bool {
+		clock.Advance(time.Minute)
+		return registry.LastRefresh().After(start)
+	}, time.Second, 10*time.Millisecond)
+	require.Less(t, clock.Now().Sub(start), time.Hour)
+}
+
+func TestContractRegistryMultipleChains(t *testing.T) {
+	registry, err := r.NewSmartContractRegistry(
+		nil,
+		testUtils.NewLog(t),
+		config.ContractsOptions{RefreshInterval: time.Minute},
+	)
+	require.NoError(t, err)
+	clock := testUtils.NewFakeClock()
+	registry.SetClockForTest(clock)
+
+	primary := mocks.NewMockNodesContract(t)
+	primary.EXPECT().
+		AllNodes(mock.Anything).
+		Return([]abis.NodesNodeWithId{
+			{NodeId: 1, Node: abis.NodesNode{HttpAddress: "http://foo.com"}},
+		}, nil)
+	registry.SetContractForTest(primary)
+	additional := mocks.NewMockNodesContract(t)
+	additional.EXPECT().
+		AllNodes(mock.Anything).
+		Return([]abis.NodesNodeWithId{
+			{NodeId: 1, Node: abis.NodesNode{HttpAddress: "http://other.com"}},
+			{NodeId: 2, Node: abis.NodesNode{HttpAddress: "http://bar.com"}},
+		}, nil).
+		Once()
+	additional.EXPECT().
+		AllNodes(mock.Anything).
+		Return(nil, errors.New("rpc unavailable"))
+	registry.AddChain("additional", additional)
+
+	sub, cancelSub := registry.OnRemovedNodes()
+	defer cancelSub()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, registry.Start(ctx))
+
+	// The primary chain wins when IDs collide
+	expected := []r.Node{
+		{NodeID: 1, HttpAddress: "http://foo.com"},
+		{NodeID: 2, HttpAddress: "http://bar.com"},
+	}
+	nodes, err := registry.GetNodes()
+	require.NoError(t, err)
+	require.ElementsMatch(t, expected, nodes)
+
+	// A failing chain keeps the nodes from its last successful load
+	require.Eventually(t, func() bool {
+		return clock.TickerCount() == 1
+	}, time.Second, time.Millisecond)
+	lastRefresh := registry.LastRefresh()
+	clock.Advance(time.Minute)
+	require.Eventually(t, func() bool {
+		return registry.LastRefresh().After(lastRefresh)
+	}, time.Second, time.Millisecond)
+	nodes, err = registry.GetNodes()
+	require.NoError(t, err)
+	require.ElementsMatch(t, expected, nodes)
+	require.Empty(t, sub)
+}
+
+func TestContractRegistryAllChainsFail(t *testing.T) {
+	registry, err := r.NewSmartContractRegistry(
+		nil,
+		testUtils.NewLog(t),
+		config.ContractsOptions{RefreshInterval: time.Minute},
+	)
+	require.NoError(t, err)
+
+	for _, name := range []string{"primary", "additional"} {
+		contract := mocks.NewMockNodesContract(t)
+		contract.EXPECT().AllNodes(mock.Anything).Return(nil, errors.New("rpc unavailable"))
+		if name == "primary" {
+			registry.SetContractForTest(contract)
+		} else {
+			registry.AddChain(name, contract)
+		}
+	}
+
+	err = registry.Start(context.Background())
+	require.ErrorContains(t, err, "primary chain")
+	require.ErrorContains(t, err, "additional chain")
+}
+
+func newSnapshotRegistry(
+	t *testing.T,
+	contract r.NodesContract,
+	path string,
+	privateKey string,
+) *r.SmartContractRegistry {
+	registry, err := r.NewSmartContractRegistry(
+		nil,
+		testUtils.NewLog(t),
+		config.ContractsOptions{RefreshInterval: time.Minute},
+	)
+	require.NoError(t, err)
+	registry.SetContractForTest(contract)
+	require.NoError(t, registry.EnableSnapshot(path, privateKey))
+	return registry
+}
+
+func newPrivateKeyString(t *testing.T) string {
+	privateKey, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	return hex.EncodeToString(crypto.FromECDSA(privateKey))
+}
+
+func TestContractRegistryStartsFromSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.json")
+	privateKey := newPrivateKeyString(t)
+	signingKey, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	expected := []r.Node{
+		{
+			NodeID:        1,
+			SigningKey:    &signingKey.PublicKey,
+			HttpAddress:   "http://foo.com",
+			IsHealthy:     true,
+			IsValidConfig: true,
+		},
+		{NodeID: 2, HttpAddress: "http://bar.com"},
+	}
+
+	available := mocks.NewMockNodesContract(t)
+	available.EXPECT().
+		AllNodes(mock.Anything).
+		Return([]abis.NodesNodeWithId{
+			{NodeId: 1, Node: abis.NodesNode{
+				SigningKeyPub: crypto.FromECDSAPub(&signingKey.PublicKey),
+				HttpAddress:   "http://foo.com",
+				IsHealthy:     true,
+			}},
+			{NodeId: 2, Node: abis.NodesNode{HttpAddress: "http://bar.com"}},
+		}, nil)
+	snapshotClock := testUtils.NewFakeClock()
+	registry := newSnapshotRegistry(t, available, path, privateKey)
+	registry.SetClockForTest(snapshotClock)
+	ctx, cancel := context.WithCancel(context.Background())
+	require.NoError(t, registry.Start(ctx))
+	cancel()
+
+	// A restart while the contract can't be read starts from the snapshot
+	unavailable := mocks.NewMockNodesContract(t)
+	unavailable.EXPECT().
+		AllNodes(mock.Anything).
+		Return(nil, errors.New("rpc unavailable")).
+		Once()
+	unavailable.EXPECT().
+		AllNodes(mock.Anything).
+		Return([]abis.NodesNodeWithId{
+			{NodeId: 1, Node: abis.NodesNode{
+				SigningKeyPub: crypto.FromECDSAPub(&signingKey.PublicKey),
+				HttpAddress:   "http://foo.com",
+				IsHealthy:     true,
+			}},
+		}, nil)
+	registry = newSnapshotRegistry(t, unavailable, path, privateKey)
+	clock := testUtils.NewFakeClock()
+	clock.Advance(time.Hour)
+	registry.SetClockForTest(clock)
+	sub, cancelSub := registry.OnRemovedNodes()
+	defer cancelSub()
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, registry.Start(ctx))
+	nodes, err := registry.GetNodes()
+	require.NoError(t, err)
+	require.ElementsMatch(t, expected, nodes)
+	// The registry reports the age of the snapshot, so readiness checks see it is stale
+	require.True(t, registry.LastRefresh().Equal(snapshotClock.Now()))
+
+	// Once the contract can be read again, the nodes are reconciled with it
+	require.Eventually(t, func() bool {
+		return clock.TickerCount() == 1
+	}, time.Second, time.Millisecond)
+	clock.Advance(time.Minute)
+	require.Equal(t, []r.Node{expected[1]}, <-sub)
+	require.Eventually(t, func() bool {
+		return registry.LastRefresh().Equal(clock.Now())
+	}, time.Second, time.Millisecond)
+}
+
+func TestContractRegistryRejectsSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.json")
+	unavailable := mocks.NewMockNodesContract(t)
+	unavailable.EXPECT().AllNodes(mock.Anything).Return(nil, errors.New("rpc unavailable"))
+
+	// Without a snapshot the registry fails to start, as it does when snapshots are disabled
+	err := newSnapshotRegistry(t, unavailable, path, newPrivateKeyString(t)).
+		Start(context.Background())
+	require.ErrorContains(t, err, "rpc unavailable")
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	available := mocks.NewMockNodesContract(t)
+	available.EXPECT().
+		AllNodes(mock.Anything).
+		Return([]abis.NodesNodeWithId{
+			{NodeId: 1, Node: abis.NodesNode{HttpAddress: "http://foo.com"}},
+		}, nil)
+	privateKey := newPrivateKeyString(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, newSnapshotRegistry(t, available, path, privateKey).Start(ctx))
+
+	// Snapshots signed by another key are not trusted
+	err = newSnapshotRegistry(t, unavailable, path, newPrivateKeyString(t)).
+		Start(context.Background())
+	require.ErrorContains(t, err, "not signed by this node's key")
+
+	// Nor are ones that were modified after signing
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	tampered := strings.Replace(string(data), "foo.com", "evil.com", 1)
+	require.NoError(t, os.WriteFile(path, []byte(tampered), 0o600))
+	err = newSnapshotRegistry(t, unavailable, path, privateKey).Start(context.Background())
+	require.ErrorContains(t, err, "not signed by this node's key")
+}
+
+func TestParseChain(t *testing.T) {
+	address, rpcUrl, err := r.ParseChain(
+		"0x0000000000000000000000000000000000000001@https://rpc.example.com/key",
+	)
+	require.NoError(t, err)
+	require.Equal(t, "0x0000000000000000000000000000000000000001", address)
+	require.Equal(t, "https://rpc.example.com/key", rpcUrl)
+
+	for value, expectedErr := range map[string]string{
+		"https://rpc.example.com":                     "expected <nodes contract",
+		"foo@https://rpc.example.com":                 "invalid nodes contract address",
+		"0x0000000000000000000000000000000000000001@": "missing an RPC URL",
+	} {
+		_, _, err := r.ParseChain(value)
+		require.ErrorContains(t, err, expectedErr, value)
+	}
+}
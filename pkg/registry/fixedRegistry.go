@@ -6,12 +6,18 @@ import "sync"
 type FixedNodeRegistry struct {
 	nodes                     []Node
 	newNodeNotifier           *notifier[[]Node]
+	removedNodesNotifier      *notifier[[]Node]
 	changedNodeNotifiers      map[uint16]*notifier[Node]
 	changedNodeNotifiersMutex sync.Mutex
 }
 
 func NewFixedNodeRegistry(nodes []Node) *FixedNodeRegistry {
-	return &FixedNodeRegistry{nodes: nodes}
+	return &FixedNodeRegistry{
+		nodes:                nodes,
+		newNodeNotifier:      newNotifier[[]Node](),
+		removedNodesNotifier: newNotifier[[]Node](),
+		changedNodeNotifiers: make(map[uint16]*notifier[Node]),
+	}
 }
 
 func (r *FixedNodeRegistry) GetNodes() ([]Node, error) {
@@ -26,6 +32,10 @@ func (f *FixedNodeRegistry) OnNewNodes() (<-chan []Node, CancelSubscription) {
 	return f.newNodeNotifier.register()
 }
 
+func (f *FixedNodeRegistry) OnRemovedNodes() (<-chan []Node, CancelSubscription) {
+	return f.removedNodesNotifier.register()
+}
+
 func (f *FixedNodeRegistry) OnChangedNode(
 	nodeId uint16,
 ) (<-chan Node, CancelSubscription) {
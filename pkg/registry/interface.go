@@ -24,5 +24,6 @@ and notifying listeners when the list of nodes changes.
 type NodeRegistry interface {
 	GetNodes() ([]Node, error)
 	OnNewNodes() (<-chan []Node, CancelSubscription)
+	OnRemovedNodes() (<-chan []Node, CancelSubscription)
 	OnChangedNode(uint16) (<-chan Node, CancelSubscription)
 }
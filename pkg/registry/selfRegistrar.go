@@ -0,0 +1,230 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/xmtp/xmtpd/pkg/abis"
+	"github.com/xmtp/xmtpd/pkg/config"
+	"go.uber.org/zap"
+)
+
+// Time to wait for a registration update to be mined, before giving up until the next change
+const TRANSACTION_MINED_TIMEOUT = 5 * time.Minute
+
+/*
+*
+A dumbed down interface of abis.NodesTransactor, covering the updates a node operator is
+allowed to make to their own node
+*/
+type NodesContractTransactor interface {
+	UpdateHttpAddress(
+		opts *bind.TransactOpts,
+		tokenId *big.Int,
+		httpAddress string,
+	) (*types.Transaction, error)
+}
+
+// The chain access needed to send transactions to the Nodes contract and wait for them to be
+// mined
+type ContractBackend interface {
+	bind.ContractTransactor
+	bind.DeployBackend
+	ethereum.ChainIDReader
+}
+
+/*
+*
+The SelfRegistrar keeps this node's HTTP address on the Nodes contract in sync with its
+configuration, so operators don't have to send the transaction by hand.
+
+The contract only lets the owner of a node NFT update its HTTP address. Adding nodes and
+setting the health flag are reserved for the contract owner, so the SelfRegistrar only
+reports on them.
+*/
+type SelfRegistrar struct {
+	ctx          context.Context
+	logger       *zap.Logger
+	contract     NodesContractTransactor
+	transactOpts *bind.TransactOpts
+	httpAddress  string
+	waitMined    func(ctx context.Context, tx *types.Transaction) (*types.Receipt, error)
+	minedTimeout time.Duration
+}
+
+func NewSelfRegistrar(
+	ctx context.Context,
+	backend ContractBackend,
+	logger *zap.Logger,
+	options config.ContractsOptions,
+) (*SelfRegistrar, error) {
+	if options.HttpAddress == "" {
+		return nil, fmt.Errorf("an HTTP address is required to update the node registration")
+	}
+
+	transactOpts, err := newTransactOpts(ctx, backend, options.OperatorPrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	contract, err := abis.NewNodesTransactor(
+		common.HexToAddress(options.NodesContractAddress),
+		backend,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SelfRegistrar{
+		ctx:          ctx,
+		logger:       logger.Named("selfRegistrar"),
+		contract:     contract,
+		transactOpts: transactOpts,
+		httpAddress:  options.HttpAddress,
+		waitMined: func(ctx context.Context, tx *types.Transaction) (*types.Receipt, error) {
+			return bind.WaitMined(ctx, backend, tx)
+		},
+		minedTimeout: TRANSACTION_MINED_TIMEOUT,
+	}, nil
+}
+
+/*
+*
+Brings the node's registration up to date and keeps it that way whenever the node's record
+changes on the contract. Only checking that the node is registered happens before Start
+returns. Updates are sent in the background, so that a slow chain doesn't hold up the node,
+and failed updates are logged.
+
+To stop, callers should cancel the context
+*/
+func (s *SelfRegistrar) Start(registry NodeRegistry, nodeID uint16) error {
+	nodes, err := registry.GetNodes()
+	if err != nil {
+		return err
+	}
+	idx := slices.IndexFunc(nodes, func(node Node) bool {
+		return node.NodeID == nodeID
+	})
+	if idx < 0 {
+		return fmt.Errorf(
+			"node %d is not registered. Nodes can only be added by the contract owner",
+			nodeID,
+		)
+	}
+	node := nodes[idx]
+
+	changes, cancel := registry.OnChangedNode(nodeID)
+	removals, cancelRemovals := registry.OnRemovedNodes()
+	go func() {
+		defer cancel()
+		defer cancelRemovals()
+		if err := s.sync(node); err != nil {
+			s.logger.Error("Failed to update node registration", zap.Error(err))
+		}
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case nodes, ok := <-removals:
+				if !ok {
+					return
+				}
+				isRemoved := slices.ContainsFunc(nodes, func(node Node) bool {
+					return node.NodeID == nodeID
+				})
+				if isRemoved {
+					s.logger.Error(
+						"Node was removed from the contract",
+						zap.Uint16("nodeID", nodeID),
+					)
+				}
+			case node, ok := <-changes:
+				if !ok {
+					return
+				}
+				if err := s.sync(node); err != nil {
+					s.logger.Error("Failed to update node registration", zap.Error(err))
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (s *SelfRegistrar) sync(node Node) error {
+	if !node.IsHealthy {
+		s.logger.Warn(
+			"Node is marked unhealthy on the contract. Only the contract owner can change this",
+			zap.Uint16("nodeID", node.NodeID),
+		)
+	}
+	if node.HttpAddress == s.httpAddress {
+		return nil
+	}
+
+	s.logger.Info(
+		"Updating HTTP address",
+		zap.Uint16("nodeID", node.NodeID),
+		zap.String("from", node.HttpAddress),
+		zap.String("to", s.httpAddress),
+	)
+	ctx, cancel := context.WithTimeout(s.ctx, CONTRACT_CALL_TIMEOUT)
+	defer cancel()
+	tx, err := s.contract.UpdateHttpAddress(
+		&bind.TransactOpts{
+			From:    s.transactOpts.From,
+			Signer:  s.transactOpts.Signer,
+			Context: ctx,
+		},
+		big.NewInt(int64(node.NodeID)),
+		s.httpAddress,
+	)
+	if err != nil {
+		return fmt.Errorf("unable to update HTTP address: %v", err)
+	}
+
+	minedCtx, cancelMined := context.WithTimeout(s.ctx, s.minedTimeout)
+	defer cancelMined()
+	receipt, err := s.waitMined(minedCtx, tx)
+	if err != nil {
+		return fmt.Errorf(
+			"transaction %s to update HTTP address was not mined: %v",
+			tx.Hash().Hex(),
+			err,
+		)
+	}
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		return fmt.Errorf("transaction %s to update HTTP address failed", tx.Hash().Hex())
+	}
+
+	return nil
+}
+
+// Builds transaction options that sign with the given hex encoded private key
+func newTransactOpts(
+	ctx context.Context,
+	backend ContractBackend,
+	privateKeyString string,
+) (*bind.TransactOpts, error) {
+	privateKey, err := crypto.HexToECDSA(strings.TrimPrefix(privateKeyString, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse private key: %v", err)
+	}
+
+	chainID, err := backend.ChainID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return bind.NewKeyedTransactorWithChainID(privateKey, chainID)
+}
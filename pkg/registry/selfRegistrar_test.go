@@ -0,0 +1,159 @@
+package registry
+
+import (
+	"context"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/require"
+	testUtils "github.com/xmtp/xmtpd/pkg/testing"
+)
+
+type fakeNodesTransactor struct {
+	mutex   sync.Mutex
+	updates map[int64]string
+}
+
+func (f *fakeNodesTransactor) UpdateHttpAddress(
+	opts *bind.TransactOpts,
+	tokenId *big.Int,
+	httpAddress string,
+) (*types.Transaction, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.updates[tokenId.Int64()] = httpAddress
+	return types.NewTx(&types.LegacyTx{}), nil
+}
+
+func (f *fakeNodesTransactor) getUpdates() map[int64]string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	updates := make(map[int64]string, len(f.updates))
+	for tokenId, httpAddress := range f.updates {
+		updates[tokenId] = httpAddress
+	}
+	return updates
+}
+
+func newTestSelfRegistrar(
+	t *testing.T,
+	ctx context.Context,
+	httpAddress string,
+	status uint64,
+) (*SelfRegistrar, *fakeNodesTransactor) {
+	contract := &fakeNodesTransactor{updates: make(map[int64]string)}
+	return &SelfRegistrar{
+		ctx:          ctx,
+		logger:       testUtils.NewLog(t),
+		contract:     contract,
+		transactOpts: &bind.TransactOpts{},
+		httpAddress:  httpAddress,
+		waitMined: func(ctx context.Context, tx *types.Transaction) (*types.Receipt, error) {
+			return &types.Receipt{Status: status}, nil
+		},
+		minedTimeout: TRANSACTION_MINED_TIMEOUT,
+	}, contract
+}
+
+func TestSelfRegistrarUpdatesAddress(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	registrar, contract := newTestSelfRegistrar(
+		t,
+		ctx,
+		"https://new.com",
+		types.ReceiptStatusSuccessful,
+	)
+	registry := NewFixedNodeRegistry([]Node{
+		{NodeID: 1, HttpAddress: "https://old.com", IsHealthy: true},
+		{NodeID: 2, HttpAddress: "https://other.com", IsHealthy: true},
+	})
+
+	require.NoError(t, registrar.Start(registry, 1))
+	require.Eventually(t, func() bool {
+		return len(contract.getUpdates()) > 0
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, map[int64]string{1: "https://new.com"}, contract.getUpdates())
+}
+
+func TestSelfRegistrarUnchangedAddress(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	registrar, contract := newTestSelfRegistrar(
+		t,
+		ctx,
+		"https://same.com",
+		types.ReceiptStatusSuccessful,
+	)
+	registry := NewFixedNodeRegistry([]Node{{NodeID: 1, HttpAddress: "https://same.com"}})
+
+	require.NoError(t, registrar.Start(registry, 1))
+	require.Never(t, func() bool {
+		return len(contract.getUpdates()) > 0
+	}, 100*time.Millisecond, 10*time.Millisecond)
+}
+
+func TestSelfRegistrarFailedTransaction(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	registrar, contract := newTestSelfRegistrar(
+		t,
+		ctx,
+		"https://new.com",
+		types.ReceiptStatusFailed,
+	)
+	registry := NewFixedNodeRegistry([]Node{{NodeID: 1, HttpAddress: "https://old.com"}})
+
+	// A failed update is logged, rather than stopping the node from starting
+	require.NoError(t, registrar.Start(registry, 1))
+	require.Eventually(t, func() bool {
+		return len(contract.getUpdates()) > 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestSelfRegistrarTransactionNotMined(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	registrar, _ := newTestSelfRegistrar(
+		t,
+		ctx,
+		"https://new.com",
+		types.ReceiptStatusSuccessful,
+	)
+	registrar.minedTimeout = 50 * time.Millisecond
+	waitEnded := make(chan error, 1)
+	registrar.waitMined = func(ctx context.Context, tx *types.Transaction) (*types.Receipt, error) {
+		<-ctx.Done()
+		waitEnded <- ctx.Err()
+		return nil, ctx.Err()
+	}
+	registry := NewFixedNodeRegistry([]Node{{NodeID: 1, HttpAddress: "https://old.com"}})
+
+	// Start doesn't wait for the transaction, and the wait gives up after the timeout
+	require.NoError(t, registrar.Start(registry, 1))
+	select {
+	case err := <-waitEnded:
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	case <-time.After(time.Second):
+		t.Fatal("waiting for the transaction did not time out")
+	}
+}
+
+func TestSelfRegistrarUnregisteredNode(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	registrar, contract := newTestSelfRegistrar(
+		t,
+		ctx,
+		"https://new.com",
+		types.ReceiptStatusSuccessful,
+	)
+	registry := NewFixedNodeRegistry([]Node{{NodeID: 2, HttpAddress: "https://other.com"}})
+
+	require.ErrorContains(t, registrar.Start(registry, 1), "not registered")
+	require.Empty(t, contract.getUpdates())
+}
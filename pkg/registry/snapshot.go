@@ -0,0 +1,155 @@
+package registry
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+/*
+*
+A copy of the node list written after every successful refresh, so that the registry can
+start when the Nodes contract can't be read. It is signed with the node's private key, and
+only snapshots signed with the same key are loaded.
+*/
+type snapshot struct {
+	// Time of the refresh the nodes were read in
+	Time  time.Time      `json:"time"`
+	Nodes []snapshotNode `json:"nodes"`
+}
+
+type snapshotNode struct {
+	NodeID uint16 `json:"nodeId"`
+	// Uncompressed public key, or empty if the contract held an invalid key
+	SigningKey    []byte `json:"signingKey"`
+	HttpAddress   string `json:"httpAddress"`
+	IsHealthy     bool   `json:"isHealthy"`
+	IsValidConfig bool   `json:"isValidConfig"`
+}
+
+// The file holds the exact bytes that were signed, so they don't need to be re-encoded
+type snapshotFile struct {
+	Snapshot  json.RawMessage `json:"snapshot"`
+	Signature []byte          `json:"signature"`
+}
+
+func newSnapshot(nodes []Node, now time.Time) snapshot {
+	out := snapshot{Time: now, Nodes: make([]snapshotNode, len(nodes))}
+	for idx, node := range nodes {
+		var signingKey []byte
+		if node.SigningKey != nil {
+			signingKey = crypto.FromECDSAPub(node.SigningKey)
+		}
+		out.Nodes[idx] = snapshotNode{
+			NodeID:        node.NodeID,
+			SigningKey:    signingKey,
+			HttpAddress:   node.HttpAddress,
+			IsHealthy:     node.IsHealthy,
+			IsValidConfig: node.IsValidConfig,
+		}
+	}
+	sort.Slice(out.Nodes, func(i, j int) bool {
+		return out.Nodes[i].NodeID < out.Nodes[j].NodeID
+	})
+	return out
+}
+
+func (s snapshot) nodes() ([]Node, error) {
+	out := make([]Node, len(s.Nodes))
+	for idx, node := range s.Nodes {
+		var signingKey *ecdsa.PublicKey
+		if len(node.SigningKey) > 0 {
+			var err error
+			if signingKey, err = crypto.UnmarshalPubkey(node.SigningKey); err != nil {
+				return nil, fmt.Errorf("invalid signing key for node %d: %w", node.NodeID, err)
+			}
+		}
+		out[idx] = Node{
+			NodeID:        node.NodeID,
+			SigningKey:    signingKey,
+			HttpAddress:   node.HttpAddress,
+			IsHealthy:     node.IsHealthy,
+			IsValidConfig: node.IsValidConfig,
+		}
+	}
+	return out, nil
+}
+
+/*
+*
+Signs and writes the snapshot to path. The file is replaced atomically, so a crash while
+writing leaves the previous snapshot in place.
+*/
+func writeSnapshot(path string, privateKey *ecdsa.PrivateKey, s snapshot) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	signature, err := crypto.Sign(crypto.Keccak256(data), privateKey)
+	if err != nil {
+		return err
+	}
+	file, err := json.Marshal(snapshotFile{Snapshot: data, Signature: signature})
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(file); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Reads the snapshot at path, verifying that it was signed by privateKey
+func readSnapshot(path string, privateKey *ecdsa.PrivateKey) (snapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return snapshot{}, err
+	}
+	var file snapshotFile
+	if err = json.Unmarshal(data, &file); err != nil {
+		return snapshot{}, fmt.Errorf("invalid snapshot: %w", err)
+	}
+	if len(file.Signature) != crypto.SignatureLength {
+		return snapshot{}, fmt.Errorf("invalid snapshot signature length %d", len(file.Signature))
+	}
+	signer, err := crypto.SigToPub(crypto.Keccak256(file.Snapshot), file.Signature)
+	if err != nil {
+		return snapshot{}, fmt.Errorf("invalid snapshot signature: %w", err)
+	}
+	if !signer.Equal(&privateKey.PublicKey) {
+		return snapshot{}, fmt.Errorf("snapshot was not signed by this node's key")
+	}
+
+	var s snapshot
+	if err = json.Unmarshal(file.Snapshot, &s); err != nil {
+		return snapshot{}, fmt.Errorf("invalid snapshot: %w", err)
+	}
+	return s, nil
+}
+
+func parsePrivateKey(privateKeyString string) (*ecdsa.PrivateKey, error) {
+	privateKey, err := crypto.HexToECDSA(strings.TrimPrefix(privateKeyString, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse private key: %v", err)
+	}
+	return privateKey, nil
+}
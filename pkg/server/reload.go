@@ -0,0 +1,143 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/xmtp/xmtpd/pkg/config"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Implemented by registries whose polling interval can change while they run
+type refreshIntervalSetter interface {
+	SetRefreshInterval(interval time.Duration) error
+}
+
+/*
+*
+Lets the node re-read its options on SIGHUP and on POST /admin/reload of the debug server.
+load returns the options as they are currently configured, and logLevel is the level of
+the node's logger.
+*/
+func (s *ReplicationServer) EnableReload(
+	logLevel zap.AtomicLevel,
+	load func() (config.ServerOptions, error),
+) {
+	s.reloadMutex.Lock()
+	s.logLevel = logLevel
+	s.load = load
+	s.reloadMutex.Unlock()
+
+	hupC := make(chan os.Signal, 1)
+	signal.Notify(hupC, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hupC)
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-hupC:
+				// Reload logs its outcome
+				_, _ = s.Reload()
+			}
+		}
+	}()
+}
+
+// Loads the options again and applies them. See ApplyOptions
+func (s *ReplicationServer) Reload() (config.ReloadReport, error) {
+	s.reloadMutex.Lock()
+	load := s.load
+	s.reloadMutex.Unlock()
+	if load == nil {
+		return config.ReloadReport{}, fmt.Errorf("reloading is not enabled")
+	}
+
+	options, err := load()
+	if err != nil {
+		s.log.Error("could not load options", zap.Error(err))
+		return config.ReloadReport{}, err
+	}
+	return s.ApplyOptions(options)
+}
+
+/*
+*
+Applies the options that can change while the node runs:
+  - log-level, when reloading is enabled
+  - ratelimit.*
+  - contracts.refresh-interval, for registries that poll the chain
+
+Either all of them are applied or, on error, none. Changes to any other option are reported
+as requiring a restart.
+*/
+func (s *ReplicationServer) ApplyOptions(
+	options config.ServerOptions,
+) (config.ReloadReport, error) {
+	s.reloadMutex.Lock()
+	defer s.reloadMutex.Unlock()
+
+	report := config.ReloadReport{Applied: []string{}, RequiresRestart: []string{}}
+	var level zapcore.Level
+	rateLimitChanged := false
+	var registry refreshIntervalSetter
+	for _, name := range config.ChangedOptions(s.options, options) {
+		switch {
+		case name == "log-level" && s.load != nil:
+			var err error
+			if level, err = zapcore.ParseLevel(options.LogLevel); err != nil {
+				return config.ReloadReport{}, err
+			}
+		case strings.HasPrefix(name, "ratelimit."):
+			rateLimitChanged = true
+		case name == "contracts.refresh-interval":
+			setter, ok := s.nodeRegistry.(refreshIntervalSetter)
+			if !ok {
+				report.RequiresRestart = append(report.RequiresRestart, name)
+				continue
+			}
+			if options.Contracts.RefreshInterval <= 0 {
+				return config.ReloadReport{}, fmt.Errorf("refresh interval must be positive")
+			}
+			registry = setter
+		default:
+			report.RequiresRestart = append(report.RequiresRestart, name)
+			continue
+		}
+		report.Applied = append(report.Applied, name)
+	}
+
+	// The rate limiter validates its options as they are applied, so it goes first
+	if rateLimitChanged {
+		if err := s.rateLimiter.Configure(options.RateLimit); err != nil {
+			return config.ReloadReport{}, err
+		}
+		s.options.RateLimit = options.RateLimit
+	}
+	if registry != nil {
+		if err := registry.SetRefreshInterval(options.Contracts.RefreshInterval); err != nil {
+			return config.ReloadReport{}, err
+		}
+		s.options.Contracts.RefreshInterval = options.Contracts.RefreshInterval
+	}
+	if s.options.LogLevel != options.LogLevel && s.load != nil {
+		s.logLevel.SetLevel(level)
+		s.options.LogLevel = options.LogLevel
+	}
+
+	log := s.log.With(
+		zap.Strings("applied", report.Applied),
+		zap.Strings("requiresRestart", report.RequiresRestart),
+	)
+	if len(report.RequiresRestart) > 0 {
+		log.Warn("reloaded options. Some changes require a restart")
+	} else {
+		log.Info("reloaded options")
+	}
+	return report, nil
+}
@@ -3,45 +3,98 @@ package server
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"net"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
+	"time"
 
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/xmtp/xmtpd/pkg/alerts"
 	"github.com/xmtp/xmtpd/pkg/api"
+	"github.com/xmtp/xmtpd/pkg/audit"
+	"github.com/xmtp/xmtpd/pkg/chainrpc"
 	"github.com/xmtp/xmtpd/pkg/config"
+	"github.com/xmtp/xmtpd/pkg/db"
 	"github.com/xmtp/xmtpd/pkg/db/queries"
+	"github.com/xmtp/xmtpd/pkg/debug"
+	"github.com/xmtp/xmtpd/pkg/envelopes"
+	"github.com/xmtp/xmtpd/pkg/health"
+	"github.com/xmtp/xmtpd/pkg/metrics"
+	"github.com/xmtp/xmtpd/pkg/overload"
+	"github.com/xmtp/xmtpd/pkg/payer"
+	"github.com/xmtp/xmtpd/pkg/ratelimit"
 	"github.com/xmtp/xmtpd/pkg/registrant"
 	"github.com/xmtp/xmtpd/pkg/registry"
+	"github.com/xmtp/xmtpd/pkg/spam"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 type ReplicationServer struct {
-	apiServer    *api.ApiServer
-	ctx          context.Context
-	cancel       context.CancelFunc
-	log          *zap.Logger
-	registrant   *registrant.Registrant
-	nodeRegistry registry.NodeRegistry
-	options      config.ServerOptions
-	writerDB     *sql.DB
+	apiServer     *api.ApiServer
+	debugServer   *debug.Server
+	healthServer  *health.Server
+	metricsServer *metrics.Server
+	ctx           context.Context
+	cancel        context.CancelFunc
+	log           *zap.Logger
+	registrant    *registrant.Registrant
+	nodeRegistry  registry.NodeRegistry
+	options       config.ServerOptions
+	rateLimiter   *ratelimit.Limiter
+	writerDB      *sql.DB
+	writerLock    *db.WriterLock
 	// Can add reader DB later if needed
+
+	// Guards options, and the fields below, while reloading
+	reloadMutex sync.Mutex
+	logLevel    zap.AtomicLevel
+	load        func() (config.ServerOptions, error)
 }
 
+// Published envelopes are checked by validators, in order, after the built-in rules
 func NewReplicationServer(
 	ctx context.Context,
 	log *zap.Logger,
 	options config.ServerOptions,
 	nodeRegistry registry.NodeRegistry,
 	writerDB *sql.DB,
-) (*ReplicationServer, error) {
-	var err error
+	validators ...envelopes.EnvelopeValidator,
+) (_ *ReplicationServer, err error) {
+	// Errors logged by any component are shown in the debug summary
+	var errorRecorder *debug.ErrorRecorder
+	if options.Debug.Port > 0 {
+		errorRecorder = debug.NewErrorRecorder()
+		log = log.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+			return zapcore.NewTee(core, errorRecorder)
+		}))
+	}
+
 	s := &ReplicationServer{
 		options:      options,
 		log:          log,
 		nodeRegistry: nodeRegistry,
 		writerDB:     writerDB,
 	}
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	defer func() {
+		// Stops whatever was started, and lets another process take over the database
+		if err != nil {
+			s.shutdown(0)
+		}
+	}()
+
+	if options.ReadOnly {
+		log.Info("Starting in read-only mode")
+	} else {
+		s.writerLock, err = db.AcquireWriterLock(s.ctx, writerDB)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	s.registrant, err = registrant.NewRegistrant(
 		ctx,
@@ -53,11 +106,151 @@ func NewReplicationServer(
 		return nil, err
 	}
 
-	s.ctx, s.cancel = context.WithCancel(ctx)
-	s.apiServer, err = api.NewAPIServer(ctx, s.writerDB, log, options.API.Port, s.registrant)
+	spamFilter, err := spam.NewFilter(log, options.Spam)
 	if err != nil {
 		return nil, err
 	}
+
+	payerVerifier, err := payer.NewVerifier(options.Payer)
+	if err != nil {
+		return nil, err
+	}
+
+	s.rateLimiter, err = ratelimit.NewLimiter(options.RateLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	overloadMonitor := overload.NewMonitor(log, options.Overload)
+	overloadMonitor.Start(s.ctx)
+	notifier := alerts.NewNotifier(log, options.Alerts)
+	notifier.Start(s.ctx)
+	auditor, err := audit.NewAuditor(log, options.Audit)
+	if err != nil {
+		return nil, err
+	}
+	auditor.Start(s.ctx)
+	db.StartStatsMonitor(s.ctx, log, writerDB, db.StatsOptions{
+		AnalyzeInterval:    options.DB.AnalyzeInterval,
+		ReportInterval:     options.DB.StatsInterval,
+		SlowQueryThreshold: options.DB.SlowQueryThreshold,
+		ExplainSlowQueries: options.DB.ExplainSlowQueries,
+	})
+
+	if options.Contracts.OperatorPrivateKey != "" {
+		if len(options.Contracts.RpcUrls) == 0 {
+			return nil, fmt.Errorf("an RPC URL is required to update the node registration")
+		}
+		// Transactions are only sent through the first RPC URL, so that nonces stay consistent
+		client, err := ethclient.DialContext(s.ctx, options.Contracts.RpcUrls[0])
+		if err != nil {
+			return nil, err
+		}
+		selfRegistrar, err := registry.NewSelfRegistrar(s.ctx, client, log, options.Contracts)
+		if err != nil {
+			return nil, err
+		}
+		if err = selfRegistrar.Start(nodeRegistry, s.registrant.NodeID()); err != nil {
+			return nil, err
+		}
+	}
+
+	s.apiServer, err = api.NewAPIServer(
+		s.ctx,
+		s.writerDB,
+		log,
+		options.API,
+		s.registrant,
+		nodeRegistry,
+		s.writerLock,
+		spamFilter,
+		payerVerifier,
+		envelopes.NewPipeline(validators...),
+		s.rateLimiter,
+		overloadMonitor,
+		auditor,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.Debug.Port > 0 {
+		s.debugServer, err = debug.NewServer(s.ctx, log, options.Debug, debug.Sources{
+			NodeID:        s.registrant.NodeID(),
+			ReadOnly:      options.ReadOnly,
+			Registry:      nodeRegistry,
+			DB:            writerDB,
+			SpamFilter:    spamFilter,
+			RateLimiter:   s.rateLimiter,
+			ErrorRecorder: errorRecorder,
+		}, s.Reload)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Losing the database also loses the writer lock, which only a restart recovers
+	liveness := []health.Check{health.DBCheck(writerDB)}
+	if s.writerLock != nil {
+		liveness = append(liveness, health.WriterLockCheck(s.writerLock))
+	}
+	readiness := []health.Check{health.DBCheck(writerDB)}
+	if refresher, ok := nodeRegistry.(health.Refresher); ok {
+		readiness = append(
+			readiness,
+			health.RegistryCheck(refresher, options.Health.MaxRegistryAge),
+		)
+	}
+	if len(options.Contracts.RpcUrls) > 0 && (options.Health.Port > 0 || notifier != nil) {
+		client, err := chainrpc.DialMultiClient(
+			s.ctx,
+			log,
+			options.Contracts.RpcUrls,
+			options.Contracts.RpcHedgeDelay,
+		)
+		if err != nil {
+			return nil, err
+		}
+		client.Start(s.ctx, options.Contracts.RpcProbeInterval)
+		readiness = append(readiness, health.ChainCheck(client))
+	}
+
+	if options.Health.Port > 0 {
+		s.healthServer, err = health.NewServer(
+			s.ctx,
+			log,
+			options.Health.Port,
+			liveness,
+			readiness,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	alerts.WatchRegistry(s.ctx, notifier, nodeRegistry, s.registrant.NodeID())
+	alerts.WatchChecks(
+		s.ctx,
+		notifier,
+		options.Alerts.CheckInterval,
+		alerts.SeverityCritical,
+		readiness,
+	)
+	alerts.WatchChecks(
+		s.ctx,
+		notifier,
+		options.Alerts.CheckInterval,
+		alerts.SeverityWarning,
+		[]health.Check{alerts.OverloadCheck(overloadMonitor)},
+	)
+
+	if options.Metrics.Port > 0 {
+		s.metricsServer, err = metrics.NewServer(s.ctx, log, options.Metrics.Port)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	log.Info("Replication server started", zap.Int("port", options.API.Port))
 	return s, nil
 }
@@ -74,8 +267,28 @@ func (s *ReplicationServer) WaitForShutdown() {
 }
 
 func (s *ReplicationServer) Shutdown() {
-	s.cancel()
+	s.shutdown(s.options.API.DrainTimeout)
+}
+
+// Stops every component that was started. A drainTimeout of 0 closes the API immediately
+func (s *ReplicationServer) shutdown(drainTimeout time.Duration) {
+	// The API drains before anything else is stopped, so in-flight requests can complete
 	if s.apiServer != nil {
-		s.apiServer.Close()
+		s.apiServer.Shutdown(drainTimeout)
+	}
+	s.cancel()
+	if s.debugServer != nil {
+		s.debugServer.Close()
+	}
+	if s.healthServer != nil {
+		s.healthServer.Close()
+	}
+	if s.metricsServer != nil {
+		s.metricsServer.Close()
+	}
+	if s.writerLock != nil {
+		if err := s.writerLock.Release(); err != nil {
+			s.log.Error("releasing writer lock", zap.Error(err))
+		}
 	}
 }
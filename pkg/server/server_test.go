@@ -5,15 +5,31 @@ import (
 	"crypto/ecdsa"
 	"database/sql"
 	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/stretchr/testify/require"
+	"github.com/xmtp/xmtpd/pkg/api"
 	"github.com/xmtp/xmtpd/pkg/config"
+	"github.com/xmtp/xmtpd/pkg/envelopes"
 	"github.com/xmtp/xmtpd/pkg/mocks"
+	"github.com/xmtp/xmtpd/pkg/proto/identity/associations"
+	"github.com/xmtp/xmtpd/pkg/proto/xmtpv4/message_api"
 	r "github.com/xmtp/xmtpd/pkg/registry"
 	s "github.com/xmtp/xmtpd/pkg/server"
 	test "github.com/xmtp/xmtpd/pkg/testing"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 )
 
 func NewTestServer(
@@ -54,3 +70,247 @@ func TestCreateServer(t *testing.T) {
 
 	require.NotEqual(t, server1.Addr(), server2.Addr())
 }
+
+func TestHTTPGateway(t *testing.T) {
+	ctx := context.Background()
+	db, _, dbCleanup := test.NewDB(t, ctx)
+	defer dbCleanup()
+	privateKey, err := crypto.GenerateKey()
+	require.NoError(t, err)
+
+	// Reserve a free port for the gateway
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	httpPort := listener.Addr().(*net.TCPAddr).Port
+	require.NoError(t, listener.Close())
+
+	server, err := s.NewReplicationServer(ctx, test.NewLog(t), config.ServerOptions{
+		PrivateKeyString: hex.EncodeToString(crypto.FromECDSA(privateKey)),
+		API: config.ApiOptions{
+			Port:     0,
+			HTTPPort: httpPort,
+		},
+	}, r.NewFixedNodeRegistry([]r.Node{{NodeID: 1, SigningKey: &privateKey.PublicKey}}), db)
+	require.NoError(t, err)
+	defer server.Shutdown()
+
+	resp, err := http.Post(
+		fmt.Sprintf("http://127.0.0.1:%d/mls/v2/query-envelopes", httpPort),
+		"application/json",
+		strings.NewReader(`{"query":{"originatorId":1}}`),
+	)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestCustomValidator(t *testing.T) {
+	ctx := context.Background()
+	db, _, dbCleanup := test.NewDB(t, ctx)
+	defer dbCleanup()
+	privateKey, err := crypto.GenerateKey()
+	require.NoError(t, err)
+
+	rejectTopic := envelopes.ValidatorFunc(func(clientEnv *message_api.ClientEnvelope) error {
+		if string(clientEnv.GetAad().GetTargetTopic()) == "rejected" {
+			return status.Error(codes.PermissionDenied, "topic is not allowed")
+		}
+		return nil
+	})
+	server, err := s.NewReplicationServer(
+		ctx,
+		test.NewLog(t),
+		config.ServerOptions{
+			PrivateKeyString: hex.EncodeToString(crypto.FromECDSA(privateKey)),
+		},
+		r.NewFixedNodeRegistry([]r.Node{{NodeID: 1, SigningKey: &privateKey.PublicKey}}),
+		db,
+		rejectTopic,
+	)
+	require.NoError(t, err)
+	defer server.Shutdown()
+
+	conn, err := grpc.NewClient(
+		fmt.Sprintf("127.0.0.1:%d", server.Addr().(*net.TCPAddr).Port),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	defer conn.Close()
+	client := message_api.NewReplicationApiClient(conn)
+
+	publish := func(topic string) error {
+		clientEnvBytes, err := proto.Marshal(&message_api.ClientEnvelope{
+			Aad: &message_api.AuthenticatedData{
+				TargetOriginator: 1,
+				TargetTopic:      []byte(topic),
+			},
+		})
+		require.NoError(t, err)
+		_, err = client.PublishEnvelope(ctx, &message_api.PublishEnvelopeRequest{
+			PayerEnvelope: &message_api.PayerEnvelope{
+				UnsignedClientEnvelope: clientEnvBytes,
+				PayerSignature:         &associations.RecoverableEcdsaSignature{},
+			},
+		})
+		return err
+	}
+
+	require.NoError(t, publish("allowed"))
+	err = publish("rejected")
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	require.ErrorContains(t, err, "topic is not allowed")
+}
+
+func TestFailedStartStopsServer(t *testing.T) {
+	ctx := context.Background()
+	db, _, dbCleanup := test.NewDB(t, ctx)
+	defer dbCleanup()
+	privateKey, err := crypto.GenerateKey()
+	require.NoError(t, err)
+
+	// Reserve a free port for the API, and hold another so the health server can't bind it
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	apiPort := listener.Addr().(*net.TCPAddr).Port
+	require.NoError(t, listener.Close())
+	healthListener, err := net.Listen("tcp", "0.0.0.0:0")
+	require.NoError(t, err)
+	defer healthListener.Close()
+
+	options := config.ServerOptions{
+		PrivateKeyString: hex.EncodeToString(crypto.FromECDSA(privateKey)),
+		API:              config.ApiOptions{Port: apiPort},
+		Health:           config.HealthOptions{Port: healthListener.Addr().(*net.TCPAddr).Port},
+	}
+	registry := r.NewFixedNodeRegistry([]r.Node{{NodeID: 1, SigningKey: &privateKey.PublicKey}})
+	_, err = s.NewReplicationServer(ctx, test.NewLog(t), options, registry, db)
+	require.Error(t, err)
+
+	// The API port and the writer lock are free for the next attempt
+	options.Health.Port = 0
+	server, err := s.NewReplicationServer(ctx, test.NewLog(t), options, registry, db)
+	require.NoError(t, err)
+	server.Shutdown()
+}
+
+func TestReadOnlyServerSharesDatabase(t *testing.T) {
+	ctx := context.Background()
+	db, _, dbCleanup := test.NewDB(t, ctx)
+	defer dbCleanup()
+	privateKey, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	registry := r.NewFixedNodeRegistry([]r.Node{{NodeID: 1, SigningKey: &privateKey.PublicKey}})
+	options := config.ServerOptions{
+		PrivateKeyString: hex.EncodeToString(crypto.FromECDSA(privateKey)),
+	}
+
+	writer, err := s.NewReplicationServer(ctx, test.NewLog(t), options, registry, db)
+	require.NoError(t, err)
+	defer writer.Shutdown()
+
+	_, err = s.NewReplicationServer(ctx, test.NewLog(t), options, registry, db)
+	require.ErrorContains(t, err, "writer lock")
+
+	options.ReadOnly = true
+	reader, err := s.NewReplicationServer(ctx, test.NewLog(t), options, registry, db)
+	require.NoError(t, err)
+	defer reader.Shutdown()
+}
+
+func TestSignedQueryResponses(t *testing.T) {
+	ctx := context.Background()
+	db, _, dbCleanup := test.NewDB(t, ctx)
+	defer dbCleanup()
+	privateKey, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	registry := r.NewFixedNodeRegistry([]r.Node{{NodeID: 1, SigningKey: &privateKey.PublicKey}})
+
+	server, err := s.NewReplicationServer(ctx, test.NewLog(t), config.ServerOptions{
+		PrivateKeyString: hex.EncodeToString(crypto.FromECDSA(privateKey)),
+		API:              config.ApiOptions{SignResponses: true},
+	}, registry, db)
+	require.NoError(t, err)
+	defer server.Shutdown()
+
+	conn, err := grpc.NewClient(
+		fmt.Sprintf("127.0.0.1:%d", server.Addr().(*net.TCPAddr).Port),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	req := &message_api.QueryEnvelopesRequest{
+		Query: &message_api.EnvelopesQuery{
+			Filter: &message_api.EnvelopesQuery_OriginatorId{OriginatorId: 1},
+		},
+	}
+	var header metadata.MD
+	resp, err := message_api.NewReplicationApiClient(conn).
+		QueryEnvelopes(ctx, req, grpc.Header(&header))
+	require.NoError(t, err)
+
+	nodeID, err := api.VerifyQueryResponse(registry, header, req, resp)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, nodeID)
+
+	// A response altered in transit no longer matches the signature
+	resp.Envelopes = append(resp.Envelopes, &message_api.GatewayEnvelope{GatewaySid: 1})
+	_, err = api.VerifyQueryResponse(registry, header, req, resp)
+	require.ErrorContains(t, err, "does not match")
+}
+
+func TestReloadOptions(t *testing.T) {
+	ctx := context.Background()
+	db, _, dbCleanup := test.NewDB(t, ctx)
+	defer dbCleanup()
+	privateKey, err := crypto.GenerateKey()
+	require.NoError(t, err)
+
+	options := config.ServerOptions{
+		LogLevel:         "INFO",
+		PrivateKeyString: hex.EncodeToString(crypto.FromECDSA(privateKey)),
+	}
+	server, err := s.NewReplicationServer(
+		ctx,
+		test.NewLog(t),
+		options,
+		r.NewFixedNodeRegistry([]r.Node{{NodeID: 1, SigningKey: &privateKey.PublicKey}}),
+		db,
+	)
+	require.NoError(t, err)
+	defer server.Shutdown()
+
+	_, err = server.Reload()
+	require.ErrorContains(t, err, "not enabled")
+
+	level := zap.NewAtomicLevelAt(zap.InfoLevel)
+	reloaded := options
+	server.EnableReload(level, func() (config.ServerOptions, error) {
+		return reloaded, nil
+	})
+
+	// Invalid options are not applied at all
+	reloaded.LogLevel = "DEBUG"
+	reloaded.RateLimit.Allowlist = []string{"not-an-ip"}
+	_, err = server.Reload()
+	require.ErrorContains(t, err, "invalid rate limit allowlist entry")
+	require.Equal(t, zap.InfoLevel, level.Level())
+
+	reloaded.RateLimit.Allowlist = []string{"10.0.0.1"}
+	reloaded.API.Port = 1234
+	reloaded.Contracts.RefreshInterval = time.Minute
+	report, err := server.Reload()
+	require.NoError(t, err)
+	require.Equal(t, config.ReloadReport{
+		Applied: []string{"log-level", "ratelimit.allow"},
+		// The fixed registry does not poll, so only a restart can change its interval
+		RequiresRestart: []string{"api.port", "contracts.refresh-interval"},
+	}, report)
+	require.Equal(t, zap.DebugLevel, level.Level())
+
+	// Options that require a restart are reported until the node restarts
+	report, err = server.Reload()
+	require.NoError(t, err)
+	require.Empty(t, report.Applied)
+	require.Equal(t, []string{"api.port", "contracts.refresh-interval"}, report.RequiresRestart)
+}
@@ -0,0 +1,168 @@
+// Package spam scores published envelopes against a set of heuristics, so that abusive
+// traffic can be labeled (shadow mode) or rejected (enforce mode).
+package spam
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xmtp/xmtpd/pkg/config"
+	"github.com/xmtp/xmtpd/pkg/metrics"
+	"github.com/xmtp/xmtpd/pkg/utils"
+	"go.uber.org/zap"
+)
+
+const (
+	ModeOff     = "off"
+	ModeShadow  = "shadow"
+	ModeEnforce = "enforce"
+)
+
+// A Heuristic inspects a single envelope and reports whether it looks like spam
+type Heuristic interface {
+	Name() string
+	Check(topic []byte, payload []byte, now time.Time) bool
+}
+
+type Verdict struct {
+	// Names of the heuristics that were triggered
+	Labels []string
+}
+
+func (v Verdict) IsSpam() bool {
+	return len(v.Labels) > 0
+}
+
+type Filter struct {
+	log        *zap.Logger
+	clock      utils.Clock
+	mode       string
+	heuristics []Heuristic
+	// Number of envelopes that triggered each heuristic, by heuristic name
+	hits map[string]*atomic.Uint64
+}
+
+func NewFilter(log *zap.Logger, options config.SpamOptions) (*Filter, error) {
+	switch options.Mode {
+	case "", ModeOff, ModeShadow, ModeEnforce:
+	default:
+		return nil, fmt.Errorf("invalid spam filter mode %q", options.Mode)
+	}
+
+	heuristics := []Heuristic{}
+	if options.TopicRateLimit > 0 {
+		heuristics = append(
+			heuristics,
+			newTopicRateHeuristic(options.TopicRateLimit, options.Window),
+		)
+	}
+	if options.MinEntropy > 0 {
+		heuristics = append(
+			heuristics,
+			newEntropyHeuristic(options.MinEntropy, options.MinEntropyPayloadSize),
+		)
+	}
+	if options.DuplicateTopicLimit > 0 {
+		heuristics = append(
+			heuristics,
+			newDuplicateContentHeuristic(options.DuplicateTopicLimit, options.Window),
+		)
+	}
+
+	return newFilter(log, utils.RealClock{}, options.Mode, heuristics), nil
+}
+
+func newFilter(
+	log *zap.Logger,
+	clock utils.Clock,
+	mode string,
+	heuristics []Heuristic,
+) *Filter {
+	if mode == "" {
+		mode = ModeOff
+	}
+	hits := make(map[string]*atomic.Uint64, len(heuristics))
+	for _, h := range heuristics {
+		hits[h.Name()] = &atomic.Uint64{}
+	}
+	return &Filter{
+		log:        log.Named("spam"),
+		clock:      clock,
+		mode:       mode,
+		heuristics: heuristics,
+		hits:       hits,
+	}
+}
+
+func (f *Filter) Mode() string {
+	return f.mode
+}
+
+// Runs every heuristic against the envelope. In shadow mode the verdict is only logged and
+// counted; callers should reject the envelope only if Enforced() is true.
+func (f *Filter) Check(topic []byte, payload []byte) Verdict {
+	verdict := Verdict{}
+	if f.mode == ModeOff {
+		return verdict
+	}
+
+	now := f.clock.Now()
+	for _, h := range f.heuristics {
+		if h.Check(topic, payload, now) {
+			verdict.Labels = append(verdict.Labels, h.Name())
+			f.hits[h.Name()].Add(1)
+			metrics.EmitSpamHit(h.Name())
+		}
+	}
+
+	if verdict.IsSpam() {
+		f.log.Info(
+			"Envelope labeled as spam",
+			zap.String("mode", f.mode),
+			zap.Binary("topic", topic),
+			zap.Strings("labels", verdict.Labels),
+		)
+	}
+	return verdict
+}
+
+func (f *Filter) Enforced() bool {
+	return f.mode == ModeEnforce
+}
+
+// Returns the number of envelopes that triggered each heuristic
+func (f *Filter) Hits() map[string]uint64 {
+	hits := make(map[string]uint64, len(f.hits))
+	for name, counter := range f.hits {
+		hits[name] = counter.Load()
+	}
+	return hits
+}
+
+// Counts occurrences of keys in fixed windows. Counts reset at the start of each window,
+// which bounds memory to the number of distinct keys seen in one window.
+type windowCounter[K comparable, V any] struct {
+	mutex       sync.Mutex
+	window      time.Duration
+	windowStart time.Time
+	values      map[K]V
+}
+
+func newWindowCounter[K comparable, V any](window time.Duration) *windowCounter[K, V] {
+	return &windowCounter[K, V]{window: window, values: make(map[K]V)}
+}
+
+// Applies update to the value stored for key in the current window and returns the result
+func (w *windowCounter[K, V]) update(key K, now time.Time, update func(V) V) V {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if now.Sub(w.windowStart) >= w.window {
+		w.windowStart = now
+		w.values = make(map[K]V)
+	}
+	value := update(w.values[key])
+	w.values[key] = value
+	return value
+}
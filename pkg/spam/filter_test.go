@@ -0,0 +1,94 @@
+package spam
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xmtp/xmtpd/pkg/config"
+	test "github.com/xmtp/xmtpd/pkg/testing"
+)
+
+func TestTopicRate(t *testing.T) {
+	h := newTopicRateHeuristic(2, time.Minute)
+	now := time.Now()
+
+	require.False(t, h.Check([]byte("topicA"), nil, now))
+	require.False(t, h.Check([]byte("topicA"), nil, now))
+	require.True(t, h.Check([]byte("topicA"), nil, now))
+	require.False(t, h.Check([]byte("topicB"), nil, now))
+
+	// Counts reset in the next window
+	require.False(t, h.Check([]byte("topicA"), nil, now.Add(time.Minute)))
+}
+
+func TestEntropy(t *testing.T) {
+	h := newEntropyHeuristic(3, 256)
+	now := time.Now()
+
+	require.True(t, h.Check(nil, bytes.Repeat([]byte("a"), 1024), now))
+	require.False(t, h.Check(nil, test.RandomBytes(1024), now))
+	// Too small to check
+	require.False(t, h.Check(nil, bytes.Repeat([]byte("a"), 10), now))
+}
+
+func TestShannonEntropy(t *testing.T) {
+	require.Equal(t, 0.0, shannonEntropy(nil))
+	require.Equal(t, 0.0, shannonEntropy([]byte("aaaa")))
+	require.Equal(t, 1.0, shannonEntropy([]byte("abab")))
+	require.Equal(t, 2.0, shannonEntropy([]byte("abcd")))
+}
+
+func TestDuplicateContent(t *testing.T) {
+	h := newDuplicateContentHeuristic(2, time.Minute)
+	now := time.Now()
+	payload := []byte("payload")
+
+	require.False(t, h.Check([]byte("topicA"), payload, now))
+	// Repeated publishes to the same topic are not duplicates across topics
+	require.False(t, h.Check([]byte("topicA"), payload, now))
+	require.False(t, h.Check([]byte("topicB"), payload, now))
+	require.True(t, h.Check([]byte("topicC"), payload, now))
+	require.False(t, h.Check([]byte("topicC"), []byte("other"), now))
+}
+
+func TestFilterModes(t *testing.T) {
+	options := config.SpamOptions{
+		TopicRateLimit: 1,
+		Window:         time.Minute,
+	}
+	for _, mode := range []string{ModeOff, ModeShadow, ModeEnforce} {
+		options.Mode = mode
+		filter, err := NewFilter(test.NewLog(t), options)
+		require.NoError(t, err)
+
+		require.False(t, filter.Check([]byte("topic"), nil).IsSpam())
+		verdict := filter.Check([]byte("topic"), nil)
+		if mode == ModeOff {
+			require.False(t, verdict.IsSpam())
+			continue
+		}
+		require.Equal(t, []string{"topic_rate"}, verdict.Labels)
+		require.Equal(t, mode == ModeEnforce, filter.Enforced())
+		require.Equal(t, uint64(1), filter.Hits()["topic_rate"])
+	}
+}
+
+func TestFilterInvalidMode(t *testing.T) {
+	_, err := NewFilter(test.NewLog(t), config.SpamOptions{Mode: "block"})
+	require.ErrorContains(t, err, "invalid spam filter mode")
+}
+
+func TestFilterWindowExpiry(t *testing.T) {
+	clock := test.NewFakeClock()
+	filter := newFilter(test.NewLog(t), clock, ModeEnforce, []Heuristic{
+		newTopicRateHeuristic(1, time.Minute),
+	})
+
+	require.False(t, filter.Check([]byte("topic"), nil).IsSpam())
+	require.True(t, filter.Check([]byte("topic"), nil).IsSpam())
+
+	clock.Advance(time.Minute)
+	require.False(t, filter.Check([]byte("topic"), nil).IsSpam())
+}
@@ -0,0 +1,111 @@
+package spam
+
+import (
+	"crypto/sha256"
+	"math"
+	"time"
+)
+
+// Triggers when a topic receives more than limit envelopes within a window
+type topicRateHeuristic struct {
+	limit  int
+	counts *windowCounter[string, int]
+}
+
+func newTopicRateHeuristic(limit int, window time.Duration) *topicRateHeuristic {
+	return &topicRateHeuristic{
+		limit:  limit,
+		counts: newWindowCounter[string, int](window),
+	}
+}
+
+func (h *topicRateHeuristic) Name() string {
+	return "topic_rate"
+}
+
+func (h *topicRateHeuristic) Check(topic []byte, payload []byte, now time.Time) bool {
+	count := h.counts.update(string(topic), now, func(count int) int {
+		return count + 1
+	})
+	return count > h.limit
+}
+
+// Payloads are expected to be encrypted, and therefore close to 8 bits of entropy per byte.
+// Triggers on large payloads with low entropy, such as padding or repeated plaintext.
+type entropyHeuristic struct {
+	minEntropy     float64
+	minPayloadSize int
+}
+
+func newEntropyHeuristic(minEntropy float64, minPayloadSize int) *entropyHeuristic {
+	return &entropyHeuristic{minEntropy: minEntropy, minPayloadSize: minPayloadSize}
+}
+
+func (h *entropyHeuristic) Name() string {
+	return "low_entropy"
+}
+
+func (h *entropyHeuristic) Check(topic []byte, payload []byte, now time.Time) bool {
+	// Entropy estimates of small payloads are unreliable
+	if len(payload) < h.minPayloadSize {
+		return false
+	}
+	return shannonEntropy(payload) < h.minEntropy
+}
+
+// Returns the Shannon entropy of data, in bits per byte
+func shannonEntropy(data []byte) float64 {
+	if len(data) == 0 {
+		return 0
+	}
+	var counts [256]int
+	for _, b := range data {
+		counts[b]++
+	}
+	entropy := 0.0
+	for _, count := range counts {
+		if count == 0 {
+			continue
+		}
+		p := float64(count) / float64(len(data))
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+// Triggers when identical content is published to more than limit topics within a window
+type duplicateContentHeuristic struct {
+	limit  int
+	topics *windowCounter[[sha256.Size]byte, map[string]bool]
+}
+
+func newDuplicateContentHeuristic(limit int, window time.Duration) *duplicateContentHeuristic {
+	return &duplicateContentHeuristic{
+		limit:  limit,
+		topics: newWindowCounter[[sha256.Size]byte, map[string]bool](window),
+	}
+}
+
+func (h *duplicateContentHeuristic) Name() string {
+	return "duplicate_content"
+}
+
+func (h *duplicateContentHeuristic) Check(topic []byte, payload []byte, now time.Time) bool {
+	exceeded := false
+	h.topics.update(
+		sha256.Sum256(payload),
+		now,
+		func(topics map[string]bool) map[string]bool {
+			if topics == nil {
+				topics = make(map[string]bool)
+			}
+			// Stop tracking new topics once over the limit to bound memory
+			if len(topics) <= h.limit {
+				topics[string(topic)] = true
+			}
+			exceeded = len(topics) > h.limit
+			return topics
+		},
+	)
+	return exceeded
+}